@@ -0,0 +1,155 @@
+// Package api serves a Peer over gRPC, as bluetalk.proto defines, for
+// services and companion apps that integrate with a BlueTalk node rather
+// than drive it from a terminal.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative bluetalk.proto
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"bluetalk/peer"
+	"bluetalk/transport"
+)
+
+// eventBuffer is how many events a StreamEvents client may fall behind by
+// before it misses some.
+const eventBuffer = 64
+
+// Server implements the BlueTalk service on a Peer, which its owner runs.
+type Server struct {
+	UnimplementedBlueTalkServer
+	peer *peer.Peer
+}
+
+// NewServer returns a Server for p. Register it on a grpc.Server with
+// RegisterBlueTalkServer.
+func NewServer(p *peer.Peer) *Server {
+	return &Server{peer: p}
+}
+
+func (s *Server) SendMessage(ctx context.Context, req *SendMessageRequest) (*SendMessageResponse, error) {
+	if req.GetText() == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+	d := s.peer.SendMessage(req.GetText())
+	if req.GetWait() {
+		select {
+		case <-d.Done():
+		case <-ctx.Done():
+			d.Cancel()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	if err := d.Err(); err != nil {
+		return nil, statusError(err)
+	}
+	return &SendMessageResponse{Id: d.ID}, nil
+}
+
+func (s *Server) StreamEvents(req *StreamEventsRequest, stream BlueTalk_StreamEventsServer) error {
+	events, cancel := s.peer.Subscribe(eventBuffer)
+	defer cancel()
+	for {
+		select {
+		case ev := <-events:
+			e := eventMessage(ev)
+			if e.Status == "" && e.Event == nil {
+				continue
+			}
+			if err := stream.Send(e); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+	}
+}
+
+func (s *Server) ListPeers(ctx context.Context, req *ListPeersRequest) (*ListPeersResponse, error) {
+	info, connected := s.peer.Info()
+	var resp ListPeersResponse
+	for _, n := range s.peer.Nearby() {
+		resp.Peers = append(resp.Peers, &NearbyPeer{
+			Address:     n.Address,
+			Name:        n.Name,
+			Fingerprint: n.Fingerprint,
+			Rssi:        int32(n.RSSI),
+			LastSeen:    timestamppb.New(n.LastSeen),
+			Connected:   connected && n.Address == info.Address,
+		})
+	}
+	return &resp, nil
+}
+
+func (s *Server) Connect(ctx context.Context, req *ConnectRequest) (*ConnectResponse, error) {
+	if req.GetAddress() == "" {
+		return nil, status.Error(codes.InvalidArgument, "no address")
+	}
+	if err := s.peer.Connect(ctx, req.GetAddress()); err != nil {
+		return nil, statusError(err)
+	}
+	return &ConnectResponse{}, nil
+}
+
+func (s *Server) Disconnect(ctx context.Context, req *DisconnectRequest) (*DisconnectResponse, error) {
+	if err := s.peer.Disconnect(); err != nil {
+		return nil, statusError(err)
+	}
+	return &DisconnectResponse{}, nil
+}
+
+// eventMessage converts a Peer event for the wire. Events with neither a
+// status line nor a message of their own come out empty, and are not sent.
+func eventMessage(ev peer.PeerEvent) *Event {
+	e := &Event{Time: timestamppb.New(time.Now()), Status: ev.Status()}
+	switch ev := ev.(type) {
+	case peer.MessageReceived:
+		e.Event = &Event_MessageReceived{MessageReceived: &MessageReceived{Peer: ev.Peer, Name: ev.Name, Id: ev.ID, Text: ev.Text}}
+	case peer.MessageDelivered:
+		e.Event = &Event_MessageDelivered{MessageDelivered: &MessageDelivered{Id: ev.ID}}
+	case peer.Connected:
+		e.Event = &Event_Connected{Connected: &Connected{Peer: ev.Peer, Central: ev.Central}}
+	case peer.Disconnected:
+		e.Event = &Event_Disconnected{Disconnected: &Disconnected{Peer: ev.Peer, Reason: ev.Reason}}
+	case peer.PeerFound:
+		e.Event = &Event_PeerFound{PeerFound: &PeerFound{Address: ev.Address, Name: ev.Name, Rssi: int32(ev.RSSI)}}
+	case peer.PeerLost:
+		e.Event = &Event_PeerLost{PeerLost: &PeerLost{Address: ev.Address, Name: ev.Name}}
+	case peer.Typing:
+		e.Event = &Event_Typing{Typing: &Typing{Peer: ev.Peer, Active: ev.Active}}
+	case peer.Error:
+		msg := ""
+		if ev.Err != nil {
+			msg = ev.Err.Error()
+		}
+		e.Event = &Event_Error{Error: &Error{Op: ev.Op, Message: msg}}
+	}
+	return e
+}
+
+// statusError gives err the gRPC code a client can act on.
+func statusError(err error) error {
+	code := codes.Unavailable
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	case errors.Is(err, peer.ErrNotConnected):
+		code = codes.FailedPrecondition
+	case errors.Is(err, peer.ErrNotAllowed), errors.Is(err, peer.ErrUnauthorized):
+		code = codes.PermissionDenied
+	case errors.Is(err, peer.ErrUnsupported):
+		code = codes.Unimplemented
+	case errors.Is(err, peer.ErrInvalidAddress), errors.Is(err, transport.ErrTooLarge):
+		code = codes.InvalidArgument
+	case errors.Is(err, transport.ErrTimeout), errors.Is(err, transport.ErrExpired):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
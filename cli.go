@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"bluetalk/api"
+	"bluetalk/peer"
+	"bluetalk/transport"
+)
+
+const usage = `usage: bluetalk [command] [flags]
+
+Commands:
+  peer    find a peer or wait for one, whichever comes first (the default)
+  host    only advertise, and chat with whoever connects
+  client  only scan, and connect to the first peer found
+  scan    list the peers in range and exit
+  daemon  run without a UI, serving the gRPC API in api/bluetalk.proto
+
+Flags:
+`
+
+// logLevel is how much the Peer's own status is shown.
+type logLevel int
+
+const (
+	// levelError shows only failures, levelInfo every status line and
+	// levelDebug the quiet events as well.
+	levelError logLevel = iota
+	levelInfo
+	levelDebug
+)
+
+func (l logLevel) String() string {
+	return [...]string{"error", "info", "debug"}[l]
+}
+
+// eventLine is the line to show for ev at level l besides the Peer's status
+// lines, which only levelInfo and up show: failures at levelError, and the
+// events with no status line at levelDebug.
+func (l logLevel) eventLine(ev peer.PeerEvent) (string, bool) {
+	switch {
+	case l == levelError && ev.Status() != "":
+		_, failed := ev.(peer.Error)
+		return ev.Status(), failed
+	case l == levelDebug && ev.Status() == "":
+		return fmt.Sprintf("%T %+v", ev, ev), true
+	}
+	return "", false
+}
+
+func (l *logLevel) Set(s string) error {
+	for _, level := range []logLevel{levelError, levelInfo, levelDebug} {
+		if strings.EqualFold(s, level.String()) {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q, want error, info or debug", s)
+}
+
+// options are the command line. Flags left out fall back to the
+// environment: $BLUETALK_CONFIG, $BLUETALK_ADAPTER, $BLUETALK_NAME,
+// $BLUETALK_ROOM, $BLUETALK_PLAIN, $BLUETALK_WEB and $BLUETALK_DOWNLOADS.
+type options struct {
+	command string
+
+	// role is what the command asks of the Peer, and setRole whether
+	// it asked at all; with no command the config file decides.
+	role    peer.Role
+	setRole bool
+
+	config    string
+	adapter   string
+	downloads string
+	name      string
+	room      string
+	logLevel  logLevel
+	plain     bool
+	web       string
+	yes       bool
+	duration  time.Duration
+	listen    string
+	exposeAPI bool
+	exposeWeb bool
+}
+
+func parseArgs(args []string, stderr io.Writer) (options, error) {
+	opts := options{command: "peer", logLevel: levelInfo}
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		opts.command, args = args[0], args[1:]
+		switch opts.command {
+		case "peer":
+			opts.role, opts.setRole = peer.RoleBoth, true
+		case "host":
+			opts.role, opts.setRole = peer.RoleHost, true
+		case "client":
+			opts.role, opts.setRole = peer.RoleClient, true
+		case "daemon":
+			opts.role, opts.setRole = peer.RoleBoth, true
+		case "scan":
+		default:
+			return opts, fmt.Errorf("unknown command %q, see bluetalk -h", opts.command)
+		}
+	}
+
+	fs := flag.NewFlagSet("bluetalk "+opts.command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.config, "config", os.Getenv("BLUETALK_CONFIG"), "config file `path` (default: the user config directory)")
+	fs.StringVar(&opts.adapter, "adapter", os.Getenv("BLUETALK_ADAPTER"), "Bluetooth adapter: an ID like hci1, its index, or its address")
+	fs.StringVar(&opts.downloads, "downloads", os.Getenv("BLUETALK_DOWNLOADS"), "`dir` received files are saved to (default: bluetalk/downloads in the user data directory)")
+	fs.StringVar(&opts.name, "name", os.Getenv("BLUETALK_NAME"), "name to announce (default: the host name)")
+	fs.StringVar(&opts.room, "room", os.Getenv("BLUETALK_ROOM"), "only meet peers in this room")
+	fs.Var(&opts.logLevel, "log-level", "`level` of status shown: error, info or debug")
+	fs.BoolVar(&opts.plain, "plain", os.Getenv("BLUETALK_PLAIN") != "", "print lines instead of the full-screen UI")
+	fs.StringVar(&opts.web, "web", os.Getenv("BLUETALK_WEB"), "chat from a browser instead, at http://`addr`/ (such as localhost:8080); the page has no authentication, so only loopback addresses are allowed without -expose-web")
+	fs.BoolVar(&opts.yes, "yes", false, "accept pairing requests and incoming files without asking")
+	fs.DurationVar(&opts.duration, "duration", 10*time.Second, "how long scan listens")
+	fs.StringVar(&opts.listen, "listen", "localhost:7447", "`addr` the daemon serves its API on; the API has no authentication or TLS, so only loopback addresses are allowed without -expose-api")
+	fs.BoolVar(&opts.exposeAPI, "expose-api", false, "let -listen be an address other machines can reach, handing anyone who connects your chats, pairings and files")
+	fs.BoolVar(&opts.exposeWeb, "expose-web", false, "let -web be an address other machines can reach, handing anyone who connects your chats and files")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return opts, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if opts.name == "" {
+		opts.name = "BlueTalk"
+		if host, err := os.Hostname(); err == nil {
+			opts.name = host
+		}
+	}
+	return opts, nil
+}
+
+func main() {
+	opts, err := parseArgs(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bluetalk:", err)
+		os.Exit(2)
+	}
+	switch opts.command {
+	case "scan":
+		os.Exit(scan(opts))
+	case "daemon":
+		os.Exit(daemon(opts))
+	}
+	chat(opts)
+}
+
+// scan runs `bluetalk scan`: it prints the peers heard within the scan
+// duration one per line, as tab-separated address, signal strength, name
+// and identity fingerprint.
+func scan(opts options) int {
+	p := peer.NewPeer(nil, nil, nil)
+	cfg, err := loadPeerConfig(opts)
+	if err != nil && opts.logLevel >= levelInfo {
+		fmt.Fprintf(os.Stderr, "Using the default configuration: %v\n", err)
+	}
+	if err := p.Configure(cfg); err != nil {
+		fmt.Fprintln(os.Stderr, "bluetalk:", err)
+		return 1
+	}
+	if opts.room != "" {
+		p.SetRoom(opts.room)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	context.AfterFunc(ctx, p.Stop)
+
+	nearby, err := p.Scan(opts.duration)
+	p.Stop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bluetalk:", err)
+		return 1
+	}
+	for _, n := range nearby {
+		fmt.Printf("%s\t%d\t%s\t%s\n", n.Address, n.RSSI, n.Name, n.Fingerprint)
+	}
+	return 0
+}
+
+// checkExposed reports whether the listen address addr can be reached from
+// other machines, and refuses it unless allow, set by flag, says so: neither
+// the API nor the web frontend has any authentication.
+func checkExposed(addr string, allow bool, flag string) (exposed bool, err error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false, err
+	}
+	if loopbackHost(host) {
+		return false, nil
+	}
+	if !allow {
+		return true, fmt.Errorf("refusing to listen on %s: there is no authentication, so anyone who can reach it could read and send chat; listen on localhost, or pass %s to do it anyway", addr, flag)
+	}
+	return true, nil
+}
+
+// loopbackHost reports whether host, from a listen address or a Host header
+// with the port removed, only names this machine: localhost or a loopback
+// IP. An empty host, which listens everywhere, does not.
+func loopbackHost(host string) bool {
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// daemon runs `bluetalk daemon`: the peer with no frontend, serving the
+// gRPC API on the listen address until interrupted. Status goes to stderr,
+// as the log level allows.
+func daemon(opts options) int {
+	exposed, err := checkExposed(opts.listen, opts.exposeAPI, "-expose-api")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bluetalk:", err)
+		return 2
+	}
+	ln, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bluetalk:", err)
+		return 1
+	}
+	logf := func(line string) { fmt.Fprintf(os.Stderr, "%s %s\n", time.Now().Format(time.DateTime), line) }
+	if exposed {
+		logf(fmt.Sprintf("WARNING: the API on %s has no authentication or TLS: anyone who can reach it can read and send chat as you", ln.Addr()))
+	}
+
+	status := make(chan string, 32)
+	p := newPeer(opts, nil, nil, status, logf)
+	if opts.yes {
+		p.SetPairingHandler(peer.PairingHandler{
+			ConfirmPasskey: func(string, uint32) bool { return true },
+		})
+		p.SetFileHandler(transport.FileHandler{
+			Accept: func(transport.FileOffer) bool { return true },
+		})
+	}
+	events, _ := p.Subscribe(64)
+
+	srv := grpc.NewServer()
+	api.RegisterBlueTalkServer(srv, api.NewServer(p))
+	go srv.Serve(ln)
+	go p.Run()
+	logf(fmt.Sprintf("Serving the BlueTalk API on %s", ln.Addr()))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	for {
+		select {
+		case line := <-status:
+			if opts.logLevel >= levelInfo {
+				logf(line)
+			}
+		case ev := <-events:
+			if line, ok := opts.logLevel.eventLine(ev); ok {
+				logf(line)
+			}
+		case <-ctx.Done():
+			srv.Stop()
+			p.Stop()
+			return 0
+		}
+	}
+}
@@ -2,43 +2,449 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/ed25519"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"bluetalk/peer"
+	"bluetalk/transport"
 )
 
-func main() {
+const (
+	// pairingPromptTimeout bounds how long a pairing confirmation waits for
+	// input.
+	pairingPromptTimeout = 30 * time.Second
+
+	// filePromptTimeout bounds how long an incoming file offer waits for
+	// input.
+	filePromptTimeout = time.Minute
+
+	// chatMessageTTL is how long a typed message waits for an absent peer
+	// before it is dropped as stale.
+	chatMessageTTL = 2 * time.Minute
+)
+
+// frontend is how the chat is shown and typed into.
+type frontend interface {
+	// input hands every line typed to handle until the user is done.
+	input(handle func(text string))
+	message(name, text string)
+	status(text string)
+	close()
+}
+
+// lineUI is the plain frontend: lines are printed as they come and read
+// from stdin. It is used when stdout is not a terminal or -plain is set.
+type lineUI struct{}
+
+func (lineUI) input(handle func(text string)) {
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("You: ")
+		if !scanner.Scan() {
+			return
+		}
+		handle(scanner.Text())
+	}
+}
+
+func (lineUI) message(name, text string) { fmt.Printf("\r\033[K[%s]: %s\n", name, text) }
+func (lineUI) status(text string)        { fmt.Printf("\r\033[K[System]: %s\n", text) }
+func (lineUI) close()                    {}
+
+// newFrontend starts the web frontend if opts ask for it, otherwise the
+// full-screen TUI, or the line frontend where there is no terminal for it.
+func newFrontend(p *peer.Peer, opts options) frontend {
+	if opts.web != "" {
+		w, err := newWebUI(p, opts.web, opts.exposeWeb)
+		if err == nil {
+			fmt.Printf("--- BlueTalk: chat at http://%s/ ---\n", w.addr)
+			if w.exposed {
+				fmt.Printf("WARNING: the web UI on %s has no authentication or TLS: anyone who can reach it can read and send chat as you\n", w.addr)
+			}
+			return w
+		}
+		fmt.Printf("Web UI unavailable: %v\n", err)
+	}
+	if !opts.plain {
+		if t, err := newTUI(p); err == nil {
+			return t
+		}
+	}
 	fmt.Println("--- BlueTalk: Robust P2P Chat ---")
 	fmt.Println("State: Initializing BLE stack...")
+	return lineUI{}
+}
 
+// chat runs the peer, host and client commands: it finds a peer as opts
+// say and chats with it until the user quits.
+func chat(opts options) {
 	sendChan := make(chan string, 32)
 	recvChan := make(chan string, 32)
 	statusChan := make(chan string, 32)
+	// peerStatus carries the Peer's own status lines, which the log level
+	// filters; statusChan is for the prompts and replies to commands.
+	peerStatus := make(chan string, 32)
 
-	peer := NewPeer(sendChan, recvChan, statusChan)
-	go peer.Run()
+	// pendingPrompt holds the answer channel of an open prompt; the next
+	// input line answers it instead of being sent.
+	var pendingPrompt atomic.Pointer[chan string]
+	prompt := func(question, timedOut string, timeout time.Duration) (string, bool) {
+		answer := make(chan string, 1)
+		pendingPrompt.Store(&answer)
+		defer pendingPrompt.CompareAndSwap(&answer, nil)
+
+		statusChan <- question
+		select {
+		case text := <-answer:
+			return text, true
+		case <-time.After(timeout):
+			statusChan <- timedOut
+			return "", false
+		}
+	}
+	ask := func(question, timedOut string, timeout time.Duration) bool {
+		if opts.yes {
+			statusChan <- question + " yes"
+			return true
+		}
+		text, ok := prompt(question+" [y/n]", timedOut, timeout)
+		return ok && (strings.EqualFold(text, "y") || strings.EqualFold(text, "yes"))
+	}
+
+	p := newPeer(opts, sendChan, recvChan, peerStatus, func(note string) { statusChan <- note })
+	// peerName is how the connected peer asked to be shown.
+	var peerName atomic.Pointer[string]
+	p.OnIdentity(func(addr string, id transport.Identity) {
+		peerName.Store(&id.Name)
+		statusChan <- fmt.Sprintf("%s is %s (%s)", addr, id.Name, id.Fingerprint())
+	})
+	p.SetPairingHandler(peer.PairingHandler{
+		ConfirmPasskey: func(device string, passkey uint32) bool {
+			return ask(fmt.Sprintf("Pair with %s using code %06d?", device, passkey),
+				"Pairing request timed out", pairingPromptTimeout)
+		},
+		DisplayPasskey: func(device string, passkey uint32) {
+			statusChan <- fmt.Sprintf("Enter code %06d on %s to pair", passkey, device)
+		},
+		RequestPasskey: func(device string) (uint32, bool) {
+			text, ok := prompt(fmt.Sprintf("Type the code shown on %s to pair:", device),
+				"Pairing request timed out", pairingPromptTimeout)
+			if !ok {
+				return 0, false
+			}
+			passkey, err := strconv.ParseUint(text, 10, 32)
+			return uint32(passkey), err == nil && passkey <= 999999
+		},
+	})
+	p.SetFileHandler(transport.FileHandler{
+		Accept: func(offer transport.FileOffer) bool {
+			return ask(fmt.Sprintf("Receive %s (%d bytes)?", offer.Name, offer.Size),
+				"File offer timed out", filePromptTimeout)
+		},
+		Progress: progressReporter(statusChan, "Receiving"),
+		Done: func(offer transport.FileOffer, path string, err error) {
+			if err != nil {
+				statusChan <- fmt.Sprintf("Receiving %s failed: %v", offer.Name, err)
+				return
+			}
+			statusChan <- fmt.Sprintf("Saved %s", path)
+		},
+	})
+	var lastBars atomic.Int32
+	lastBars.Store(-1)
+	p.OnLinkQuality(func(q transport.LinkQuality) {
+		if lastBars.Swap(int32(q.Bars)) != int32(q.Bars) {
+			statusChan <- fmt.Sprintf("Link quality: %d/4 (round trip %s)", q.Bars, q.RTT.Round(time.Millisecond))
+		}
+	})
+	// Interrupting, closing stdin or quitting the TUI shuts the peer down
+	// cleanly, so the connected peer sees us leave at once.
+	ctx, quit := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer quit()
+
+	ui := newFrontend(p, opts)
+	events, _ := p.Subscribe(64)
+	go p.Run()
 
 	go func() {
-		scanner := bufio.NewScanner(os.Stdin)
-		for {
-			fmt.Print("You: ")
-			if !scanner.Scan() {
+		ui.input(func(text string) {
+			text = strings.TrimSpace(text)
+			if answer := pendingPrompt.Swap(nil); answer != nil {
+				*answer <- text
 				return
 			}
-			text := strings.TrimSpace(scanner.Text())
 			if text == "" {
-				continue
+				return
 			}
-			sendChan <- text
-		}
+			if path, ok := strings.CutPrefix(text, "/send "); ok {
+				go sendFile(p, statusChan, strings.TrimSpace(path))
+				return
+			}
+			switch {
+			case text == "/nearby":
+				listNearby(p, statusChan)
+			case text == "/stats":
+				showStats(p, statusChan)
+			case accessCommand(p, statusChan, text) || presenceCommand(p, statusChan, text):
+			default:
+				sendChan <- text
+			}
+		})
+		quit()
 	}()
 
 	for {
 		select {
 		case msg := <-recvChan:
-			fmt.Printf("\r\033[K[Peer]: %s\n", msg)
+			name := "Peer"
+			if n := peerName.Load(); n != nil && *n != "" {
+				name = *n
+			}
+			ui.message(name, msg)
 		case status := <-statusChan:
-			fmt.Printf("\r\033[K[System]: %s\n", status)
+			ui.status(status)
+		case status := <-peerStatus:
+			if opts.logLevel >= levelInfo {
+				ui.status(status)
+			}
+		case ev := <-events:
+			if line, ok := opts.logLevel.eventLine(ev); ok {
+				ui.status(line)
+			}
+		case <-ctx.Done():
+			ui.close()
+			fmt.Println("\r\033[KShutting down...")
+			p.Stop()
+			return
+		}
+	}
+}
+
+// newPeer returns the Peer the chat and daemon commands run, set up from
+// opts and the user's files. What could not be set up is passed to note.
+func newPeer(opts options, send, recv, status chan string, note func(string)) *peer.Peer {
+	p := peer.NewPeer(send, recv, status)
+	cfg, err := loadPeerConfig(opts)
+	if err != nil {
+		note(fmt.Sprintf("Using the default configuration: %v", err))
+	}
+	if err := p.Configure(cfg); err != nil {
+		note(fmt.Sprintf("Using the default configuration: %v", err))
+	}
+	p.SetEncryption(true)
+	p.SetCompression(true)
+	p.SetReadReceipts(true)
+	p.SetMessageTTL(chatMessageTTL)
+	p.SetConnectionParams(peer.LowLatencyConnectionParams())
+	if key, err := loadIdentity(); err != nil {
+		note(fmt.Sprintf("No identity, peers will only see our address: %v", err))
+	} else {
+		p.SetIdentity(key, opts.name)
+	}
+	p.SetNickname(opts.name)
+	if opts.room != "" {
+		p.SetRoom(opts.room)
+		note(fmt.Sprintf("Joining room %q", opts.room))
+	}
+	if path, err := peer.DefaultLastPeerPath(); err == nil {
+		policy := peer.DefaultReconnectPolicy()
+		policy.Path = path
+		if err := p.SetReconnectPolicy(policy); err != nil {
+			note(fmt.Sprintf("Not reconnecting to the last peer: %v", err))
+		}
+	}
+	if known, err := loadKnownPeers(); err != nil {
+		note(fmt.Sprintf("Peer keys will not be pinned: %v", err))
+	} else {
+		p.SetTrustPolicy(peer.TrustPolicy{Known: known})
+	}
+	return p
+}
+
+func loadIdentity() (ed25519.PrivateKey, error) {
+	path, err := peer.DefaultIdentityPath()
+	if err != nil {
+		return nil, err
+	}
+	return peer.LoadIdentity(path)
+}
+
+// loadPeerConfig reads the config file opts name, or the default one, and
+// applies the command line over it. A file that cannot be used gives the
+// defaults, returned along with the error.
+func loadPeerConfig(opts options) (peer.PeerConfig, error) {
+	cfg := peer.DefaultPeerConfig()
+	path, err := opts.config, error(nil)
+	if path == "" {
+		path, err = peer.DefaultPeerConfigPath()
+	}
+	if err == nil {
+		var loaded peer.PeerConfig
+		if loaded, err = peer.LoadPeerConfig(path); err == nil {
+			cfg = loaded
+		}
+	}
+	if opts.adapter != "" {
+		cfg.Adapter = opts.adapter
+	}
+	if opts.downloads != "" {
+		cfg.DownloadDir = opts.downloads
+	}
+	if opts.setRole {
+		cfg.Role = opts.role
+	}
+	return cfg, err
+}
+
+func loadKnownPeers() (*peer.KnownPeers, error) {
+	path, err := peer.DefaultKnownPeersPath()
+	if err != nil {
+		return nil, err
+	}
+	return peer.LoadKnownPeers(path)
+}
+
+// accessCommand runs /block, /unblock, /allow and /disallow, which take a
+// peer address or identity fingerprint, and reports whether text was one.
+func accessCommand(p *peer.Peer, statusChan chan<- string, text string) bool {
+	cmd, entry, _ := strings.Cut(text, " ")
+	entry = strings.TrimSpace(entry)
+	var apply func(string)
+	switch cmd {
+	case "/block":
+		apply = p.Block
+	case "/unblock":
+		apply = p.Unblock
+	case "/allow":
+		apply = p.Allow
+	case "/disallow":
+		apply = p.Disallow
+	default:
+		return false
+	}
+	if entry == "" {
+		statusChan <- fmt.Sprintf("Usage: %s <address or fingerprint>", cmd)
+		return true
+	}
+	go func() {
+		apply(entry)
+		list := p.AccessList()
+		statusChan <- fmt.Sprintf("Allowed: %v, blocked: %v", list.Allow, list.Block)
+	}()
+	return true
+}
+
+// presenceCommand runs /status, which sets our status to online, away or
+// busy, and /seen, which lists the peers seen and when; it reports whether
+// text was one of them.
+func presenceCommand(p *peer.Peer, statusChan chan<- string, text string) bool {
+	cmd, arg, _ := strings.Cut(text, " ")
+	switch cmd {
+	case "/status":
+		statuses := map[string]transport.PresenceStatus{
+			"online": transport.PresenceOnline,
+			"away":   transport.PresenceAway,
+			"busy":   transport.PresenceBusy,
+		}
+		status, ok := statuses[strings.ToLower(strings.TrimSpace(arg))]
+		if !ok {
+			statusChan <- "Usage: /status online|away|busy"
+			return true
+		}
+		p.SetPresence(status)
+		statusChan <- fmt.Sprintf("You are %s", status)
+	case "/seen":
+		seen := p.Presence()
+		if len(seen) == 0 {
+			statusChan <- "No peers seen yet"
+		}
+		for _, pp := range seen {
+			state := "last seen " + time.Since(pp.LastSeen).Round(time.Second).String() + " ago"
+			if pp.Online {
+				state = pp.Status.String()
+			}
+			statusChan <- fmt.Sprintf("%s: %s", pp.Label(), state)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// listNearby runs /nearby, which lists the devices discovery has seen
+// recently and how long ago.
+func listNearby(p *peer.Peer, statusChan chan<- string) {
+	nearby := p.Nearby()
+	if len(nearby) == 0 {
+		statusChan <- "No peers nearby"
+	}
+	for _, n := range nearby {
+		line := fmt.Sprintf("%s (%s): %d dBm, seen %s ago", n.Name, n.Address, n.RSSI, n.Age.Round(time.Second))
+		if n.Fingerprint != "" {
+			line += ", identity " + n.Fingerprint
+		}
+		statusChan <- line
+	}
+}
+
+// showStats runs /stats, which describes the connection and what has been
+// dropped.
+func showStats(p *peer.Peer, statusChan chan<- string) {
+	if info, ok := p.Info(); !ok {
+		statusChan <- "Not connected"
+	} else {
+		role := "central"
+		if !info.Central {
+			role = "peripheral"
+		}
+		statusChan <- fmt.Sprintf("Connected to %s (%s) as %s for %s", info.Name, info.Address, role, info.Uptime().Round(time.Second))
+		if info.Identity != "" {
+			statusChan <- "Identity " + info.Identity
+		}
+		link := fmt.Sprintf("Protocol v%d, MTU %d", info.Version, info.MTU)
+		if info.Encrypted {
+			link += ", encrypted"
+		}
+		if info.RSSI != 0 {
+			link += fmt.Sprintf(", %d dBm", info.RSSI)
+		}
+		statusChan <- link
+	}
+	drops := p.DropStats()
+	statusChan <- fmt.Sprintf("Dropped %d messages and %d status lines", drops.Messages, drops.Status)
+}
+
+func sendFile(p *peer.Peer, statusChan chan<- string, path string) {
+	progress := progressReporter(statusChan, "Sending")
+	err := p.SendFile(path, func(sent, total int64) {
+		progress(transport.FileOffer{Name: path, Size: total}, sent)
+	})
+	if err != nil {
+		statusChan <- fmt.Sprintf("Sending %s failed: %v", path, err)
+		return
+	}
+	statusChan <- fmt.Sprintf("Sent %s", path)
+}
+
+// progressReporter returns a progress callback that reports every quarter of
+// a transfer rather than every chunk.
+func progressReporter(statusChan chan<- string, verb string) func(transport.FileOffer, int64) {
+	var last atomic.Int64
+	return func(offer transport.FileOffer, done int64) {
+		if offer.Size == 0 {
+			return
+		}
+		quarter := done * 4 / offer.Size
+		if prev := last.Swap(quarter); quarter > prev && quarter < 4 {
+			statusChan <- fmt.Sprintf("%s %s: %d%%", verb, offer.Name, quarter*25)
 		}
 	}
 }
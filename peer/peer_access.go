@@ -0,0 +1,155 @@
+package peer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// identifyTimeout is the default for how long a peer that only an identity
+// can admit has to send one before it is disconnected; see PeerConfig.
+const identifyTimeout = 15 * time.Second
+
+// AccessList restricts which peers the Peer talks to. Entries are BLE
+// addresses or identity fingerprints (see transport.Identity.Fingerprint).
+// A peer matching Block is never connected to. When Allow is non-empty,
+// only peers matching it are; one allowed only by fingerprint is connected
+// to and dropped if it does not prove that identity.
+type AccessList struct {
+	Allow []string
+	Block []string
+}
+
+// SetAccessList replaces the allowlist and blocklist. The connected peer is
+// not re-checked; see Block for that.
+func (p *Peer) SetAccessList(list AccessList) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.access = AccessList{Allow: slices.Clone(list.Allow), Block: slices.Clone(list.Block)}
+}
+
+// AccessList returns the current allowlist and blocklist.
+func (p *Peer) AccessList() AccessList {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return AccessList{Allow: slices.Clone(p.access.Allow), Block: slices.Clone(p.access.Block)}
+}
+
+// Block adds an address or fingerprint to the blocklist and disconnects
+// the connected peer if it matches.
+func (p *Peer) Block(entry string) {
+	p.mu.Lock()
+	if !containsEntry(p.access.Block, entry) {
+		p.access.Block = append(p.access.Block, entry)
+	}
+	id := p.linkID
+	p.mu.Unlock()
+
+	if id != "" && !p.admitsIdentity(id) {
+		p.handleDisconnect(fmt.Sprintf("Disconnected from %s: blocked", id))
+	}
+}
+
+// Unblock removes an entry from the blocklist.
+func (p *Peer) Unblock(entry string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.access.Block = slices.DeleteFunc(p.access.Block, func(e string) bool { return sameEntry(e, entry) })
+}
+
+// Allow adds an address or fingerprint to the allowlist. The first entry
+// turns the allowlist on.
+func (p *Peer) Allow(entry string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !containsEntry(p.access.Allow, entry) {
+		p.access.Allow = append(p.access.Allow, entry)
+	}
+}
+
+// Disallow removes an entry from the allowlist. Removing the last one turns
+// the allowlist off.
+func (p *Peer) Disallow(entry string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.access.Allow = slices.DeleteFunc(p.access.Allow, func(e string) bool { return sameEntry(e, entry) })
+}
+
+func (p *Peer) currentAccessList() AccessList {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.access
+}
+
+// admitsAddress reports whether a device may be connected to, as far as its
+// address tells.
+func (a AccessList) admitsAddress(addr string) bool {
+	if containsEntry(a.Block, addr) {
+		return false
+	}
+	if len(a.Allow) == 0 || containsEntry(a.Allow, addr) {
+		return true
+	}
+	return slices.ContainsFunc(a.Allow, isFingerprint)
+}
+
+// blocksFingerprint reports whether identity fingerprint fp, as a device
+// advertises it, is blocked. An empty fp is not.
+func (a AccessList) blocksFingerprint(fp string) bool {
+	return fp != "" && containsEntry(a.Block, fp)
+}
+
+// needsIdentity reports whether the peer at addr can only stay connected by
+// proving an allowed identity.
+func (a AccessList) needsIdentity(addr string) bool {
+	return len(a.Allow) > 0 && !containsEntry(a.Allow, addr)
+}
+
+// admitsPeer reports whether the peer at addr with identity fingerprint fp,
+// empty if unknown, may stay connected.
+func (a AccessList) admitsPeer(addr, fp string) bool {
+	if containsEntry(a.Block, addr) || (fp != "" && containsEntry(a.Block, fp)) {
+		return false
+	}
+	return len(a.Allow) == 0 || containsEntry(a.Allow, addr) || (fp != "" && containsEntry(a.Allow, fp))
+}
+
+// admitsIdentity checks the peer connected as addr against the access list,
+// with the identity it proved if it has.
+func (p *Peer) admitsIdentity(addr string) bool {
+	fp := ""
+	if id, ok := p.transport.PeerIdentity(addr); ok {
+		fp = id.Fingerprint()
+	}
+	return p.currentAccessList().admitsPeer(addr, fp)
+}
+
+// requireIdentity disconnects link if its peer has not proved an allowed
+// identity within identifyTimeout.
+func (p *Peer) requireIdentity(link *bleLink, addr string) {
+	if !p.pause(p.currentConfig().IdentifyTimeout) || p.link.Load() != link {
+		return
+	}
+	if _, ok := p.transport.PeerIdentity(addr); !ok {
+		p.handleDisconnect(fmt.Sprintf("Disconnected from %s: not on the allowlist and did not identify itself", addr))
+	}
+}
+
+func containsEntry(list []string, entry string) bool {
+	return slices.ContainsFunc(list, func(e string) bool { return sameEntry(e, entry) })
+}
+
+// sameEntry compares access list entries; addresses and fingerprints are
+// case-insensitive.
+func sameEntry(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// isFingerprint reports whether entry is an identity fingerprint rather
+// than an address.
+func isFingerprint(entry string) bool {
+	b, err := hex.DecodeString(entry)
+	return err == nil && len(b) == 8
+}
@@ -0,0 +1,331 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+	"tinygo.org/x/bluetooth"
+)
+
+// Minimum BlueZ releases for the optional features BlueTalk uses.
+const (
+	minBlueZAdvMonitor    = "5.56"
+	minBlueZConnectDevice = "5.49"
+)
+
+// bluezCapabilities records what the running bluetoothd offers. Features are
+// detected by introspecting the adapter rather than from the version string,
+// since several of them are hidden unless bluetoothd runs with --experimental.
+type bluezCapabilities struct {
+	Version              string
+	AdvertisementMonitor bool
+	ConnectDevice        bool
+}
+
+// bluezVersion asks bluetoothd for its version. It returns "" when the daemon
+// binary is not found in the usual locations.
+func bluezVersion() string {
+	for _, path := range []string{"bluetoothd", "/usr/libexec/bluetooth/bluetoothd", "/usr/lib/bluetooth/bluetoothd"} {
+		out, err := exec.Command(path, "--version").Output()
+		if err == nil {
+			return strings.TrimSpace(string(out))
+		}
+	}
+	return ""
+}
+
+func detectBlueZCapabilities() (bluezCapabilities, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return bluezCapabilities{}, fmt.Errorf("system bus: %w", err)
+	}
+
+	node, err := introspect.Call(conn.Object("org.bluez", adapterPath()))
+	if err != nil {
+		return bluezCapabilities{}, fmt.Errorf("introspect adapter: %w", mapPlatformError(err))
+	}
+
+	caps := bluezCapabilities{Version: bluezVersion()}
+	for _, iface := range node.Interfaces {
+		switch iface.Name {
+		case "org.bluez.AdvertisementMonitorManager1":
+			caps.AdvertisementMonitor = true
+		case "org.bluez.Adapter1":
+			for _, m := range iface.Methods {
+				if m.Name == "ConnectDevice" {
+					caps.ConnectDevice = true
+				}
+			}
+		}
+	}
+	return caps, nil
+}
+
+// requireBlueZ returns ErrUnsupported, naming the minimum BlueZ release, when
+// a feature is missing from the running daemon.
+func requireBlueZ(feature string, available bool, minVersion, running string) error {
+	if available {
+		return nil
+	}
+	if running == "" {
+		running = "unknown"
+	}
+	return fmt.Errorf("%s requires BlueZ >= %s (running %s; experimental features may need bluetoothd --experimental): %w",
+		feature, minVersion, running, ErrUnsupported)
+}
+
+// defaultAdapterID matches the controller tinygo's DefaultAdapter uses.
+const defaultAdapterID = "hci0"
+
+// adapterID is the controller the peer uses, set by selectAdapter along with
+// adapter before anything touches the radio.
+var adapterID = defaultAdapterID
+
+// selectAdapter points adapter and adapterPath at the controller spec names:
+// an ID like hci1, its index alone, or its address. An empty spec keeps the
+// default.
+func selectAdapter(spec string) error {
+	if spec == "" {
+		return nil
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err = conn.Object("org.bluez", "/").
+		Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects)
+	if err != nil {
+		return fmt.Errorf("list adapters: %w", mapPlatformError(err))
+	}
+
+	want := spec
+	if _, err := strconv.Atoi(spec); err == nil {
+		want = "hci" + spec
+	}
+	var ids []string
+	for path, ifaces := range objects {
+		props, ok := ifaces["org.bluez.Adapter1"]
+		if !ok {
+			continue
+		}
+		id := string(path[strings.LastIndexByte(string(path), '/')+1:])
+		addr, _ := props["Address"].Value().(string)
+		if id == want || strings.EqualFold(addr, spec) {
+			adapterID = id
+			adapter = bluetooth.NewAdapter(id)
+			return nil
+		}
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return fmt.Errorf("no adapter %q (have %s): %w", spec, strings.Join(ids, ", "), ErrNoAdapter)
+}
+
+func adapterPath() dbus.ObjectPath {
+	return dbus.ObjectPath("/org/bluez/" + adapterID)
+}
+
+// devicePath returns the BlueZ object path for a remote address.
+func devicePath(addr string) dbus.ObjectPath {
+	return adapterPath() + dbus.ObjectPath("/dev_"+strings.ReplaceAll(strings.ToUpper(addr), ":", "_"))
+}
+
+// adapterInfo reads the controller's properties from org.bluez.Adapter1.
+func (p *Peer) adapterInfo() (AdapterInfo, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return AdapterInfo{}, fmt.Errorf("system bus: %w", err)
+	}
+
+	var props map[string]dbus.Variant
+	err = conn.Object("org.bluez", adapterPath()).
+		Call("org.freedesktop.DBus.Properties.GetAll", 0, "org.bluez.Adapter1").Store(&props)
+	if err != nil {
+		return AdapterInfo{}, fmt.Errorf("read adapter properties: %w", mapPlatformError(err))
+	}
+
+	var info AdapterInfo
+	for key, dst := range map[string]*string{
+		"Address":     &info.Address,
+		"AddressType": &info.AddressType,
+		"Name":        &info.Name,
+		"Modalias":    &info.Modalias,
+	} {
+		if v, ok := props[key]; ok {
+			*dst, _ = v.Value().(string)
+		}
+	}
+	return info, nil
+}
+
+// bondedDevices lists the Device1 objects under the adapter that are paired
+// or bonded.
+func (p *Peer) bondedDevices() ([]BondedDevice, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("system bus: %w", err)
+	}
+
+	var objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+	err = conn.Object("org.bluez", "/").
+		Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objects)
+	if err != nil {
+		return nil, fmt.Errorf("list devices: %w", mapPlatformError(err))
+	}
+
+	var devices []BondedDevice
+	for _, ifaces := range objects {
+		props, ok := ifaces["org.bluez.Device1"]
+		if !ok {
+			continue
+		}
+		if owner, _ := props["Adapter"].Value().(dbus.ObjectPath); owner != adapterPath() {
+			continue
+		}
+		dev := BondedDevice{}
+		dev.Address, _ = props["Address"].Value().(string)
+		dev.Name, _ = props["Alias"].Value().(string)
+		dev.Paired, _ = props["Paired"].Value().(bool)
+		dev.Bonded, _ = props["Bonded"].Value().(bool)
+		if dev.Paired || dev.Bonded {
+			devices = append(devices, dev)
+		}
+	}
+	slices.SortFunc(devices, func(a, b BondedDevice) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	return devices, nil
+}
+
+// forget removes the device, and with it any bond, from the adapter.
+func (p *Peer) forget(addr string) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+
+	err = conn.Object("org.bluez", adapterPath()).
+		Call("org.bluez.Adapter1.RemoveDevice", 0, devicePath(addr)).Err
+	if err != nil {
+		return fmt.Errorf("forget %s: %w", addr, mapPlatformError(err))
+	}
+	return nil
+}
+
+// pair runs Device1.Pair, which blocks while the pairing agent prompts the
+// user. A device that is already paired is not an error.
+func (p *Peer) pair(addr string) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+
+	defer p.beginPairing(addr)()
+	err = conn.Object("org.bluez", devicePath(addr)).Call("org.bluez.Device1.Pair", 0).Err
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) && dbusErr.Name == "org.bluez.Error.AlreadyExists" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("pair with %s: %w", addr, mapPlatformError(err))
+	}
+	return nil
+}
+
+// addressType reads Device1.AddressType, "public" or "random", or returns ""
+// if BlueZ does not know the device.
+func (p *Peer) addressType(addr string) string {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return ""
+	}
+	v, err := conn.Object("org.bluez", devicePath(addr)).GetProperty("org.bluez.Device1.AddressType")
+	if err != nil {
+		return ""
+	}
+	t, _ := v.Value().(string)
+	return t
+}
+
+// prepareDirectConnect makes sure BlueZ has a device object for addr, which
+// it needs to connect without discovering the device first. BlueZ drops the
+// objects of unpaired devices soon after they go away; Adapter1.ConnectDevice
+// recreates one, and starts connecting, from the address alone.
+func (p *Peer) prepareDirectConnect(addr, addrType string) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+	if _, err := conn.Object("org.bluez", devicePath(addr)).GetProperty("org.bluez.Device1.Address"); err == nil {
+		return nil
+	}
+
+	caps, err := detectBlueZCapabilities()
+	if err != nil {
+		return err
+	}
+	if err := requireBlueZ("connecting without discovery", caps.ConnectDevice, minBlueZConnectDevice, caps.Version); err != nil {
+		return err
+	}
+	if addrType == "" {
+		addrType = "public"
+	}
+	err = conn.Object("org.bluez", adapterPath()).CallWithContext(p.ctx, "org.bluez.Adapter1.ConnectDevice", 0, map[string]dbus.Variant{
+		"Address":     dbus.MakeVariant(strings.ToUpper(addr)),
+		"AddressType": dbus.MakeVariant(addrType),
+	}).Err
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) && dbusErr.Name == "org.bluez.Error.AlreadyExists" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("connect to %s: %w", addr, mapPlatformError(err))
+	}
+	return nil
+}
+
+// readRSSI reads Device1.RSSI. BlueZ takes it from the advertisements the
+// device sends, so it is missing for a peer that stopped advertising when it
+// connected, and only current while the peer keeps advertising.
+func (p *Peer) readRSSI(addr string) (int16, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return 0, fmt.Errorf("system bus: %w", err)
+	}
+
+	v, err := conn.Object("org.bluez", devicePath(addr)).GetProperty("org.bluez.Device1.RSSI")
+	if err != nil {
+		return 0, fmt.Errorf("read RSSI of %s: %w", addr, mapPlatformError(err))
+	}
+	rssi, ok := v.Value().(int16)
+	if !ok {
+		return 0, fmt.Errorf("read RSSI of %s: unexpected type %s", addr, v.Signature())
+	}
+	return rssi, nil
+}
+
+// setPeerAlias sets Device1.Alias, which BlueZ persists and reports as the
+// device name in later scans.
+func (p *Peer) setPeerAlias(addr, alias string) error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+
+	err = conn.Object("org.bluez", devicePath(addr)).
+		Call("org.freedesktop.DBus.Properties.Set", 0, "org.bluez.Device1", "Alias", dbus.MakeVariant(alias)).Err
+	if err != nil {
+		return fmt.Errorf("set alias for %s: %w", addr, mapPlatformError(err))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+//go:build windows && !simble
+
+package peer
+
+import "fmt"
+
+// selectAdapter only takes the default: WinRT through tinygo offers no
+// other controller.
+func selectAdapter(spec string) error {
+	if spec != "" {
+		return fmt.Errorf("adapter %q: %w", spec, ErrUnsupported)
+	}
+	return nil
+}
+
+const platformReportsPower = false
+
+// radioReady cannot tell: tinygo does not expose the WinRT radio state, so
+// a radio that is off shows up as failing scans and connections.
+func radioReady() error {
+	return nil
+}
+
+// adapterInfo returns the controller address; WinRT exposes nothing else
+// through tinygo.
+func (p *Peer) adapterInfo() (AdapterInfo, error) {
+	addr, err := adapter.Address()
+	if err != nil {
+		return AdapterInfo{}, err
+	}
+	return AdapterInfo{Address: addr.String(), AddressType: "public"}, nil
+}
+
+func (p *Peer) bondedDevices() ([]BondedDevice, error) {
+	return nil, fmt.Errorf("bonded devices: %w", ErrUnsupported)
+}
+
+func (p *Peer) forget(addr string) error {
+	return fmt.Errorf("forget: %w", ErrUnsupported)
+}
+
+// pair is left to Windows, which pairs from its own dialog when a
+// characteristic needs it.
+func (p *Peer) pair(addr string) error {
+	return fmt.Errorf("pair: %w", ErrUnsupported)
+}
+
+// addressType is unknown to WinRT through tinygo.
+func (p *Peer) addressType(addr string) string {
+	return ""
+}
+
+// prepareDirectConnect has nothing to do: WinRT connects to an address it
+// has not seen advertise.
+func (p *Peer) prepareDirectConnect(addr, addrType string) error {
+	return nil
+}
+
+// readRSSI is unavailable: WinRT reports signal strength only in
+// advertisements, which tinygo does not pass on once connected.
+func (p *Peer) readRSSI(addr string) (int16, error) {
+	return 0, fmt.Errorf("read RSSI: %w", ErrUnsupported)
+}
+
+func (p *Peer) setPeerAlias(addr, alias string) error {
+	return fmt.Errorf("set alias: %w", ErrUnsupported)
+}
+
+// servePeripheral is Linux-only for now; a Windows peer only connects out.
+func (p *Peer) servePeripheral() error {
+	return ErrUnsupported
+}
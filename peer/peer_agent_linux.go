@@ -0,0 +1,195 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	agentPath  = dbus.ObjectPath("/org/bluetalk/agent")
+	agentIface = "org.bluez.Agent1"
+)
+
+var errAgentRejected = dbus.NewError("org.bluez.Error.Rejected", []any{"rejected by user"})
+
+// pairingAgent implements org.bluez.Agent1 and forwards each request to the
+// peer's PairingHandler. It only answers for pairings BlueTalk started
+// itself, through pair; anything else, and service authorisation for any
+// UUID but the BlueTalk service, is rejected. Requests the handler does not
+// cover are accepted (Just Works) or, when they need typed input, rejected.
+type pairingAgent struct {
+	peer *Peer
+
+	mu        sync.Mutex
+	initiated map[string]int
+}
+
+// beginPairing marks addr as being paired by us until the returned func is
+// called, so the agent will answer its prompts.
+func (a *pairingAgent) beginPairing(addr string) (end func()) {
+	addr = strings.ToUpper(addr)
+	a.mu.Lock()
+	a.initiated[addr]++
+	a.mu.Unlock()
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if a.initiated[addr]--; a.initiated[addr] <= 0 {
+			delete(a.initiated, addr)
+		}
+	}
+}
+
+// ours reports whether device is one we are pairing with.
+func (a *pairingAgent) ours(device dbus.ObjectPath) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.initiated[deviceAddress(device)] > 0
+}
+
+// registerPairingAgent exports the agent and registers it with bluetoothd.
+// It is not made the default agent, so BlueZ only sends it the prompts of
+// pairings BlueTalk starts. With a RequestPasskey handler it can take typed
+// codes; with only ConfirmPasskey it can compare them; with neither it
+// registers as NoInputNoOutput so pairing falls back to Just Works.
+func (p *Peer) registerPairingAgent() error {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+	agent := &pairingAgent{peer: p, initiated: make(map[string]int)}
+	if err := conn.Export(agent, agentPath, agentIface); err != nil {
+		return fmt.Errorf("export agent: %w", err)
+	}
+
+	capability := "NoInputNoOutput"
+	switch h := p.currentPairingHandler(); {
+	case h.RequestPasskey != nil:
+		capability = "KeyboardDisplay"
+	case h.ConfirmPasskey != nil:
+		capability = "DisplayYesNo"
+	}
+
+	manager := conn.Object("org.bluez", "/org/bluez")
+	if err := manager.Call("org.bluez.AgentManager1.RegisterAgent", 0, agentPath, capability).Err; err != nil {
+		_ = conn.Export(nil, agentPath, agentIface)
+		return fmt.Errorf("register agent: %w", mapPlatformError(err))
+	}
+	p.mu.Lock()
+	p.agent = agent
+	p.mu.Unlock()
+	return nil
+}
+
+// unregisterPairingAgent withdraws the agent registered by
+// registerPairingAgent, if any.
+func (p *Peer) unregisterPairingAgent() {
+	p.mu.Lock()
+	agent := p.agent
+	p.agent = nil
+	p.mu.Unlock()
+	if agent == nil {
+		return
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return
+	}
+	_ = conn.Object("org.bluez", "/org/bluez").Call("org.bluez.AgentManager1.UnregisterAgent", 0, agentPath).Err
+	_ = conn.Export(nil, agentPath, agentIface)
+}
+
+// beginPairing lets the agent answer the prompts for a pairing with addr
+// that we are about to start; call the returned func once it is over.
+func (p *Peer) beginPairing(addr string) (end func()) {
+	p.mu.Lock()
+	agent := p.agent
+	p.mu.Unlock()
+	if agent == nil {
+		return func() {}
+	}
+	return agent.beginPairing(addr)
+}
+
+// deviceAddress turns /org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF into
+// AA:BB:CC:DD:EE:FF.
+func deviceAddress(device dbus.ObjectPath) string {
+	s := string(device)
+	if i := strings.LastIndex(s, "/dev_"); i >= 0 {
+		s = s[i+len("/dev_"):]
+	}
+	return strings.ReplaceAll(s, "_", ":")
+}
+
+func (a *pairingAgent) Release() *dbus.Error {
+	return nil
+}
+
+func (a *pairingAgent) RequestPinCode(device dbus.ObjectPath) (string, *dbus.Error) {
+	return "", errAgentRejected
+}
+
+func (a *pairingAgent) DisplayPinCode(device dbus.ObjectPath, pincode string) *dbus.Error {
+	return nil
+}
+
+func (a *pairingAgent) RequestPasskey(device dbus.ObjectPath) (uint32, *dbus.Error) {
+	if !a.ours(device) {
+		return 0, errAgentRejected
+	}
+	if h := a.peer.currentPairingHandler(); h.RequestPasskey != nil {
+		if passkey, ok := h.RequestPasskey(deviceAddress(device)); ok {
+			return passkey, nil
+		}
+	}
+	return 0, errAgentRejected
+}
+
+func (a *pairingAgent) DisplayPasskey(device dbus.ObjectPath, passkey uint32, entered uint16) *dbus.Error {
+	if !a.ours(device) {
+		return errAgentRejected
+	}
+	if h := a.peer.currentPairingHandler(); h.DisplayPasskey != nil {
+		h.DisplayPasskey(deviceAddress(device), passkey)
+	}
+	return nil
+}
+
+func (a *pairingAgent) RequestConfirmation(device dbus.ObjectPath, passkey uint32) *dbus.Error {
+	if !a.ours(device) {
+		return errAgentRejected
+	}
+	h := a.peer.currentPairingHandler()
+	if h.ConfirmPasskey == nil || h.ConfirmPasskey(deviceAddress(device), passkey) {
+		return nil
+	}
+	return errAgentRejected
+}
+
+func (a *pairingAgent) RequestAuthorization(device dbus.ObjectPath) *dbus.Error {
+	if !a.ours(device) {
+		return errAgentRejected
+	}
+	return nil
+}
+
+func (a *pairingAgent) AuthorizeService(device dbus.ObjectPath, uuid string) *dbus.Error {
+	service := bytesToUUID(a.peer.currentConfig().ServiceUUID).String()
+	if !a.ours(device) || !strings.EqualFold(uuid, service) {
+		return errAgentRejected
+	}
+	h := a.peer.currentPairingHandler()
+	if h.AuthorizeService == nil || h.AuthorizeService(deviceAddress(device), uuid) {
+		return nil
+	}
+	return errAgentRejected
+}
+
+func (a *pairingAgent) Cancel() *dbus.Error {
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux || simble
+
+package peer
+
+// pairingAgent is Linux-only; elsewhere the Peer never has one.
+type pairingAgent struct{}
+
+// registerPairingAgent is a no-op: Windows and macOS show their own pairing
+// dialogs and give applications no hook into them.
+func (p *Peer) registerPairingAgent() error {
+	return nil
+}
+
+func (p *Peer) unregisterPairingAgent() {}
@@ -0,0 +1,323 @@
+//go:build (linux || windows) && !simble
+
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+var adapter = bluetooth.DefaultAdapter
+
+func bytesToUUID(b []byte) bluetooth.UUID {
+	var arr [16]byte
+	copy(arr[:], b)
+	return bluetooth.NewUUID(arr)
+}
+
+// parseAddress parses a peer address as reported in scan results.
+func parseAddress(s string) (bluetooth.Address, error) {
+	mac, err := bluetooth.ParseMAC(s)
+	if err != nil {
+		return bluetooth.Address{}, err
+	}
+	return bluetooth.Address{MACAddress: bluetooth.MACAddress{MAC: mac}}, nil
+}
+
+func (p *Peer) setupPlatform() error {
+	if err := selectAdapter(p.currentConfig().Adapter); err != nil {
+		return fmt.Errorf("select BLE adapter: %w", err)
+	}
+	if err := radioReady(); err != nil {
+		return err
+	}
+	if err := adapter.Enable(); err != nil {
+		return fmt.Errorf("failed to enable BLE adapter: %w", err)
+	}
+	p.publishStatus("BLE adapter enabled")
+	if err := p.servePeripheral(); err != nil {
+		p.publishStatus(fmt.Sprintf("Peripheral role unavailable: %v", err))
+	}
+	return nil
+}
+
+func advertisementOptions(data AdvertisementData, service []byte) (bluetooth.AdvertisementOptions, error) {
+	opts := bluetooth.AdvertisementOptions{
+		LocalName:    data.LocalName,
+		ServiceUUIDs: []bluetooth.UUID{bytesToUUID(service)},
+	}
+	for companyID, payload := range data.ManufacturerData {
+		opts.ManufacturerData = append(opts.ManufacturerData, bluetooth.ManufacturerDataElement{
+			CompanyID: companyID,
+			Data:      payload,
+		})
+	}
+	for uuidStr, payload := range data.ServiceData {
+		uuid, err := bluetooth.ParseUUID(uuidStr)
+		if err != nil {
+			return opts, fmt.Errorf("invalid service data UUID %q: %w", uuidStr, err)
+		}
+		opts.ServiceData = append(opts.ServiceData, bluetooth.ServiceDataElement{
+			UUID: uuid,
+			Data: payload,
+		})
+	}
+	return opts, nil
+}
+
+func (p *Peer) startAdvertising(data AdvertisementData) error {
+	if data.extended {
+		err := p.startExtendedAdvertising(data)
+		if err == nil {
+			return nil
+		}
+		p.extAdvFailed.Store(true)
+		p.publishStatus(fmt.Sprintf("Extended advertising unavailable, using legacy adverts: %v", err))
+		data = p.legacyForm(data)
+	}
+	opts, err := advertisementOptions(data, p.currentConfig().ServiceUUID)
+	if err != nil {
+		return err
+	}
+	adv := adapter.DefaultAdvertisement()
+	if err := adv.Configure(opts); err != nil {
+		return err
+	}
+	return adv.Start()
+}
+
+func (p *Peer) stopAdvertising() error {
+	p.stopExtendedAdvertising()
+	return adapter.DefaultAdvertisement().Stop()
+}
+
+func (p *Peer) startScanning(callback func(bluetooth.ScanResult)) error {
+	match := p.scanMatcher()
+	return adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
+		if match(device) {
+			callback(device)
+		}
+	})
+}
+
+func (p *Peer) stopScan() error {
+	return adapter.StopScan()
+}
+
+func (p *Peer) connectAndSubscribePlatform(ctx context.Context, addr bluetooth.Address) error {
+	params := p.connectionParams().tinygo()
+	device, err := connectDevice(ctx, addr, params)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", mapPlatformError(err))
+	}
+
+	rxChar, txChar, err := p.subscribe(device)
+	if errors.Is(err, ErrPairingRequired) {
+		p.publishStatus(fmt.Sprintf("%s requires pairing, pairing...", addr.String()))
+		if err = p.pair(addr.String()); err == nil {
+			p.publishStatus(fmt.Sprintf("Paired with %s", addr.String()))
+			rxChar, txChar, err = p.subscribe(device)
+		}
+	}
+	if err != nil {
+		_ = device.Disconnect()
+		return err
+	}
+
+	p.requestConnectionParams()
+
+	client := &CentralClient{
+		device:         device,
+		writeChar:      rxChar,
+		notifyChar:     txChar,
+		disconnectedCh: make(chan struct{}),
+	}
+
+	p.setConnectedAsCentral(client, addr.String())
+	// A client released in a role collision is no longer current, and its
+	// end must not tear down the connection that replaced it.
+	go func() {
+		<-client.Disconnected()
+		p.mu.Lock()
+		current := p.centralClient == centralConn(client)
+		p.mu.Unlock()
+		if current {
+			p.handleDisconnect(fmt.Sprintf("Disconnected from %s", addr.String()))
+		}
+	}()
+	p.emit(Connected{Peer: addr.String(), Central: true})
+	go p.reportPeerDeviceInfo(client)
+	return nil
+}
+
+// subscribe finds the BlueTalk characteristics on a connected device and
+// enables TX notifications. A peer whose characteristics need an encrypted
+// link fails with ErrPairingRequired until it is paired.
+func (p *Peer) subscribe(device bluetooth.Device) (rx, tx bluetooth.DeviceCharacteristic, err error) {
+	cfg := p.currentConfig()
+	bleSvc := bytesToUUID(cfg.ServiceUUID)
+	bleRX := bytesToUUID(cfg.RXUUID)
+	bleTX := bytesToUUID(cfg.TXUUID)
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{bleSvc})
+	if err != nil || len(services) == 0 {
+		return rx, tx, fmt.Errorf("service discovery failed: %w", mapPlatformError(err))
+	}
+	svc := services[0]
+
+	chars, err := svc.DiscoverCharacteristics([]bluetooth.UUID{bleRX, bleTX})
+	if err != nil {
+		return rx, tx, fmt.Errorf("characteristic discovery failed: %w", mapPlatformError(err))
+	}
+
+	for _, c := range chars {
+		if c.UUID() == bleRX {
+			rx = c
+		}
+		if c.UUID() == bleTX {
+			tx = c
+		}
+	}
+	if rx.UUID() != bleRX || tx.UUID() != bleTX {
+		return rx, tx, fmt.Errorf("required characteristics not found")
+	}
+
+	err = tx.EnableNotifications(func(buf []byte) {
+		p.receivePacket(buf)
+	})
+	if err != nil {
+		return rx, tx, fmt.Errorf("failed to enable notifications: %w", mapPlatformError(err))
+	}
+	return rx, tx, nil
+}
+
+type CentralClient struct {
+	device         bluetooth.Device
+	writeChar      bluetooth.DeviceCharacteristic
+	notifyChar     bluetooth.DeviceCharacteristic
+	disconnectedCh chan struct{}
+	once           sync.Once
+}
+
+func (c *CentralClient) WriteNoResponse(data []byte) error {
+	_, err := c.writeChar.WriteWithoutResponse(data)
+	if err != nil {
+		c.signalDisconnect()
+	}
+	return err
+}
+
+// MaxWriteLen returns the largest write the link currently accepts. tinygo
+// reports the ATT MTU here, so the ATT header is subtracted.
+func (c *CentralClient) MaxWriteLen() int {
+	mtu, err := c.writeChar.GetMTU()
+	if err != nil || int(mtu) <= attHeaderSize+bleMTU {
+		return bleMTU
+	}
+	return int(mtu) - attHeaderSize
+}
+
+// WriteChunked writes data of any size, splitting it into pieces that fit the
+// link's current MTU and pacing them by chunkPacing.
+func (c *CentralClient) WriteChunked(data []byte) error {
+	chunk := c.MaxWriteLen()
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		if err := c.WriteNoResponse(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if len(data) > 0 {
+			time.Sleep(chunkPacing)
+		}
+	}
+	return nil
+}
+
+// Close unsubscribes from notifications, releasing the signal watcher behind
+// them, and then disconnects the device.
+func (c *CentralClient) Close() error {
+	c.signalDisconnect()
+	_ = stopNotifications(&c.notifyChar)
+	return c.device.Disconnect()
+}
+
+// Release unsubscribes from notifications without disconnecting the device,
+// whose link the peer's connection to us shares.
+func (c *CentralClient) Release() error {
+	c.signalDisconnect()
+	return stopNotifications(&c.notifyChar)
+}
+
+func (c *CentralClient) Disconnected() <-chan struct{} {
+	return c.disconnectedCh
+}
+
+func (c *CentralClient) signalDisconnect() {
+	c.once.Do(func() { close(c.disconnectedCh) })
+}
+
+func (p *Peer) runDiscoveryAndConnection() {
+	var rest time.Duration
+	for p.ctx.Err() == nil {
+		if p.connected.Load() {
+			p.waitUntilDisconnected()
+			continue
+		}
+		if p.connectRequested() || p.reconnect() {
+			continue
+		}
+
+		role := p.currentConfig().Role
+		if role != RoleHost {
+			p.emit(ScanStarted{})
+			devices := p.scanWindows(p.currentScanWindows())
+			if p.ctx.Err() != nil {
+				return
+			}
+			if len(devices) > 0 {
+				selected := devices[0]
+				p.publishStatus(fmt.Sprintf("Connecting to %s (%s)...", selected.Name, selected.Address.String()))
+				err := p.connectWithRetry(selected.Address)
+				if err != nil && p.ctx.Err() == nil {
+					p.emit(Error{Op: "Connection failed", Err: err})
+					p.pause(connectRetryDelay(err))
+				}
+				continue
+			}
+		}
+		if role == RoleClient {
+			rest = p.restRadio(rest)
+			continue
+		}
+
+		if role == RoleHost {
+			p.publishStatus("Advertising...")
+		} else {
+			p.publishStatus("No peers found. Advertising...")
+		}
+		if err := p.advertiseFor(p.advertisePhase()); err != nil {
+			p.emit(Error{Op: "Advertising failed", Err: err})
+		}
+		if !p.connected.Load() {
+			rest = p.restRadio(rest)
+		}
+	}
+}
+
+// writePeripheral notifies the connected central of a packet. Callers hold
+// p.mu.
+func (p *Peer) writePeripheral(data []byte) (int, error) {
+	p.peripheralNotifierMu.Lock()
+	n := p.peripheralNotifier
+	p.peripheralNotifierMu.Unlock()
+	if n == nil {
+		return 0, ErrNotConnected
+	}
+	return n.Write(data)
+}
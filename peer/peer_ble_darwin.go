@@ -0,0 +1,642 @@
+//go:build darwin && !simble
+
+package peer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tinygo-org/cbgo"
+	"tinygo.org/x/bluetooth"
+)
+
+var adapter = bluetooth.DefaultAdapter
+
+// notifyTimeout bounds how long a notification waits for room in
+// CoreBluetooth's transmit queue.
+const notifyTimeout = 2 * time.Second
+
+var errNotifyQueueFull = errors.New("notification queue full")
+
+// darwinAdvState holds a dedicated PeripheralManager for advertising and the
+// peripheral role on macOS (tinygo bluetooth does not expose
+// DefaultAdvertisement on darwin).
+var darwinAdvState struct {
+	pm     cbgo.PeripheralManager
+	pmOnce sync.Once
+
+	// stateMu guards state, the manager's last reported state, and
+	// stateCh, which is closed and replaced every time it changes.
+	stateMu sync.Mutex
+	state   cbgo.ManagerState
+	stateCh chan struct{}
+
+	// svcOnce publishes the BlueTalk service once the manager is powered on;
+	// tx is its notify characteristic, and centrals write to its RX
+	// characteristic. readyCh is signalled when the transmit queue has room
+	// again after UpdateValue refused a packet.
+	svcOnce sync.Once
+	tx      cbgo.MutableCharacteristic
+	readyCh chan struct{}
+}
+
+type darwinAdvDelegate struct {
+	cbgo.PeripheralManagerDelegateBase
+	p *Peer
+}
+
+// PeripheralManagerDidUpdateState follows the radio's power state, which the
+// peripheral manager shares with the central one, and reports each change.
+// The first state is the one the manager starts in and is only reported if
+// it means Bluetooth cannot be used.
+func (d *darwinAdvDelegate) PeripheralManagerDidUpdateState(pmgr cbgo.PeripheralManager) {
+	state := pmgr.State()
+	darwinAdvState.stateMu.Lock()
+	prev := darwinAdvState.state
+	darwinAdvState.state = state
+	close(darwinAdvState.stateCh)
+	darwinAdvState.stateCh = make(chan struct{})
+	darwinAdvState.stateMu.Unlock()
+
+	if state == prev {
+		return
+	}
+	switch state {
+	case cbgo.ManagerStatePoweredOn:
+		if prev != cbgo.ManagerStateUnknown {
+			d.p.emit(PowerChanged{On: true})
+		}
+	case cbgo.ManagerStatePoweredOff:
+		d.p.emit(PowerChanged{On: false})
+	case cbgo.ManagerStateUnauthorized, cbgo.ManagerStateUnsupported:
+		d.p.emit(Error{Op: "Bluetooth unavailable", Err: managerStateErr(state)})
+	}
+}
+
+// DidStartAdvertising reports adverts CoreBluetooth refused to send, which
+// would otherwise leave the Peer invisible without a word.
+func (d *darwinAdvDelegate) DidStartAdvertising(pmgr cbgo.PeripheralManager, err error) {
+	if err != nil {
+		d.p.emit(Error{Op: "Advertising failed", Err: err})
+	}
+}
+
+func (d *darwinAdvDelegate) DidAddService(pmgr cbgo.PeripheralManager, svc cbgo.Service, err error) {
+	if err != nil {
+		d.p.publishStatus(fmt.Sprintf("Peripheral role unavailable: %v", err))
+	}
+}
+
+// DidReceiveWriteRequests hands packets the connected central writes to RX
+// to the transport. CoreBluetooth wants one response for the whole batch.
+func (d *darwinAdvDelegate) DidReceiveWriteRequests(pmgr cbgo.PeripheralManager, reqs []cbgo.ATTRequest) {
+	if len(reqs) == 0 {
+		return
+	}
+	result := cbgo.ATTErrorSuccess
+	for _, req := range reqs {
+		if !bytes.Equal(req.Characteristic().UUID(), cbgoUUID(d.p.currentConfig().RXUUID)) {
+			result = cbgo.ATTErrorWriteNotPermitted
+			continue
+		}
+		if d.p.servingCentral(req.Central().Identifier().String()) {
+			d.p.receivePacket(req.Value())
+		}
+	}
+	pmgr.RespondToRequest(reqs[0], result)
+}
+
+// CentralDidSubscribe is how a peripheral learns a central has connected:
+// CoreBluetooth reports no connection event, but a BlueTalk central
+// subscribes to TX as soon as it has found the service.
+func (d *darwinAdvDelegate) CentralDidSubscribe(pmgr cbgo.PeripheralManager, cent cbgo.Central, chr cbgo.Characteristic) {
+	if d.p.isTX(chr) {
+		go d.p.acceptCentral(cent)
+	}
+}
+
+func (d *darwinAdvDelegate) CentralDidUnsubscribe(pmgr cbgo.PeripheralManager, cent cbgo.Central, chr cbgo.Characteristic) {
+	if d.p.isTX(chr) {
+		go d.p.centralGone(cent.Identifier().String())
+	}
+}
+
+func (d *darwinAdvDelegate) IsReadyToUpdateSubscribers(pmgr cbgo.PeripheralManager) {
+	select {
+	case darwinAdvState.readyCh <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Peer) isTX(chr cbgo.Characteristic) bool {
+	return bytes.Equal(chr.UUID(), cbgoUUID(p.currentConfig().TXUUID))
+}
+
+// darwinNotifier sends packets to a subscribed central as notifications on
+// TX.
+type darwinNotifier struct {
+	central cbgo.Central
+}
+
+func (n darwinNotifier) Write(data []byte) (int, error) {
+	deadline := time.After(notifyTimeout)
+	for {
+		if darwinAdvState.pm.UpdateValue(data, darwinAdvState.tx.Characteristic(), []cbgo.Central{n.central}) {
+			return len(data), nil
+		}
+		select {
+		case <-darwinAdvState.readyCh:
+		case <-deadline:
+			return 0, errNotifyQueueFull
+		}
+	}
+}
+
+// Close does nothing: CoreBluetooth gives a peripheral no way to drop a
+// central, so the link ends when the central disconnects or unsubscribes.
+func (n darwinNotifier) Close() error {
+	return nil
+}
+
+// acceptCentral takes a connection from a central that subscribed to TX. One
+// that collides with our own connection to the same peer is settled by
+// takeIncoming.
+func (p *Peer) acceptCentral(cent cbgo.Central) {
+	id := cent.Identifier().String()
+	// CoreBluetooth gives a peripheral no way to drop a central; one that
+	// is not allowed is just never attached to the transport.
+	if !p.currentAccessList().admitsAddress(id) {
+		p.publishStatus(fmt.Sprintf("Refused connection from %s: not allowed", id))
+		return
+	}
+	if p.connected.Load() && !p.takeIncoming(id) {
+		return
+	}
+
+	if latency, ok := p.connectionParams().cbgoLatency(); ok {
+		darwinAdvState.pm.SetDesiredConnectionLatency(latency, cent)
+	}
+
+	p.peripheralNotifierMu.Lock()
+	p.peripheralNotifier = darwinNotifier{central: cent}
+	p.peripheralNotifierMu.Unlock()
+
+	p.setConnectedAsPeripheral(id, max(cent.MaximumUpdateValueLength(), bleMTU))
+	p.emit(Connected{Peer: id})
+}
+
+// publishService adds the BlueTalk service to the peripheral manager, so a
+// central that connects finds RX to write packets to and TX to subscribe to.
+func (p *Peer) publishService() {
+	cfg := p.currentConfig()
+	svc := cbgo.NewMutableService(cbgoUUID(cfg.ServiceUUID), true)
+	rx := cbgo.NewMutableCharacteristic(cbgoUUID(cfg.RXUUID),
+		cbgo.CharacteristicPropertyWrite|cbgo.CharacteristicPropertyWriteWithoutResponse, nil, cbgo.AttributePermissionsWriteable)
+	darwinAdvState.tx = cbgo.NewMutableCharacteristic(cbgoUUID(cfg.TXUUID),
+		cbgo.CharacteristicPropertyRead|cbgo.CharacteristicPropertyNotify, nil, cbgo.AttributePermissionsReadable)
+	svc.SetCharacteristics([]cbgo.MutableCharacteristic{rx, darwinAdvState.tx})
+	darwinAdvState.pm.AddService(svc)
+}
+
+func bytesToUUID(b []byte) bluetooth.UUID {
+	var arr [16]byte
+	copy(arr[:], b)
+	return bluetooth.NewUUID(arr)
+}
+
+// cbgoUUID converts one of the BlueTalk UUIDs to cbgo format.
+func cbgoUUID(b []byte) cbgo.UUID {
+	s := bytesToUUID(b).String()
+	u, err := cbgo.ParseUUID(s)
+	if err != nil {
+		panic("blueTalk UUID: " + err.Error())
+	}
+	return u
+}
+
+func (p *Peer) setupPlatform() error {
+	// CoreBluetooth only ever drives the system controller.
+	if spec := p.currentConfig().Adapter; spec != "" {
+		return fmt.Errorf("select BLE adapter %q: %w", spec, ErrUnsupported)
+	}
+	// The peripheral manager is created first so a radio that is off is
+	// reported as such rather than as the central's enable timeout.
+	p.startPeripheralManager()
+	if err := adapter.Enable(); err != nil {
+		if unavailable := radioUnavailable(); unavailable != nil {
+			err = unavailable
+		}
+		return fmt.Errorf("failed to enable BLE adapter: %w", err)
+	}
+	p.publishStatus("BLE adapter enabled")
+	return nil
+}
+
+// adapterInfo is unavailable on macOS: CoreBluetooth never reveals the
+// controller address.
+func (p *Peer) adapterInfo() (AdapterInfo, error) {
+	return AdapterInfo{}, fmt.Errorf("adapter info: %w", ErrUnsupported)
+}
+
+// Bonds are owned by the system on macOS and cannot be listed or removed.
+func (p *Peer) bondedDevices() ([]BondedDevice, error) {
+	return nil, fmt.Errorf("bonded devices: %w", ErrUnsupported)
+}
+
+func (p *Peer) forget(addr string) error {
+	return fmt.Errorf("forget: %w", ErrUnsupported)
+}
+
+// pair is left to CoreBluetooth, which pairs from its own dialog when a
+// characteristic needs it.
+func (p *Peer) pair(addr string) error {
+	return fmt.Errorf("pair: %w", ErrUnsupported)
+}
+
+// addressType is meaningless on macOS, where peers are known by identifier.
+func (p *Peer) addressType(addr string) string {
+	return ""
+}
+
+// prepareDirectConnect has nothing to do: CoreBluetooth retrieves a peer it
+// has connected to before by its identifier.
+func (p *Peer) prepareDirectConnect(addr, addrType string) error {
+	return nil
+}
+
+// parseAddress parses a peer identifier as reported in scan results.
+func parseAddress(s string) (bluetooth.Address, error) {
+	uuid, err := bluetooth.ParseUUID(s)
+	if err != nil {
+		return bluetooth.Address{}, err
+	}
+	return bluetooth.Address{UUID: uuid}, nil
+}
+
+// readRSSI is unavailable: CoreBluetooth can read a peripheral's RSSI, but
+// tinygo does not expose the CBPeripheral behind a connection, and a
+// peripheral cannot read a central's at all.
+func (p *Peer) readRSSI(addr string) (int16, error) {
+	return 0, fmt.Errorf("read RSSI: %w", ErrUnsupported)
+}
+
+func (p *Peer) setPeerAlias(addr, alias string) error {
+	return fmt.Errorf("set alias: %w", ErrUnsupported)
+}
+
+// startPeripheralManager creates the peripheral manager the first time it
+// is needed. CoreBluetooth reports its state shortly afterwards.
+func (p *Peer) startPeripheralManager() {
+	darwinAdvState.pmOnce.Do(func() {
+		darwinAdvState.stateCh = make(chan struct{})
+		darwinAdvState.readyCh = make(chan struct{}, 1)
+		darwinAdvState.pm = cbgo.NewPeripheralManager(nil)
+		darwinAdvState.pm.SetDelegate(&darwinAdvDelegate{p: p})
+	})
+}
+
+// managerStateErr says why Bluetooth cannot be used in state, or returns nil
+// if it can be or CoreBluetooth has not decided yet.
+func managerStateErr(state cbgo.ManagerState) error {
+	switch state {
+	case cbgo.ManagerStatePoweredOff:
+		return ErrPoweredOff
+	case cbgo.ManagerStateUnauthorized:
+		return ErrUnauthorized
+	case cbgo.ManagerStateUnsupported:
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// platformReportsPower is true: the peripheral manager delegate reports
+// the radio going off and on.
+const platformReportsPower = true
+
+// radioReady returns ErrPoweredOff while CoreBluetooth says the radio is
+// off.
+func radioReady() error {
+	if err := radioUnavailable(); errors.Is(err, ErrPoweredOff) {
+		return err
+	}
+	return nil
+}
+
+// radioUnavailable returns the reason Bluetooth cannot be used, if the
+// peripheral manager has said so.
+func radioUnavailable() error {
+	darwinAdvState.stateMu.Lock()
+	defer darwinAdvState.stateMu.Unlock()
+	return managerStateErr(darwinAdvState.state)
+}
+
+// waitPoweredOn waits for the peripheral manager to be powered on. It fails
+// as soon as CoreBluetooth says the radio is off or may not be used, rather
+// than waiting out the timeout.
+func (p *Peer) waitPoweredOn(timeout time.Duration) error {
+	deadline := time.After(timeout)
+	for {
+		darwinAdvState.stateMu.Lock()
+		state, changed := darwinAdvState.state, darwinAdvState.stateCh
+		darwinAdvState.stateMu.Unlock()
+		if state == cbgo.ManagerStatePoweredOn {
+			return nil
+		}
+		if err := managerStateErr(state); err != nil {
+			return err
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return fmt.Errorf("BLE peripheral manager did not become ready in time: %w", ErrNotReady)
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		}
+	}
+}
+
+// waitForRadio blocks while the peripheral manager says Bluetooth cannot be
+// used, until that changes or the Peer stops. It reports whether it waited.
+func (p *Peer) waitForRadio() bool {
+	waited := false
+	for {
+		darwinAdvState.stateMu.Lock()
+		state, changed := darwinAdvState.state, darwinAdvState.stateCh
+		darwinAdvState.stateMu.Unlock()
+		if managerStateErr(state) == nil {
+			return waited
+		}
+		waited = true
+		select {
+		case <-changed:
+		case <-p.ctx.Done():
+			return true
+		}
+	}
+}
+
+// extendedAdvertising is false: CoreBluetooth decides the advert format
+// itself and only ever sends the local name and service UUIDs.
+func (p *Peer) extendedAdvertising() bool {
+	return false
+}
+
+func (p *Peer) startAdvertising(data AdvertisementData) error {
+	p.startPeripheralManager()
+	// The peripheral manager shares the radio with the central one.
+	if err := p.waitPoweredOn(10 * time.Second); err != nil {
+		return fmt.Errorf("advertise: %w", err)
+	}
+	darwinAdvState.svcOnce.Do(p.publishService)
+
+	// CoreBluetooth only lets apps advertise a local name and service UUIDs.
+	darwinAdvState.pm.StartAdvertising(cbgo.AdvData{
+		LocalName:    data.LocalName,
+		ServiceUUIDs: []cbgo.UUID{cbgoUUID(p.currentConfig().ServiceUUID)},
+	})
+	return nil
+}
+
+func (p *Peer) stopAdvertising() error {
+	darwinAdvState.stateMu.Lock()
+	state := darwinAdvState.state
+	darwinAdvState.stateMu.Unlock()
+	if state != cbgo.ManagerStatePoweredOn {
+		return nil // not advertising
+	}
+	darwinAdvState.pm.StopAdvertising()
+	return nil
+}
+
+func (p *Peer) startScanning(callback func(bluetooth.ScanResult)) error {
+	match := p.scanMatcher()
+	return adapter.Scan(func(adapter *bluetooth.Adapter, device bluetooth.ScanResult) {
+		if match(device) {
+			callback(device)
+		}
+	})
+}
+
+func (p *Peer) stopScan() error {
+	return adapter.StopScan()
+}
+
+func (p *Peer) connectAndSubscribePlatform(ctx context.Context, addr bluetooth.Address) error {
+	params := p.connectionParams().tinygo()
+	device, err := connectDevice(ctx, addr, params)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", mapPlatformError(err))
+	}
+
+	cfg := p.currentConfig()
+	bleSvc := bytesToUUID(cfg.ServiceUUID)
+	bleRX := bytesToUUID(cfg.RXUUID)
+	bleTX := bytesToUUID(cfg.TXUUID)
+
+	services, err := device.DiscoverServices([]bluetooth.UUID{bleSvc})
+	if err != nil || len(services) == 0 {
+		_ = device.Disconnect()
+		return fmt.Errorf("service discovery failed: %w", err)
+	}
+	svc := services[0]
+
+	chars, err := svc.DiscoverCharacteristics([]bluetooth.UUID{bleRX, bleTX})
+	if err != nil {
+		_ = device.Disconnect()
+		return fmt.Errorf("characteristic discovery failed: %w", err)
+	}
+
+	var rxChar, txChar bluetooth.DeviceCharacteristic
+	for _, c := range chars {
+		if c.UUID() == bleRX {
+			rxChar = c
+		}
+		if c.UUID() == bleTX {
+			txChar = c
+		}
+	}
+	if rxChar.UUID() != bleRX || txChar.UUID() != bleTX {
+		_ = device.Disconnect()
+		return fmt.Errorf("required characteristics not found")
+	}
+
+	err = txChar.EnableNotifications(func(buf []byte) {
+		p.receivePacket(buf)
+	})
+	if err != nil {
+		_ = device.Disconnect()
+		return fmt.Errorf("failed to enable notifications: %w", mapPlatformError(err))
+	}
+
+	p.requestConnectionParams()
+
+	client := &CentralClient{
+		device:         device,
+		writeChar:      rxChar,
+		disconnectedCh: make(chan struct{}),
+	}
+
+	p.setConnectedAsCentral(client, addr.String())
+	// A client released in a role collision is no longer current, and its
+	// end must not tear down the connection that replaced it.
+	go func() {
+		<-client.Disconnected()
+		p.mu.Lock()
+		current := p.centralClient == centralConn(client)
+		p.mu.Unlock()
+		if current {
+			p.handleDisconnect(fmt.Sprintf("Disconnected from %s", addr.String()))
+		}
+	}()
+	p.emit(Connected{Peer: addr.String(), Central: true})
+	go p.reportPeerDeviceInfo(client)
+	return nil
+}
+
+type CentralClient struct {
+	device         bluetooth.Device
+	writeChar      bluetooth.DeviceCharacteristic
+	disconnectedCh chan struct{}
+	once           sync.Once
+}
+
+func (c *CentralClient) WriteNoResponse(data []byte) error {
+	_, err := c.writeChar.WriteWithoutResponse(data)
+	if err != nil {
+		c.signalDisconnect()
+	}
+	return err
+}
+
+// MaxWriteLen returns the largest write the link currently accepts.
+// CoreBluetooth already reports the usable payload, not the ATT MTU.
+func (c *CentralClient) MaxWriteLen() int {
+	n, err := c.writeChar.GetMTU()
+	if err != nil || int(n) < bleMTU {
+		return bleMTU
+	}
+	return int(n)
+}
+
+// WriteChunked writes data of any size, splitting it into pieces that fit the
+// link's current MTU and pacing them by chunkPacing.
+func (c *CentralClient) WriteChunked(data []byte) error {
+	chunk := c.MaxWriteLen()
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		if err := c.WriteNoResponse(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+		if len(data) > 0 {
+			time.Sleep(chunkPacing)
+		}
+	}
+	return nil
+}
+
+func (c *CentralClient) Close() error {
+	c.signalDisconnect()
+	return c.device.Disconnect()
+}
+
+// Release stops using the connection but leaves it up, since the peer's
+// connection to us shares its link. tinygo cannot unsubscribe on macOS; the
+// peer stops notifying once it has dropped its side.
+func (c *CentralClient) Release() error {
+	c.signalDisconnect()
+	return nil
+}
+
+func (c *CentralClient) Disconnected() <-chan struct{} {
+	return c.disconnectedCh
+}
+
+func (c *CentralClient) signalDisconnect() {
+	c.once.Do(func() { close(c.disconnectedCh) })
+}
+
+func (p *Peer) runDiscoveryAndConnection() {
+	var rest time.Duration
+	for p.ctx.Err() == nil {
+		if p.connected.Load() {
+			p.waitUntilDisconnected()
+			continue
+		}
+		// Nothing can be found or advertised with the radio off, and the
+		// change has already been reported.
+		if p.waitForRadio() {
+			continue
+		}
+		if p.connectRequested() || p.reconnect() {
+			continue
+		}
+
+		role := p.currentConfig().Role
+		if role != RoleHost {
+			p.emit(ScanStarted{})
+			devices := p.scanWindows(p.currentScanWindows())
+			if p.ctx.Err() != nil {
+				return
+			}
+			if len(devices) > 0 {
+				selected := devices[0]
+				p.publishStatus(fmt.Sprintf("Connecting to %s (%s)...", selected.Name, selected.Address.String()))
+				err := p.connectWithRetry(selected.Address)
+				if err != nil && p.ctx.Err() == nil {
+					p.emit(Error{Op: "Connection failed", Err: err})
+					p.pause(connectRetryDelay(err))
+				}
+				continue
+			}
+		}
+		if role == RoleClient {
+			rest = p.restRadio(rest)
+			continue
+		}
+
+		if role == RoleHost {
+			p.publishStatus("Advertising...")
+		} else {
+			p.publishStatus("No peers found. Advertising...")
+		}
+		if err := p.advertiseFor(p.advertisePhase()); err != nil {
+			p.emit(Error{Op: "Advertising failed", Err: err})
+		}
+		if !p.connected.Load() {
+			rest = p.restRadio(rest)
+		}
+	}
+}
+
+// writePeripheral notifies the subscribed central of a packet. Callers hold
+// p.mu.
+func (p *Peer) writePeripheral(data []byte) (int, error) {
+	p.peripheralNotifierMu.Lock()
+	n := p.peripheralNotifier
+	p.peripheralNotifierMu.Unlock()
+	if n == nil {
+		return 0, ErrNotConnected
+	}
+	return n.Write(data)
+}
+
+// cbgoLatency maps connection parameters to the closest latency preset
+// CoreBluetooth lets a peripheral ask for. ok is false when they express no
+// preference for either.
+func (c ConnectionParams) cbgoLatency() (latency cbgo.PeripheralManagerConnectionLatency, ok bool) {
+	switch {
+	case c.MaxInterval == 0 && c.Latency == 0:
+		return 0, false
+	case c.MaxInterval > 100*time.Millisecond:
+		return cbgo.PeripheralManagerConnectionLatencyHigh, true
+	case c.MaxInterval > 30*time.Millisecond || c.Latency > 0:
+		return cbgo.PeripheralManagerConnectionLatencyMedium, true
+	default:
+		return cbgo.PeripheralManagerConnectionLatencyLow, true
+	}
+}
@@ -0,0 +1,11 @@
+//go:build linux && !simble
+
+package peer
+
+import "tinygo.org/x/bluetooth"
+
+// stopNotifications calls StopNotify on the characteristic and removes the
+// D-Bus match rule and signal channel tinygo registered for it.
+func stopNotifications(char *bluetooth.DeviceCharacteristic) error {
+	return char.EnableNotifications(nil)
+}
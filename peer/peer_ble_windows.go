@@ -0,0 +1,23 @@
+//go:build windows && !simble
+
+package peer
+
+import "tinygo.org/x/bluetooth"
+
+// stopNotifications is a no-op on Windows: WinRT drops the value-changed
+// subscription together with the device when it is closed.
+func stopNotifications(char *bluetooth.DeviceCharacteristic) error {
+	return nil
+}
+
+// extendedAdvertising is false: WinRT does not offer extended adverts
+// through tinygo.
+func (p *Peer) extendedAdvertising() bool {
+	return false
+}
+
+func (p *Peer) startExtendedAdvertising(data AdvertisementData) error {
+	return ErrUnsupported
+}
+
+func (p *Peer) stopExtendedAdvertising() {}
@@ -0,0 +1,67 @@
+package peer
+
+import (
+	"io"
+
+	"bluetalk/transport"
+)
+
+// defaultBulkPSM is the L2CAP channel BlueTalk peers open for file transfers
+// unless configured otherwise.
+const defaultBulkPSM = 0x85
+
+// bulkLink is a side channel to the connected peer that carries the
+// transport's bulk data faster than GATT.
+type bulkLink interface {
+	transport.Link
+	io.Closer
+}
+
+// attachBulk hands link, a side channel to peer id, to the transport for file
+// transfers and streams, replacing any earlier one. link is closed instead if
+// id is no longer the connected peer.
+func (p *Peer) attachBulk(id string, link bulkLink) {
+	p.mu.Lock()
+	if !p.connected.Load() || p.linkID != id {
+		p.mu.Unlock()
+		_ = link.Close()
+		return
+	}
+	old := p.bulkLink
+	p.bulkLink = link
+	p.mu.Unlock()
+
+	if old != nil {
+		_ = old.Close()
+	}
+	if err := p.transport.AttachBulk(id, link); err != nil {
+		p.dropBulk(link)
+		return
+	}
+	p.publishStatus("File transfers switched to a faster side channel")
+}
+
+// dropBulk closes link and, if it is the side channel in use, moves bulk
+// data back to the main link.
+func (p *Peer) dropBulk(link bulkLink) {
+	p.mu.Lock()
+	current := p.bulkLink == link
+	id := p.linkID
+	if current {
+		p.bulkLink = nil
+	}
+	p.mu.Unlock()
+
+	_ = link.Close()
+	if current {
+		p.transport.DetachBulk(id)
+	}
+}
+
+// connectedTo reports whether we are connected as central to peripheral id,
+// the end that opens side channels.
+func (p *Peer) connectedTo(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected.Load() && p.isCentral && p.linkID == id
+}
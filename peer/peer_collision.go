@@ -0,0 +1,42 @@
+package peer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Two peers that scan and advertise at the same time can each connect to the
+// other as central. Both then hold a connection in each role, and if each
+// keeps the one it started, both are left half-used. The tiebreak makes the
+// two sides agree, without any messages, on which connection survives: the
+// peer whose adapter address is lower stays central, and the other ends its
+// GATT client role and serves the incoming connection. Both sides compare
+// the same pair of addresses, their own and the one the other connects
+// from, so they always agree. A peer that does not know its own address, as
+// on macOS, never yields.
+
+// takeIncoming decides what to do about central id connecting to us while we
+// are connected. It reports true once it has given up our own connection so
+// the caller can accept the incoming one; false means the caller should
+// turn the newcomer away.
+func (p *Peer) takeIncoming(id string) bool {
+	p.mu.Lock()
+	local := p.localAddr
+	p.mu.Unlock()
+	if !p.collides(id) || local == "" || strings.ToUpper(local) < strings.ToUpper(id) {
+		return false
+	}
+
+	// Both roles share one BLE link, so only our client role is ended; the
+	// link stays up for the incoming connection.
+	p.disconnect(fmt.Sprintf("Connection collision with %s: switching to its connection", id), false, true)
+	return true
+}
+
+// collides reports whether central id is the peer we are connected to as
+// central, so that its connection and ours share one BLE link.
+func (p *Peer) collides(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected.Load() && p.isCentral && sameEntry(p.linkID, id)
+}
@@ -0,0 +1,910 @@
+// Package peer is BlueTalk's Bluetooth LE connectivity. A Peer discovers
+// other BlueTalk peers, connects to one as central or serves one as
+// peripheral, and carries messages to it over package transport. It prints
+// nothing: what happens is reported as events from Subscribe, and as status
+// lines on a channel for UIs that just show them.
+package peer
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bluetalk/transport"
+)
+
+const (
+	serviceName = "BlueTalk"
+	bleMTU      = 20
+
+	// maxAttributeLen is the largest value an ATT attribute can hold.
+	maxAttributeLen = 512
+
+	// attHeaderSize is the ATT opcode and handle overhead of a write or
+	// notification; the usable payload is the ATT MTU minus this.
+	attHeaderSize = 3
+
+	// chunkPacing spaces out consecutive WriteChunked writes so the
+	// controller's transmit queue is not overrun.
+	chunkPacing = 5 * time.Millisecond
+
+	// advRotateInterval is how long each advertisement set stays on air
+	// before the next one is swapped in, when more than one is configured.
+	advRotateInterval = 1 * time.Second
+)
+
+// 128-bit custom UUIDs for BlueTalk (raw bytes for platform use), the
+// defaults of PeerConfig.
+var (
+	serviceUUID = []byte{0xa1, 0xb2, 0xc3, 0xd4, 0xe5, 0xf6, 0x11, 0x11, 0x22, 0x22, 0x33, 0x33, 0x44, 0x44, 0x55, 0x55}
+	rxUUID      = []byte{0xa1, 0xb2, 0xc3, 0xd4, 0xe5, 0xf6, 0x11, 0x11, 0x22, 0x22, 0x33, 0x33, 0x44, 0x44, 0x55, 0x66}
+	txUUID      = []byte{0xa1, 0xb2, 0xc3, 0xd4, 0xe5, 0xf6, 0x11, 0x11, 0x22, 0x22, 0x33, 0x33, 0x44, 0x44, 0x55, 0x77}
+)
+
+// Errors reported by the platform BLE stack. On Linux they are mapped from
+// org.bluez.Error.* names so discovery code can decide whether to retry.
+var (
+	ErrNotReady             = errors.New("bluetooth adapter not ready")
+	ErrAlreadyConnected     = errors.New("already connected")
+	ErrInProgress           = errors.New("operation already in progress")
+	ErrAuthenticationFailed = errors.New("authentication failed")
+	ErrPairingRequired      = errors.New("peer requires pairing")
+	ErrUnsupported          = errors.New("not supported on this platform")
+	ErrPoweredOff           = errors.New("bluetooth is off")
+	ErrUnauthorized         = errors.New("not allowed to use bluetooth")
+	ErrNoAdapter            = errors.New("no such bluetooth adapter")
+	ErrRadioBlocked         = errors.New("bluetooth is blocked")
+)
+
+// ErrNotConnected is returned when sending with no peer connected. It is the
+// transport's error, so either matches with errors.Is.
+var ErrNotConnected = transport.ErrNotConnected
+
+// Errors returned by Connect: for an address that is not one, and for a
+// peer the access list keeps out.
+var (
+	ErrInvalidAddress = errors.New("invalid peer address")
+	ErrNotAllowed     = errors.New("not allowed by the access list")
+)
+
+// AdvertisementData is what BlueTalk puts in its adverts next to the service
+// UUID, which is always included. Each platform carries what its stack allows:
+// BlueZ takes every field, Windows only manufacturer data, and macOS only the
+// local name. ServiceData is keyed by service UUID string ("180f" or the full
+// 128-bit form).
+type AdvertisementData struct {
+	LocalName        string
+	ManufacturerData map[uint16][]byte
+	ServiceData      map[string][]byte
+
+	// extended marks a set built for an extended advert; see
+	// peer_extadv.go.
+	extended bool
+}
+
+// ScanFilter selects which advertising devices discovery reports. A device
+// matches when it advertises any of ServiceUUIDs (raw 16-byte UUIDs, like
+// serviceUUID) or when NamePrefix is set and its local name starts with it.
+// An empty filter matches every device.
+type ScanFilter struct {
+	ServiceUUIDs [][]byte
+	NamePrefix   string
+}
+
+// AdapterInfo describes the local Bluetooth controller. Fields the platform
+// cannot report are left empty.
+type AdapterInfo struct {
+	Address     string
+	AddressType string
+	Name        string
+	Modalias    string
+}
+
+// BondedDevice is a remote device the local adapter has paired with.
+type BondedDevice struct {
+	Address string
+	Name    string
+	Paired  bool
+	Bonded  bool
+}
+
+// PairingHandler receives pairing prompts from the platform's pairing agent,
+// so the UI can ask the user inline. Only pairings BlueTalk starts reach it,
+// and AuthorizeService is only asked about the BlueTalk service; everything
+// else is refused. Nil funcs accept the request (Just Works), except
+// RequestPasskey: without it, pairings that need a code typed in are
+// rejected. Device is the remote address.
+type PairingHandler struct {
+	ConfirmPasskey   func(device string, passkey uint32) bool
+	DisplayPasskey   func(device string, passkey uint32)
+	RequestPasskey   func(device string) (passkey uint32, ok bool)
+	AuthorizeService func(device, uuid string) bool
+}
+
+// centralConn is the interface for an active BLE central connection (write + disconnect).
+// Release ends only the GATT client role, leaving the link itself up.
+type centralConn interface {
+	WriteNoResponse(data []byte) error
+	MaxWriteLen() int
+	Close() error
+	Release() error
+	Disconnected() <-chan struct{}
+}
+
+// peripheralNotifier is the interface for sending notifications as a BLE peripheral.
+type peripheralNotifier interface {
+	Write(data []byte) (int, error)
+	Close() error
+}
+
+type Peer struct {
+	sendCh   chan string
+	recvCh   chan string
+	statusCh chan string
+
+	mu        sync.Mutex
+	connected atomic.Bool
+	isCentral bool
+
+	// linkID identifies the connected peer to the transport.
+	linkID string
+
+	centralClient centralConn
+
+	// bulkLink is the side channel file transfers take to the connected
+	// peer; nil if there is none.
+	bulkLink bulkLink
+
+	config        PeerConfig
+	connParams    ConnectionParams
+	retryPolicy   RetryPolicy
+	pairing       PairingHandler
+	scanFilter    ScanFilter
+	scanWindowCfg ScanWindowConfig
+	connPolicy    ConnectionPolicy
+	messageTTL    time.Duration
+	rssiMonitor   RSSIMonitor
+	watchdog      HealthWatchdog
+	dutyCycle     DutyCycle
+
+	reconnectPolicy ReconnectPolicy
+	trust           TrustPolicy
+	access          AccessList
+
+	// heldOff holds until when discovery passes over each peer address:
+	// one refused as incompatible, so discovery does not keep reconnecting
+	// to it, or one Disconnect dropped.
+	heldOff map[string]time.Time
+
+	// agent is the pairing agent while it is registered; see
+	// registerPairingAgent. Only Linux has one.
+	agent *pairingAgent
+
+	// connectReqs carries Connect calls to the discovery loop.
+	connectReqs chan connectRequest
+
+	// localAddr is the adapter's address, for the role collision
+	// tiebreak, and identityKey the public key we announce; empty if
+	// unknown.
+	localAddr   string
+	identityKey ed25519.PublicKey
+
+	peripheralNotifierMu sync.Mutex
+	peripheralNotifier   peripheralNotifier
+
+	events   eventBus
+	presence presenceBook
+
+	advMu    sync.Mutex
+	advSets  []AdvertisementData
+	nickname string
+	room     []byte
+
+	// presenceStatus is the status we announce; see SetPresence.
+	presenceStatus transport.PresenceStatus
+
+	// advertising is set while advertiseFor runs, so connections reported
+	// then are known to come from a central that found us.
+	advertising atomic.Bool
+
+	// passiveFailed is set once passive scanning has failed; discovery
+	// scans actively from then on.
+	passiveFailed atomic.Bool
+
+	// connParamsReported is set once the user has been told that the
+	// connection parameters cannot be requested.
+	connParamsReported atomic.Bool
+
+	// extAdvFailed is set once an extended advert has failed; only legacy
+	// adverts are sent from then on.
+	extAdvFailed atomic.Bool
+
+	// lastActivity is when a peer was last seen, connected or left, in
+	// Unix nanoseconds, for the duty cycle.
+	lastActivity atomic.Int64
+
+	// lastPeer is the peer last connected to, and reconnectPending set
+	// when the link to it was lost and discovery should first try to get
+	// it back.
+	lastPeerMu       sync.Mutex
+	lastPeer         *LastPeer
+	reconnectPending atomic.Bool
+
+	// link is the connection the transport is attached to; nil while
+	// disconnected.
+	link atomic.Pointer[bleLink]
+
+	onIdentity atomic.Pointer[func(addr string, peer transport.Identity)]
+	onMessage  atomic.Pointer[func(msg transport.Message)]
+	hooks      atomic.Pointer[NotificationHooks]
+
+	transport     *transport.Transport
+	scanCache     *scanCache
+	droppedStatus atomic.Uint64
+
+	// ctx is cancelled by Stop, ending Run and everything it started; wg
+	// counts those goroutines so Stop can wait for them.
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// New returns a Peer for applications that embed BlueTalk: messages are sent
+// with Send or SendMessage and received through OnMessage, and everything
+// else is reported through Subscribe.
+func New() *Peer {
+	return NewPeer(nil, nil, nil)
+}
+
+// NewPeer returns a Peer for chat UIs built on channels: text read from send
+// goes to the connected peer, chat text received is delivered on recv, and
+// status lines on status. Any of them may be nil, and New passes none.
+func NewPeer(send, recv, status chan string) *Peer {
+	p := &Peer{
+		sendCh:          send,
+		recvCh:          recv,
+		statusCh:        status,
+		config:          DefaultPeerConfig(),
+		retryPolicy:     defaultRetryPolicy,
+		scanWindowCfg:   defaultScanWindows,
+		connPolicy:      defaultConnectionPolicy,
+		rssiMonitor:     defaultRSSIMonitor,
+		watchdog:        defaultHealthWatchdog,
+		dutyCycle:       defaultDutyCycle,
+		reconnectPolicy: defaultReconnectPolicy,
+		scanFilter: ScanFilter{
+			ServiceUUIDs: [][]byte{serviceUUID},
+		},
+		advSets:     []AdvertisementData{{LocalName: serviceName}},
+		connectReqs: make(chan connectRequest, 1),
+	}
+	p.ctx, p.stop = context.WithCancel(context.Background())
+	p.transport = transport.NewTransport(recv, status, transport.DefaultTransportConfig())
+	p.transport.OnDrop(func(id, reason string) {
+		p.rediscover("Disconnected: " + reason)
+	})
+	p.transport.OnIncompatible(p.incompatible)
+	p.transport.OnIdentity(p.identified)
+	p.transport.OnMessage(p.messageReceived)
+	p.transport.OnStatus(p.publishStatus)
+	p.transport.OnTyping(func(id string, typing bool) {
+		p.emit(Typing{Peer: id, Active: typing})
+	})
+	p.transport.OnPresence(p.presenceReceived)
+	p.transport.OnBulkReady(p.openBulk)
+	p.SetPresence(transport.PresenceOnline)
+	_ = p.useDownloadDir("")
+	p.scanCache = newScanCache(p.onPeerFound, nil, p.onPeerLost)
+	p.noteActivity()
+	return p
+}
+
+// SetAdvertisementData replaces the advertisement payload with a single set.
+// It takes effect the next time the peer starts advertising.
+func (p *Peer) SetAdvertisementData(data AdvertisementData) {
+	p.SetAdvertisements(data)
+}
+
+// SetAdvertisements configures one or more advertisement sets. The platform
+// stacks only hold one advert at a time, so multiple sets are rotated on air
+// every advRotateInterval during each advertising phase.
+func (p *Peer) SetAdvertisements(sets ...AdvertisementData) {
+	if len(sets) == 0 {
+		sets = []AdvertisementData{{LocalName: p.currentConfig().Name}}
+	}
+	p.advMu.Lock()
+	defer p.advMu.Unlock()
+	p.advSets = slices.Clone(sets)
+}
+
+// advertisementSets returns the advertisement sets with the nickname and
+// room added, in extended form if extended is set.
+func (p *Peer) advertisementSets(extended bool) []AdvertisementData {
+	cfg := p.currentConfig()
+	p.mu.Lock()
+	key := p.identityKey
+	p.mu.Unlock()
+
+	p.advMu.Lock()
+	defer p.advMu.Unlock()
+	sets := make([]AdvertisementData, len(p.advSets))
+	for i, data := range p.advSets {
+		data = withRoom(withNickname(data, p.nickname, cfg.Name, extended), cfg.ServiceUUID, p.room)
+		if extended {
+			data = withFingerprint(data, cfg.RXUUID, key)
+			data.extended = true
+		}
+		sets[i] = data
+	}
+	return sets
+}
+
+// advertisePhase returns how long to advertise after a scan found nothing:
+// the configured window plus up to one scan window of jitter. Two peers that
+// start together would otherwise scan and advertise in lockstep and never
+// see each other; the jitter lets them drift until one advertises while the
+// other scans.
+func (p *Peer) advertisePhase() time.Duration {
+	window := p.currentScanWindows().Window
+	return p.currentConfig().AdvertiseWindow + randomPhaseDuration(0, int(window/time.Millisecond))
+}
+
+// advertiseFor advertises for d, cycling through the advertisement sets. It
+// stops early once a central connects or the peer is stopped.
+func (p *Peer) advertiseFor(d time.Duration) error {
+	sets := p.advertisementSets(false)
+	if p.extendedAdvertising() {
+		sets = interleave(p.advertisementSets(true), sets)
+	}
+	slot := d
+	if len(sets) > 1 {
+		slot = advRotateInterval
+	}
+
+	p.advertising.Store(true)
+	defer p.advertising.Store(false)
+
+	deadline := time.Now().Add(d)
+	for i := 0; time.Now().Before(deadline) && !p.connected.Load() && p.ctx.Err() == nil; i++ {
+		if err := p.startAdvertising(sets[i%len(sets)]); err != nil {
+			return err
+		}
+		p.sleepUntilConnected(min(slot, time.Until(deadline)))
+		_ = p.stopAdvertising()
+	}
+	return nil
+}
+
+// sleepUntilConnected waits for d, or less if a peer connects or the peer is
+// stopped meanwhile.
+func (p *Peer) sleepUntilConnected(d time.Duration) {
+	deadline := time.Now().Add(d)
+	for !p.connected.Load() && time.Now().Before(deadline) {
+		if !p.pause(min(250*time.Millisecond, time.Until(deadline))) {
+			return
+		}
+	}
+}
+
+// pause waits for d and reports true, or returns false as soon as the peer
+// is stopped.
+func (p *Peer) pause(d time.Duration) bool {
+	if d <= 0 {
+		return p.ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
+// Run brings up the adapter and then discovers, connects and reconnects to
+// peers until Stop is called. It returns at once on a stopped Peer.
+func (p *Peer) Run() {
+	p.mu.Lock()
+	if p.ctx.Err() != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.wg.Add(1)
+	p.mu.Unlock()
+	defer p.wg.Done()
+
+	for {
+		err := p.setupPlatform()
+		if err == nil {
+			break
+		}
+		if !radioOffErr(err) {
+			p.emit(Error{Op: "BLE setup failed", Err: err})
+			return
+		}
+		if !platformReportsPower {
+			p.emit(PowerChanged{On: false, Blocked: errors.Is(err, ErrRadioBlocked), Err: err})
+		}
+		if !p.waitRadio() {
+			return
+		}
+		if !platformReportsPower {
+			p.emit(PowerChanged{On: true})
+		}
+	}
+
+	if err := p.registerPairingAgent(); err != nil {
+		p.emit(Error{Op: "Pairing agent unavailable", Err: err})
+	}
+	if err := p.listenBulk(); err != nil {
+		p.emit(Error{Op: "File transfers stay on GATT", Err: err})
+	}
+
+	if info, err := p.AdapterInfo(); err == nil && info.Address != "" {
+		p.mu.Lock()
+		p.localAddr = info.Address
+		p.mu.Unlock()
+		label := info.Address
+		if info.Name != "" {
+			label += " (" + info.Name + ")"
+		}
+		p.publishStatus("Local adapter: " + label)
+	}
+
+	p.wg.Go(p.writeLoop)
+	p.wg.Go(p.watchRadio)
+
+	p.runDiscoveryAndConnection()
+}
+
+// Stop shuts the peer down: scanning, advertising, connection attempts and
+// the write loop end, Stop waits for Run to return, and then the connected
+// peer, if any, is disconnected. A stopped Peer cannot be run again.
+func (p *Peer) Stop() {
+	p.mu.Lock()
+	p.stop()
+	p.mu.Unlock()
+	p.wg.Wait()
+	p.handleDisconnect("Disconnected: stopped")
+	p.unregisterPairingAgent()
+}
+
+// SetScanFilter replaces the discovery filter used by later scan windows.
+func (p *Peer) SetScanFilter(filter ScanFilter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scanFilter = filter
+}
+
+func (p *Peer) currentScanFilter() ScanFilter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scanFilter
+}
+
+// SetPairingHandler installs the callbacks used for pairing prompts. It must
+// be called before Run.
+func (p *Peer) SetPairingHandler(h PairingHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pairing = h
+}
+
+func (p *Peer) currentPairingHandler() PairingHandler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pairing
+}
+
+// SetEncryption turns end-to-end encryption of chat messages on or off for
+// future connections. Messages are encrypted even over unpaired links.
+func (p *Peer) SetEncryption(on bool) {
+	p.transport.EnableEncryption(on)
+}
+
+// SetCompression turns deflate compression of long messages on or off. It
+// only takes effect with peers that support it.
+func (p *Peer) SetCompression(on bool) {
+	p.transport.EnableCompression(on)
+}
+
+// SetKeepalive configures how quickly a silent peer is declared gone. It
+// takes effect on the next connection.
+func (p *Peer) SetKeepalive(cfg transport.KeepaliveConfig) {
+	p.transport.SetKeepalive(cfg)
+}
+
+// SetReadReceipts turns automatic read receipts on or off. When on, a receipt
+// is sent for each chat message once it is handed to the receive channel.
+func (p *Peer) SetReadReceipts(on bool) {
+	p.transport.EnableReadReceipts(on)
+}
+
+// MarkRead tells the peer its message id has been displayed, for UIs that
+// take messages from OnMessage and send receipts themselves.
+func (p *Peer) MarkRead(id uint32) {
+	p.transport.MarkRead(id)
+}
+
+// SetTyping tells the connected peer whether the user is composing a
+// message. See transport.Transport.SetTyping.
+func (p *Peer) SetTyping(typing bool) {
+	p.transport.SetTyping(typing)
+}
+
+// SetFileHandler sets how incoming file offers are answered and reported.
+func (p *Peer) SetFileHandler(h transport.FileHandler) {
+	p.transport.SetFileHandler(h)
+}
+
+// SetDownloadDir sets the directory received files are saved to, overriding
+// the config's.
+func (p *Peer) SetDownloadDir(dir string) {
+	p.transport.SetDownloadDir(dir)
+}
+
+// SendFile sends the file at path to the connected peer, resuming an earlier
+// interrupted transfer of the same file. It blocks until the peer has
+// verified the file.
+func (p *Peer) SendFile(path string, progress func(sent, total int64)) error {
+	return p.transport.SendFile(path, progress)
+}
+
+// SetTransportConfig tunes transport timeouts, retries and pacing, for slow
+// links or noisy radio environments.
+func (p *Peer) SetTransportConfig(cfg transport.TransportConfig) {
+	p.transport.SetConfig(cfg)
+}
+
+// AdapterInfo returns the address and metadata of the local controller.
+func (p *Peer) AdapterInfo() (AdapterInfo, error) {
+	return p.adapterInfo()
+}
+
+// BondedDevices lists the devices the adapter holds pairing data for, so the
+// UI can offer a list of saved peers.
+func (p *Peer) BondedDevices() ([]BondedDevice, error) {
+	return p.bondedDevices()
+}
+
+// Forget removes a saved peer and its bond from the adapter.
+func (p *Peer) Forget(addr string) error {
+	return p.forget(addr)
+}
+
+// Pair bonds with the device at addr, showing the PairingHandler's prompts.
+// Connecting pairs on its own when the peer requires it; this is for pairing
+// ahead of time. Windows and macOS pair from their own dialogs and report
+// ErrUnsupported.
+func (p *Peer) Pair(addr string) error {
+	return p.pair(addr)
+}
+
+// SetPeerAlias gives a remote device a friendly name ("Priya's laptop") that
+// the platform stores and shows in future scans.
+func (p *Peer) SetPeerAlias(addr, alias string) error {
+	return p.setPeerAlias(addr, alias)
+}
+
+// Send queues a message of the given kind for the connected peer. Chat text
+// normally goes through the send channel instead. While no peer is connected
+// the message waits in the outbound queue; see SetQueue.
+func (p *Peer) Send(kind transport.MessageKind, data []byte) *transport.Delivery {
+	return p.transport.Send(kind, data)
+}
+
+// SendContext is Send that abandons the message when ctx is done.
+func (p *Peer) SendContext(ctx context.Context, kind transport.MessageKind, data []byte) *transport.Delivery {
+	return p.transport.SendContext(ctx, "", kind, data)
+}
+
+// SetQueue configures how long messages wait for a peer that is not
+// connected, and where they are saved. See transport.QueueConfig.
+func (p *Peer) SetQueue(cfg transport.QueueConfig) error {
+	return p.transport.SetQueue(cfg)
+}
+
+// SetMessageTTL sets how long text from the send channel stays worth
+// sending. A message still unsent after ttl, typically because the peer was
+// away, is dropped with a status message instead of arriving late. Zero, the
+// default, leaves it to the queue TTL.
+func (p *Peer) SetMessageTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messageTTL = ttl
+}
+
+func (p *Peer) currentMessageTTL() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.messageTTL
+}
+
+// SendTTL queues a message for the connected peer that is dropped, failing
+// with transport.ErrExpired, if it has not been sent within ttl.
+func (p *Peer) SendTTL(kind transport.MessageKind, data []byte, ttl time.Duration) *transport.Delivery {
+	return p.transport.SendTTL("", kind, data, ttl)
+}
+
+// SendMessage queues chat text for the connected peer and returns its
+// delivery handle, for UIs that show sent and delivered states.
+func (p *Peer) SendMessage(text string) *transport.Delivery {
+	return p.Send(transport.KindChat, []byte(text))
+}
+
+// SendReader streams length bytes from r to the connected peer without
+// reading it all into memory first. See transport.Transport.SendReader.
+func (p *Peer) SendReader(r io.Reader, length int64) error {
+	if !p.connected.Load() {
+		return ErrNotConnected
+	}
+	return p.transport.SendReader(r, length)
+}
+
+// SetRelay lets messages broadcast by other peers travel on through this
+// one, up to maxHops hops. See transport.Transport.SetRelay.
+func (p *Peer) SetRelay(maxHops int) {
+	p.transport.SetRelay(maxHops)
+}
+
+// Broadcast sends a message to every peer reachable through the mesh. See
+// transport.Transport.Broadcast.
+func (p *Peer) Broadcast(kind transport.MessageKind, data []byte) []*transport.Delivery {
+	return p.transport.Broadcast(kind, data)
+}
+
+// OnMessage registers fn to receive every incoming message with its kind.
+// See transport.Transport.OnMessage.
+func (p *Peer) OnMessage(fn func(msg transport.Message)) {
+	if fn == nil {
+		p.onMessage.Store(nil)
+		return
+	}
+	p.onMessage.Store(&fn)
+}
+
+// OnLinkQuality registers fn to receive periodic link quality estimates. See
+// transport.Transport.OnLinkQuality.
+func (p *Peer) OnLinkQuality(fn func(q transport.LinkQuality)) {
+	p.transport.OnLinkQuality(fn)
+}
+
+// OnProgress registers fn to follow the reassembly of incoming messages. See
+// transport.Transport.OnProgress.
+func (p *Peer) OnProgress(fn func(progress transport.ReceiveProgress)) {
+	p.transport.OnProgress(fn)
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case msg, ok := <-p.sendCh:
+			if !ok {
+				return
+			}
+			if msg != "" {
+				p.sendChat(msg)
+			}
+		}
+	}
+}
+
+// sendChat queues chat text from the send channel and reports how it went.
+func (p *Peer) sendChat(msg string) {
+	if !p.connected.Load() && p.transport.Queueing() {
+		p.publishStatus("Not connected: message queued until the peer is back")
+	}
+	// Wait off the loop, so a message held for a reconnect does not hold
+	// up the ones typed after it; the transport queues those behind it, so
+	// they still arrive in order.
+	d := p.transport.SendTTL("", transport.KindChat, []byte(msg), p.currentMessageTTL())
+	p.wg.Go(func() {
+		select {
+		case <-d.Done():
+		case <-p.ctx.Done():
+			d.Cancel()
+			return
+		}
+		switch err := d.Err(); {
+		case err == nil:
+			p.emit(MessageDelivered{ID: d.ID})
+		case errors.Is(err, transport.ErrExpired):
+			p.publishStatus(fmt.Sprintf("Not sent, the peer was away too long: %q", msg))
+		case errors.Is(err, transport.ErrTooLarge):
+			p.publishStatus("Message too long to send; try splitting it up")
+		case errors.Is(err, transport.ErrTimeout):
+			p.emit(Error{Op: "Send failed, peer is not responding", Err: err})
+		default:
+			p.emit(Error{Op: "Send failed", Err: err})
+		}
+	})
+}
+
+func (p *Peer) setConnectedAsCentral(client centralConn, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.centralClient = client
+	p.isCentral = true
+	p.linkID = id
+	p.connected.Store(true)
+	p.noteActivity()
+	p.rememberPeer(id)
+	p.attach(id, client.MaxWriteLen())
+	go p.watchMTU(p.link.Load(), client, id)
+}
+
+// setConnectedAsPeripheral records a connection from central id that takes
+// notifications of up to mtu bytes.
+func (p *Peer) setConnectedAsPeripheral(id string, mtu int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.centralClient = nil
+	p.isCentral = false
+	p.linkID = id
+	p.connected.Store(true)
+	p.noteActivity()
+	p.rememberPeer(id)
+	p.attach(id, mtu)
+}
+
+// centralGone handles the platform reporting that central id went away, if
+// it is the central we are serving.
+func (p *Peer) centralGone(id string) {
+	if p.servingCentral(id) {
+		p.handleDisconnect(fmt.Sprintf("Disconnected from %s", id))
+	}
+}
+
+// servingCentral reports whether we are connected as peripheral to central
+// id.
+func (p *Peer) servingCentral(id string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected.Load() && !p.isCentral && p.linkID == id
+}
+
+func (p *Peer) handleDisconnect(reason string) {
+	p.disconnect(reason, true, false)
+}
+
+// rediscover disconnects from a peer whose link has gone dead and sends
+// discovery back to scanning rather than reconnecting to it directly: the
+// platform may still hold the dead link and hand it straight back.
+func (p *Peer) rediscover(reason string) {
+	p.disconnect(reason, false, false)
+}
+
+// disconnect tears down the current connection, if any, and reports it.
+// With reconnect set, discovery first tries to get the peer back. With
+// keepLink set, a central connection is released rather than closed, leaving
+// the BLE link up for a connection to the same peer in the other role.
+func (p *Peer) disconnect(reason string, reconnect, keepLink bool) {
+	wasConnected := p.connected.Swap(false)
+	if !wasConnected {
+		return
+	}
+
+	p.mu.Lock()
+	client := p.centralClient
+	p.centralClient = nil
+	p.isCentral = false
+	id := p.linkID
+	p.linkID = ""
+	p.link.Store(nil)
+	bulk := p.bulkLink
+	p.bulkLink = nil
+
+	p.peripheralNotifierMu.Lock()
+	if p.peripheralNotifier != nil {
+		_ = p.peripheralNotifier.Close()
+		p.peripheralNotifier = nil
+	}
+	p.peripheralNotifierMu.Unlock()
+	p.mu.Unlock()
+
+	switch {
+	case client != nil && keepLink:
+		_ = client.Release()
+	case client != nil:
+		_ = client.Close()
+	}
+	if bulk != nil {
+		_ = bulk.Close()
+	}
+
+	p.noteActivity()
+	p.reconnectPending.Store(reconnect)
+	p.presenceOffline(id)
+	p.transport.Detach(id)
+	p.emit(Disconnected{Peer: id, Reason: reason})
+}
+
+func (p *Peer) onPeerFound(entry scanEntry) {
+	p.emit(PeerFound{
+		Address: entry.Address.String(),
+		Name:    entry.Name,
+		RSSI:    entry.RSSI,
+		Version: entry.Version,
+	})
+}
+
+func (p *Peer) onPeerLost(entry scanEntry) {
+	p.emit(PeerLost{Address: entry.Address.String(), Name: entry.Name})
+}
+
+func (p *Peer) writeRaw(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.connected.Load() {
+		return ErrNotConnected
+	}
+
+	if p.isCentral {
+		err := p.centralClient.WriteNoResponse(data)
+		if err != nil {
+			go p.handleDisconnect("Disconnected: write failed")
+		}
+		return err
+	}
+	_, err := p.writePeripheral(data)
+	return err
+}
+
+// publishStatus reports a status line that has no event type of its own.
+func (p *Peer) publishStatus(msg string) {
+	p.emit(Notice{Text: msg})
+}
+
+// DropStats reports how many received messages and status lines were
+// dropped because the UI did not drain its channels in time.
+func (p *Peer) DropStats() transport.DropStats {
+	stats := p.transport.DropStats()
+	stats.Status += p.droppedStatus.Load()
+	return stats
+}
+
+// waitUntilDisconnected waits for the link to drop or the peer to be
+// stopped.
+func (p *Peer) waitUntilDisconnected() {
+	for p.connected.Load() {
+		if !p.pause(250 * time.Millisecond) {
+			return
+		}
+	}
+}
+
+// connectRetryDelay returns how long to back off after a failed connection
+// attempt before starting the next discovery cycle.
+func connectRetryDelay(err error) time.Duration {
+	switch {
+	case errors.Is(err, ErrInProgress), errors.Is(err, ErrAlreadyConnected):
+		return 500 * time.Millisecond
+	case errors.Is(err, ErrNotReady):
+		return 5 * time.Second
+	default:
+		return 2 * time.Second
+	}
+}
+
+func randomPhaseDuration(minMs, spanMs int) time.Duration {
+	return time.Duration(minMs+randIntn(spanMs)) * time.Millisecond
+}
+
+func randIntn(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return int(time.Now().UnixNano() % int64(n))
+}
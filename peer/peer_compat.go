@@ -0,0 +1,48 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+
+	"bluetalk/transport"
+)
+
+// refusedHold is how long discovery passes over a peer after refusing it as
+// incompatible. It may be updated in the meantime, so it is not skipped for
+// good.
+const refusedHold = 10 * time.Minute
+
+// incompatible handles the transport refusing peer id for running a build
+// this one cannot talk to: the link is dropped and discovery leaves the peer
+// alone for refusedHold.
+func (p *Peer) incompatible(id, reason string) {
+	p.holdOff(id, refusedHold)
+	p.emit(Incompatible{Peer: id, Reason: reason})
+	p.rediscover(fmt.Sprintf("Refused %s: %s", id, reason))
+}
+
+// holdOff has discovery pass over the peer at addr for d.
+func (p *Peer) holdOff(addr string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.heldOff == nil {
+		p.heldOff = make(map[string]time.Time)
+	}
+	p.heldOff[addr] = time.Now().Add(d)
+}
+
+// passedOver reports whether discovery should pass over e: it advertises a
+// protocol version too old to talk to, or is held off.
+func (p *Peer) passedOver(e scanEntry) bool {
+	if e.Version != 0 && e.Version < transport.MinProtocolVersion {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until, ok := p.heldOff[e.Address.String()]
+	if ok && !time.Now().Before(until) {
+		delete(p.heldOff, e.Address.String())
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,377 @@
+package peer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	peerConfigFile = "bluetalk/config.json"
+
+	// downloadDir is where received files go, under the user's data
+	// directory, unless the config names another.
+	downloadDir = "bluetalk/downloads"
+)
+
+// PeerConfig is what a deployment may change without forking BlueTalk: the
+// GATT service and characteristic UUIDs, which keep a private deployment
+// from ever seeing the public one, the default advertised name, and the
+// discovery duty cycle. UUIDs are raw 16-byte values, like ScanFilter's.
+type PeerConfig struct {
+	ServiceUUID []byte
+	RXUUID      []byte
+	TXUUID      []byte
+
+	// Name is the local name advertised until a nickname is set, and the
+	// name discovery treats as saying nothing about the peer.
+	Name string
+
+	// ScanWindow, ScanPause and ScanWindows are the ScanWindowConfig of
+	// each discovery round, and AdvertiseWindow how long the peer then
+	// advertises when it found no one.
+	ScanWindow      time.Duration
+	ScanPause       time.Duration
+	ScanWindows     int
+	AdvertiseWindow time.Duration
+
+	// ScanMode is how discovery listens for adverts during each scan
+	// window, and Role which ends of a connection the peer takes.
+	ScanMode ScanMode
+	Role     Role
+
+	// ConnectTimeout bounds each connection attempt, and IdentifyTimeout
+	// how long a peer the access list only admits by identity has to prove
+	// it.
+	ConnectTimeout  time.Duration
+	IdentifyTimeout time.Duration
+
+	// BulkPSM is the L2CAP channel file transfers move to when both peers
+	// can open one, in the LE dynamic range 0x80-0xff; 0 keeps them on
+	// GATT. Only BlueZ lets BlueTalk open L2CAP channels.
+	BulkPSM uint16
+
+	// Adapter picks the controller on machines with several: an ID like
+	// hci1, its index, or its address. Empty uses the system default, and
+	// only BlueZ lets BlueTalk choose.
+	Adapter string
+
+	// DownloadDir is where received files are saved. Empty uses
+	// DefaultDownloadDir.
+	DownloadDir string
+}
+
+// ScanMode is how discovery listens for adverts.
+type ScanMode int
+
+const (
+	// ScanActive runs full discovery, asking every device in range for
+	// its scan response and filtering the results in BlueTalk.
+	ScanActive ScanMode = iota
+
+	// ScanPassive only listens, and has the controller or bluetoothd pass
+	// on adverts carrying the BlueTalk service alone, which spares the
+	// battery. It needs BlueZ's advertisement monitors; where they are
+	// missing discovery scans actively.
+	ScanPassive
+)
+
+func (m ScanMode) String() string {
+	switch m {
+	case ScanActive:
+		return "active"
+	case ScanPassive:
+		return "passive"
+	default:
+		return fmt.Sprintf("ScanMode(%d)", int(m))
+	}
+}
+
+// parseScanMode parses a ScanMode as written in the config file.
+func parseScanMode(s string) (ScanMode, error) {
+	for _, m := range []ScanMode{ScanActive, ScanPassive} {
+		if strings.EqualFold(s, m.String()) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scan mode %q, want active or passive", s)
+}
+
+// Role is which ends of a connection a peer takes.
+type Role int
+
+const (
+	// RoleBoth scans and connects to the first peer found, and advertises
+	// for others to connect when it finds none.
+	RoleBoth Role = iota
+
+	// RoleHost only advertises and waits for others to connect.
+	RoleHost
+
+	// RoleClient only scans and connects out, and never advertises.
+	RoleClient
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleBoth:
+		return "both"
+	case RoleHost:
+		return "host"
+	case RoleClient:
+		return "client"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+// ParseRole parses a Role as written in the config file or on the command
+// line.
+func ParseRole(s string) (Role, error) {
+	for _, r := range []Role{RoleBoth, RoleHost, RoleClient} {
+		if strings.EqualFold(s, r.String()) {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown role %q, want both, host or client", s)
+}
+
+// DefaultPeerConfig returns the public BlueTalk UUIDs and timing.
+func DefaultPeerConfig() PeerConfig {
+	return PeerConfig{
+		ServiceUUID:     serviceUUID,
+		RXUUID:          rxUUID,
+		TXUUID:          txUUID,
+		Name:            serviceName,
+		ScanWindow:      defaultScanWindows.Window,
+		ScanPause:       defaultScanWindows.Pause,
+		ScanWindows:     defaultScanWindows.Windows,
+		AdvertiseWindow: 5 * time.Second,
+		ConnectTimeout:  defaultRetryPolicy.AttemptTimeout,
+		IdentifyTimeout: identifyTimeout,
+		BulkPSM:         defaultBulkPSM,
+	}
+}
+
+// validate checks that the config describes a usable service.
+func (c PeerConfig) validate() error {
+	for _, u := range []struct {
+		name string
+		uuid []byte
+	}{{"service", c.ServiceUUID}, {"RX", c.RXUUID}, {"TX", c.TXUUID}} {
+		if len(u.uuid) != 16 {
+			return fmt.Errorf("%s UUID must be 16 bytes, got %d", u.name, len(u.uuid))
+		}
+	}
+	if string(c.RXUUID) == string(c.TXUUID) || string(c.ServiceUUID) == string(c.RXUUID) || string(c.ServiceUUID) == string(c.TXUUID) {
+		return errors.New("service, RX and TX UUIDs must differ")
+	}
+	if c.Name == "" {
+		return errors.New("name must not be empty")
+	}
+	if c.ScanWindow <= 0 || c.AdvertiseWindow <= 0 {
+		return errors.New("scan and advertise windows must be positive")
+	}
+	if c.ScanMode != ScanActive && c.ScanMode != ScanPassive {
+		return fmt.Errorf("unknown scan mode %v", c.ScanMode)
+	}
+	if c.Role < RoleBoth || c.Role > RoleClient {
+		return fmt.Errorf("unknown role %v", c.Role)
+	}
+	if c.BulkPSM != 0 && (c.BulkPSM < 0x80 || c.BulkPSM > 0xff) {
+		return fmt.Errorf("bulk PSM %#x is outside the LE dynamic range 0x80-0xff", c.BulkPSM)
+	}
+	return nil
+}
+
+// Configure applies cfg, replacing the UUIDs, the advertisement sets, the
+// scan filter, the scan windows and the connect timeout. It must be called
+// before Run, and before the setters that refine what it sets.
+func (p *Peer) Configure(cfg PeerConfig) error {
+	if err := cfg.validate(); err != nil {
+		return fmt.Errorf("invalid peer config: %w", err)
+	}
+
+	p.mu.Lock()
+	p.config = cfg
+	p.scanFilter = ScanFilter{ServiceUUIDs: [][]byte{cfg.ServiceUUID}}
+	p.scanWindowCfg = ScanWindowConfig{Window: cfg.ScanWindow, Pause: cfg.ScanPause, Windows: cfg.ScanWindows}
+	p.retryPolicy.AttemptTimeout = cfg.ConnectTimeout
+	p.mu.Unlock()
+
+	p.SetAdvertisements(AdvertisementData{LocalName: cfg.Name})
+	p.scanCache.configure(cfg)
+	if err := p.useDownloadDir(cfg.DownloadDir); err != nil {
+		p.publishStatus(fmt.Sprintf("Not accepting files: %v", err))
+	}
+	return nil
+}
+
+// useDownloadDir saves received files to dir, or to DefaultDownloadDir if
+// dir is empty. When there is neither, files are refused.
+func (p *Peer) useDownloadDir(dir string) error {
+	var err error
+	if dir == "" {
+		dir, err = DefaultDownloadDir()
+	}
+	p.transport.SetDownloadDir(dir)
+	return err
+}
+
+func (p *Peer) currentConfig() PeerConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+// DefaultPeerConfigPath returns where BlueTalk looks for its config file.
+func DefaultPeerConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("locate config directory: %w", err)
+	}
+	return filepath.Join(dir, filepath.FromSlash(peerConfigFile)), nil
+}
+
+// DefaultDownloadDir returns where received files are saved unless the
+// config names another directory: bluetalk/downloads under the user's data
+// directory, which is $XDG_DATA_HOME or ~/.local/share on Linux and the
+// config directory elsewhere.
+func DefaultDownloadDir() (string, error) {
+	dir, err := userDataDir()
+	if err != nil {
+		return "", fmt.Errorf("locate data directory: %w", err)
+	}
+	return filepath.Join(dir, filepath.FromSlash(downloadDir)), nil
+}
+
+func userDataDir() (string, error) {
+	if runtime.GOOS != "linux" {
+		return os.UserConfigDir()
+	}
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+// peerConfigJSON is the config file format. UUIDs are written in the usual
+// dashed form and durations as strings like "5s"; anything left out keeps
+// its default.
+type peerConfigJSON struct {
+	ServiceUUID     string `json:"service_uuid"`
+	RXUUID          string `json:"rx_uuid"`
+	TXUUID          string `json:"tx_uuid"`
+	Name            string `json:"name"`
+	ScanWindow      string `json:"scan_window"`
+	ScanPause       string `json:"scan_pause"`
+	ScanWindows     int    `json:"scan_windows"`
+	AdvertiseWindow string `json:"advertise_window"`
+	ScanMode        string `json:"scan_mode"`
+	Role            string `json:"role"`
+	ConnectTimeout  string `json:"connect_timeout"`
+	IdentifyTimeout string `json:"identify_timeout"`
+	BulkPSM         *int   `json:"bulk_psm"`
+	Adapter         string `json:"adapter"`
+	DownloadDir     string `json:"download_dir"`
+}
+
+// LoadPeerConfig reads the config file at path over the defaults. A missing
+// file gives the defaults.
+func LoadPeerConfig(path string) (PeerConfig, error) {
+	cfg := DefaultPeerConfig()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("read peer config: %w", err)
+	}
+
+	var file peerConfigJSON
+	if err := json.Unmarshal(data, &file); err != nil {
+		return cfg, fmt.Errorf("parse peer config %s: %w", path, err)
+	}
+	for _, f := range []struct {
+		name string
+		in   string
+		out  *[]byte
+	}{
+		{"service_uuid", file.ServiceUUID, &cfg.ServiceUUID},
+		{"rx_uuid", file.RXUUID, &cfg.RXUUID},
+		{"tx_uuid", file.TXUUID, &cfg.TXUUID},
+	} {
+		if f.in == "" {
+			continue
+		}
+		if *f.out, err = parseUUID(f.in); err != nil {
+			return cfg, fmt.Errorf("peer config %s: %w", f.name, err)
+		}
+	}
+	for _, f := range []struct {
+		name string
+		in   string
+		out  *time.Duration
+	}{
+		{"scan_window", file.ScanWindow, &cfg.ScanWindow},
+		{"scan_pause", file.ScanPause, &cfg.ScanPause},
+		{"advertise_window", file.AdvertiseWindow, &cfg.AdvertiseWindow},
+		{"connect_timeout", file.ConnectTimeout, &cfg.ConnectTimeout},
+		{"identify_timeout", file.IdentifyTimeout, &cfg.IdentifyTimeout},
+	} {
+		if f.in == "" {
+			continue
+		}
+		if *f.out, err = time.ParseDuration(f.in); err != nil {
+			return cfg, fmt.Errorf("peer config %s: %w", f.name, err)
+		}
+	}
+	if file.Name != "" {
+		cfg.Name = file.Name
+	}
+	if file.Role != "" {
+		if cfg.Role, err = ParseRole(file.Role); err != nil {
+			return cfg, fmt.Errorf("peer config role: %w", err)
+		}
+	}
+	if file.Adapter != "" {
+		cfg.Adapter = file.Adapter
+	}
+	if file.DownloadDir != "" {
+		cfg.DownloadDir = file.DownloadDir
+	}
+	if file.ScanWindows != 0 {
+		cfg.ScanWindows = file.ScanWindows
+	}
+	if file.ScanMode != "" {
+		if cfg.ScanMode, err = parseScanMode(file.ScanMode); err != nil {
+			return cfg, fmt.Errorf("peer config scan_mode: %w", err)
+		}
+	}
+	if file.BulkPSM != nil {
+		if *file.BulkPSM < 0 || *file.BulkPSM > 0xffff {
+			return cfg, fmt.Errorf("peer config bulk_psm: %d out of range", *file.BulkPSM)
+		}
+		cfg.BulkPSM = uint16(*file.BulkPSM)
+	}
+	return cfg, cfg.validate()
+}
+
+// parseUUID parses a 128-bit UUID, with or without dashes, to raw bytes.
+func parseUUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("invalid UUID %q", s)
+	}
+	return b, nil
+}
@@ -0,0 +1,41 @@
+//go:build (linux || windows || darwin) && !simble
+
+package peer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// connectDevice runs adapter.Connect bounded by ctx. BlueZ's Connect waits
+// indefinitely for the link, so a late success after ctx expires is torn down.
+func connectDevice(ctx context.Context, addr bluetooth.Address, params bluetooth.ConnectionParams) (bluetooth.Device, error) {
+	if deadline, ok := ctx.Deadline(); ok && params.ConnectionTimeout == 0 {
+		params.ConnectionTimeout = bluetooth.NewDuration(min(time.Until(deadline), maxConnectTimeout))
+	}
+
+	type result struct {
+		device bluetooth.Device
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		device, err := adapter.Connect(addr, params)
+		done <- result{device, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.device, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				_ = r.device.Disconnect()
+			}
+		}()
+		return bluetooth.Device{}, fmt.Errorf("connect to %s: %w", addr.String(), ctx.Err())
+	}
+}
@@ -0,0 +1,163 @@
+//go:build linux || windows || darwin
+
+package peer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// ConnectionParams are the link parameters the peer would like. Latency is
+// how many connection events the peripheral may skip. Zero fields leave the
+// platform default in place. Only some of them take effect: as central, every
+// platform applies just ConnectTimeout, since tinygo's Connect ignores the
+// rest and no stack offers a way to request them once connected; see
+// requestConnectionParams. As a peripheral on macOS, the intervals and
+// latency pick the closest of CoreBluetooth's latency presets.
+type ConnectionParams struct {
+	ConnectTimeout     time.Duration
+	MinInterval        time.Duration
+	MaxInterval        time.Duration
+	Latency            uint16
+	SupervisionTimeout time.Duration
+}
+
+// LowLatencyConnectionParams returns parameters for interactive chat: the
+// shortest interval the spec allows, no skipped events and a supervision
+// timeout that notices a lost peer quickly. Default intervals of 30 ms or
+// more make typing round trips feel sluggish. For now they only take effect
+// on a macOS peripheral, as its low latency preset; a central reports them
+// as not applied, as described on ConnectionParams.
+func LowLatencyConnectionParams() ConnectionParams {
+	return ConnectionParams{
+		MinInterval:        7500 * time.Microsecond,
+		MaxInterval:        15 * time.Millisecond,
+		SupervisionTimeout: 4 * time.Second,
+	}
+}
+
+// RetryPolicy controls how connectWithRetry re-attempts a failed connection.
+// The wait before attempt n (counting from 1 for the first retry) is
+// Backoff doubled n-1 times, capped at MaxBackoff, plus up to Jitter.
+type RetryPolicy struct {
+	Attempts       int
+	Backoff        time.Duration
+	MaxBackoff     time.Duration
+	Jitter         time.Duration
+	AttemptTimeout time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:       3,
+	Backoff:        500 * time.Millisecond,
+	MaxBackoff:     4 * time.Second,
+	Jitter:         250 * time.Millisecond,
+	AttemptTimeout: 10 * time.Second,
+}
+
+func (r RetryPolicy) delay(retry int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < retry && d < r.MaxBackoff; i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 {
+		d = min(d, r.MaxBackoff)
+	}
+	return d + time.Duration(randIntn(int(r.Jitter/time.Millisecond)))*time.Millisecond
+}
+
+// maxConnectTimeout is the longest timeout bluetooth.Duration can express.
+const maxConnectTimeout = 40 * time.Second
+
+func (c ConnectionParams) tinygo() bluetooth.ConnectionParams {
+	return bluetooth.ConnectionParams{
+		ConnectionTimeout: bluetooth.NewDuration(c.ConnectTimeout),
+		MinInterval:       bluetooth.NewDuration(c.MinInterval),
+		MaxInterval:       bluetooth.NewDuration(c.MaxInterval),
+		Timeout:           bluetooth.NewDuration(c.SupervisionTimeout),
+	}
+}
+
+// tuned reports whether the parameters ask for anything beyond the connect
+// timeout, which is only used while connecting.
+func (c ConnectionParams) tuned() bool {
+	return c.MinInterval > 0 || c.MaxInterval > 0 || c.Latency > 0 || c.SupervisionTimeout > 0
+}
+
+// requestConnectionParams is where a central would ask for the preferred
+// parameters once the link is up. None of the stacks BlueTalk runs on lets
+// it: tinygo's Device.RequestConnectionParams returns without doing
+// anything, BlueZ has no D-Bus call for it, and WinRT and CoreBluetooth are
+// not reached through tinygo. Tuned parameters are reported as not applied,
+// once per Peer, instead of being silently dropped.
+func (p *Peer) requestConnectionParams() {
+	if !p.connectionParams().tuned() || !p.connParamsReported.CompareAndSwap(false, true) {
+		return
+	}
+	err := fmt.Errorf("connection parameters: %w", ErrUnsupported)
+	p.publishStatus(fmt.Sprintf("Connection intervals and latency not applied: %v", err))
+}
+
+// SetConnectionParams sets the preferred parameters for future connections.
+func (p *Peer) SetConnectionParams(params ConnectionParams) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connParams = params
+}
+
+func (p *Peer) connectionParams() ConnectionParams {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connParams
+}
+
+// SetRetryPolicy replaces the policy used for future connection attempts.
+func (p *Peer) SetRetryPolicy(policy RetryPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retryPolicy = policy
+}
+
+func (p *Peer) currentRetryPolicy() RetryPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.retryPolicy
+}
+
+// connectWithRetry connects to addr, retrying according to the peer's
+// RetryPolicy.
+func (p *Peer) connectWithRetry(addr bluetooth.Address) error {
+	return p.connectWith(addr, p.currentRetryPolicy())
+}
+
+// connectWith connects to addr, retrying according to policy. Each attempt
+// is bounded by the policy's AttemptTimeout, and stopping the peer abandons
+// the attempt under way.
+func (p *Peer) connectWith(addr bluetooth.Address, policy RetryPolicy) error {
+	attempts := max(policy.Attempts, 1)
+
+	var err error
+	for attempt := range attempts {
+		if attempt > 0 {
+			if !p.pause(policy.delay(attempt)) {
+				return p.ctx.Err()
+			}
+			p.publishStatus(fmt.Sprintf("Retrying connection to %s (%d/%d)...", addr.String(), attempt+1, attempts))
+		}
+
+		ctx := p.ctx
+		cancel := context.CancelFunc(func() {})
+		if policy.AttemptTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, policy.AttemptTimeout)
+		}
+		err = p.connectAndSubscribePlatform(ctx, addr)
+		cancel()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
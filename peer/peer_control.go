@@ -0,0 +1,105 @@
+//go:build linux || windows || darwin
+
+package peer
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// disconnectHold is how long discovery passes over a peer after Disconnect,
+// so that the next round does not connect straight back to it.
+const disconnectHold = time.Minute
+
+// connectRequest is a Connect call waiting for the discovery loop, which
+// sends the outcome on done. A request whose ctx is done by then is dropped.
+type connectRequest struct {
+	ctx  context.Context
+	addr string
+	done chan error
+}
+
+// Connect connects to the peer at addr, dropping the connection to any
+// other peer first. The discovery loop connects at the start of its next
+// round, directly and without scanning for the peer, whatever the role;
+// Connect waits for the outcome until ctx is done.
+func (p *Peer) Connect(ctx context.Context, addr string) error {
+	a, err := parseAddress(addr)
+	if err != nil {
+		return fmt.Errorf("connect to %q: %w", addr, ErrInvalidAddress)
+	}
+	addr = a.String()
+	if !p.currentAccessList().admitsAddress(addr) {
+		return fmt.Errorf("connect to %s: %w", addr, ErrNotAllowed)
+	}
+	p.mu.Lock()
+	current := p.linkID
+	p.mu.Unlock()
+	if p.connected.Load() && current == addr {
+		return nil
+	}
+
+	req := connectRequest{ctx: ctx, addr: addr, done: make(chan error, 1)}
+	select {
+	case p.connectReqs <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+	p.disconnect(fmt.Sprintf("Disconnected to connect to %s", addr), false, false)
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+// connectRequested makes the connection a Connect call asked for, if there
+// is one waiting. It reports whether it connected.
+func (p *Peer) connectRequested() bool {
+	var req connectRequest
+	select {
+	case req = <-p.connectReqs:
+	default:
+		return false
+	}
+	if req.ctx.Err() != nil {
+		return false
+	}
+	addr, _ := parseAddress(req.addr)
+
+	p.mu.Lock()
+	delete(p.heldOff, req.addr)
+	p.mu.Unlock()
+
+	p.publishStatus(fmt.Sprintf("Connecting to %s...", req.addr))
+	err := p.prepareDirectConnect(req.addr, p.addressType(req.addr))
+	if err == nil {
+		err = p.connectWithRetry(addr)
+	}
+	if err != nil && p.ctx.Err() == nil {
+		p.emit(Error{Op: "Connection failed", Err: err})
+	}
+	req.done <- err
+	return err == nil
+}
+
+// Disconnect drops the current connection, and discovery passes over the
+// peer for a minute. The peer may still connect to us meanwhile.
+func (p *Peer) Disconnect() error {
+	p.mu.Lock()
+	id := p.linkID
+	p.mu.Unlock()
+	if !p.connected.Load() {
+		return ErrNotConnected
+	}
+	p.holdOff(id, disconnectHold)
+	p.disconnect("Disconnected on request", false, false)
+	return nil
+}
@@ -0,0 +1,119 @@
+//go:build (linux || windows || darwin) && !simble
+
+package peer
+
+import (
+	"fmt"
+	"strings"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// peerDeviceInfo holds the standard Device Information Service fields and the
+// battery level read from a connected peer. Fields the peer does not expose
+// are left empty; Battery is -1 when unknown.
+type peerDeviceInfo struct {
+	Manufacturer string
+	Model        string
+	Firmware     string
+	Battery      int
+}
+
+func (info peerDeviceInfo) String() string {
+	var parts []string
+	if info.Manufacturer != "" || info.Model != "" {
+		parts = append(parts, strings.TrimSpace(info.Manufacturer+" "+info.Model))
+	}
+	if info.Firmware != "" {
+		parts = append(parts, "firmware "+info.Firmware)
+	}
+	if info.Battery >= 0 {
+		parts = append(parts, fmt.Sprintf("battery %d%%", info.Battery))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ReadBatteryLevel reads the Battery Level characteristic (0x2A19) of the
+// connected peer.
+func (c *CentralClient) ReadBatteryLevel() (uint8, error) {
+	return readBatteryLevel(c.device)
+}
+
+// ReadDeviceInfo reads whatever Device Information Service strings and
+// battery level the connected peer exposes.
+func (c *CentralClient) ReadDeviceInfo() peerDeviceInfo {
+	return readDeviceInfo(c.device)
+}
+
+func readBatteryLevel(device bluetooth.Device) (uint8, error) {
+	chars, err := discoverChars(device, bluetooth.ServiceUUIDBattery, bluetooth.CharacteristicUUIDBatteryLevel)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1)
+	n, err := chars[0].Read(buf)
+	if err != nil {
+		return 0, fmt.Errorf("battery read failed: %w", err)
+	}
+	if n < 1 {
+		return 0, fmt.Errorf("battery read returned no data")
+	}
+	return buf[0], nil
+}
+
+func readDeviceInfo(device bluetooth.Device) peerDeviceInfo {
+	info := peerDeviceInfo{Battery: -1}
+	if level, err := readBatteryLevel(device); err == nil {
+		info.Battery = int(level)
+	}
+
+	chars, err := discoverChars(device, bluetooth.ServiceUUIDDeviceInformation,
+		bluetooth.CharacteristicUUIDManufacturerNameString,
+		bluetooth.CharacteristicUUIDModelNumberString,
+		bluetooth.CharacteristicUUIDFirmwareRevisionString,
+	)
+	if err != nil {
+		return info
+	}
+
+	buf := make([]byte, maxAttributeLen)
+	for _, c := range chars {
+		n, err := c.Read(buf)
+		if err != nil || n == 0 {
+			continue
+		}
+		value := strings.TrimRight(string(buf[:min(n, len(buf))]), "\x00 ")
+		switch c.UUID() {
+		case bluetooth.CharacteristicUUIDManufacturerNameString:
+			info.Manufacturer = value
+		case bluetooth.CharacteristicUUIDModelNumberString:
+			info.Model = value
+		case bluetooth.CharacteristicUUIDFirmwareRevisionString:
+			info.Firmware = value
+		}
+	}
+	return info
+}
+
+func discoverChars(device bluetooth.Device, service bluetooth.UUID, uuids ...bluetooth.UUID) ([]bluetooth.DeviceCharacteristic, error) {
+	services, err := device.DiscoverServices([]bluetooth.UUID{service})
+	if err != nil || len(services) == 0 {
+		return nil, fmt.Errorf("service %s not found: %w", service.String(), err)
+	}
+	chars, err := services[0].DiscoverCharacteristics(uuids)
+	if err != nil {
+		return nil, fmt.Errorf("characteristic discovery failed: %w", err)
+	}
+	if len(chars) == 0 {
+		return nil, fmt.Errorf("no characteristics found in service %s", service.String())
+	}
+	return chars, nil
+}
+
+// reportPeerDeviceInfo publishes the peer's model and battery level, if it
+// exposes either, so the chat UI can show them.
+func (p *Peer) reportPeerDeviceInfo(client *CentralClient) {
+	if s := client.ReadDeviceInfo().String(); s != "" {
+		p.publishStatus("Peer device: " + s)
+	}
+}
@@ -0,0 +1,72 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+)
+
+// DutyCycle adapts how often the radio discovers to how busy the area is.
+// While peers have been seen within QuietAfter, discovery rounds follow each
+// other back to back. Once it has been quiet for longer, the radio rests
+// between rounds, starting at MinRest and doubling each quiet round up to
+// MaxRest, and goes back to full speed as soon as a peer is seen or
+// connects. A zero MaxRest keeps the radio busy all the time.
+type DutyCycle struct {
+	QuietAfter time.Duration
+	MinRest    time.Duration
+	MaxRest    time.Duration
+}
+
+var defaultDutyCycle = DutyCycle{
+	QuietAfter: time.Minute,
+	MinRest:    2 * time.Second,
+	MaxRest:    30 * time.Second,
+}
+
+// SetDutyCycle replaces the discovery duty cycle policy.
+func (p *Peer) SetDutyCycle(d DutyCycle) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dutyCycle = d
+}
+
+func (p *Peer) currentDutyCycle() DutyCycle {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.dutyCycle
+}
+
+// next returns how long to rest after a round that found no one, given the
+// rest before it and how long it has been since a peer was last seen.
+func (d DutyCycle) next(prev, quiet time.Duration) time.Duration {
+	if d.MaxRest <= 0 || quiet < d.QuietAfter {
+		return 0
+	}
+	if prev == 0 {
+		return min(d.MinRest, d.MaxRest)
+	}
+	return min(2*prev, d.MaxRest)
+}
+
+// noteActivity records that a peer was just seen, connected or left.
+func (p *Peer) noteActivity() {
+	p.lastActivity.Store(time.Now().UnixNano())
+}
+
+// restRadio rests the radio after a discovery round that found no one, for
+// as long as the duty cycle says after rest, the previous round's rest, and
+// returns the rest it took. A little jitter keeps two resting peers from
+// staying in step, which would keep them from ever finding each other.
+func (p *Peer) restRadio(rest time.Duration) time.Duration {
+	quiet := time.Since(time.Unix(0, p.lastActivity.Load()))
+	next := p.currentDutyCycle().next(rest, quiet)
+	if next == 0 {
+		return 0
+	}
+	if rest == 0 {
+		p.publishStatus(fmt.Sprintf("No peers for %s: discovering less often to save power", quiet.Round(time.Second)))
+	}
+	jitter := time.Duration(randIntn(int(next/4/time.Millisecond))) * time.Millisecond
+	p.pause(next + jitter)
+	return next
+}
@@ -0,0 +1,57 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+var bluezErrors = map[string]error{
+	"org.bluez.Error.NotReady":             ErrNotReady,
+	"org.bluez.Error.AlreadyConnected":     ErrAlreadyConnected,
+	"org.bluez.Error.InProgress":           ErrInProgress,
+	"org.bluez.Error.AuthenticationFailed": ErrAuthenticationFailed,
+	"org.bluez.Error.NotPermitted":         ErrPairingRequired,
+	"org.bluez.Error.NotAuthorized":        ErrPairingRequired,
+}
+
+// attSecurityErrors are the ATT error codes BlueZ reports, inside a generic
+// org.bluez.Error.Failed, when an attribute needs a more secure link:
+// insufficient authentication, authorization and encryption.
+var attSecurityErrors = []string{"0x05", "0x08", "0x0f"}
+
+// mapPlatformError wraps err with the matching exported error value when it
+// carries a known org.bluez.Error.* name, so callers can use errors.Is.
+func mapPlatformError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var name string
+	var dbusErr dbus.Error
+	var dbusErrPtr *dbus.Error
+	switch {
+	case errors.As(err, &dbusErr):
+		name = dbusErr.Name
+	case errors.As(err, &dbusErrPtr):
+		name = dbusErrPtr.Name
+	default:
+		return err
+	}
+
+	if typed, ok := bluezErrors[name]; ok {
+		return fmt.Errorf("%w: %w", typed, err)
+	}
+	if name == "org.bluez.Error.Failed" && strings.Contains(err.Error(), "ATT error") {
+		for _, code := range attSecurityErrors {
+			if strings.Contains(strings.ToLower(err.Error()), code) {
+				return fmt.Errorf("%w: %w", ErrPairingRequired, err)
+			}
+		}
+	}
+	return err
+}
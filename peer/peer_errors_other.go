@@ -0,0 +1,8 @@
+//go:build !linux || simble
+
+package peer
+
+// mapPlatformError returns err unchanged; only BlueZ reports named errors.
+func mapPlatformError(err error) error {
+	return err
+}
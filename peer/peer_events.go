@@ -0,0 +1,201 @@
+package peer
+
+import (
+	"fmt"
+	"sync"
+
+	"bluetalk/transport"
+)
+
+// PeerEvent is something that happened to the Peer, for UIs and tests that
+// react to it rather than parse status lines. Status renders it as the line
+// sent on the status channel, or "" for events too frequent to show there.
+type PeerEvent interface {
+	Status() string
+}
+
+// ScanStarted is reported when a discovery round begins.
+type ScanStarted struct{}
+
+func (ScanStarted) Status() string { return "Scanning for peers..." }
+
+// PeerFound is reported the first time discovery sees a device. Version is
+// the protocol version it advertises, zero if it advertises none.
+type PeerFound struct {
+	Address string
+	Name    string
+	RSSI    int16
+	Version byte
+}
+
+func (e PeerFound) Status() string {
+	if e.Version != 0 {
+		return fmt.Sprintf("Found peer %s (%s, protocol v%d)", e.Name, e.Address, e.Version)
+	}
+	return fmt.Sprintf("Found peer %s (%s)", e.Name, e.Address)
+}
+
+// PeerLost is reported when a device discovery found has not advertised for
+// the nearby expiry and is dropped from Nearby.
+type PeerLost struct {
+	Address string
+	Name    string
+}
+
+func (e PeerLost) Status() string {
+	return fmt.Sprintf("Lost sight of %s (%s)", e.Name, e.Address)
+}
+
+// Connected is reported when a link to Peer comes up. Central is whether we
+// connected out to it; otherwise it connected to us.
+type Connected struct {
+	Peer    string
+	Central bool
+}
+
+func (e Connected) Status() string {
+	if e.Central {
+		return fmt.Sprintf("Connected to %s", e.Peer)
+	}
+	return fmt.Sprintf("Connected to %s (peripheral)", e.Peer)
+}
+
+// Disconnected is reported when the link to Peer goes down, with a reason
+// fit to show the user.
+type Disconnected struct {
+	Peer   string
+	Reason string
+}
+
+func (e Disconnected) Status() string { return e.Reason }
+
+// Incompatible is reported when Peer is refused for running a BlueTalk
+// build this one cannot talk to, with Reason saying why. The Disconnected
+// event that follows carries the status line.
+type Incompatible struct {
+	Peer   string
+	Reason string
+}
+
+func (Incompatible) Status() string { return "" }
+
+// Error is reported when an operation fails: Op says what was being done.
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e Error) Status() string { return fmt.Sprintf("%s: %v", e.Op, e.Err) }
+
+// MessageDelivered is reported when the peer has acknowledged the chat
+// message with delivery ID, for UIs that mark messages as delivered.
+type MessageDelivered struct {
+	ID uint32
+}
+
+func (MessageDelivered) Status() string { return "" }
+
+// MessageReceived is reported for each chat message from Peer, whose
+// delivery ID is ID, for owners that take messages from events rather than
+// the receive channel. Name is how to show the sender, as for
+// NotificationHooks.
+type MessageReceived struct {
+	Peer string
+	Name string
+	ID   uint32
+	Text string
+}
+
+func (MessageReceived) Status() string { return "" }
+
+// Typing is reported when Peer starts or stops composing a message.
+type Typing struct {
+	Peer   string
+	Active bool
+}
+
+func (e Typing) Status() string {
+	if e.Active {
+		return fmt.Sprintf("%s is typing...", e.Peer)
+	}
+	return ""
+}
+
+// PowerChanged is reported when the Bluetooth radio is switched on or off
+// while the Peer is running, or found off when it starts. Blocked is whether
+// rfkill is what keeps it off, and Err, when the platform says, how to fix
+// it. The Peer carries on by itself once the radio is back.
+type PowerChanged struct {
+	On      bool
+	Blocked bool
+	Err     error
+}
+
+func (e PowerChanged) Status() string {
+	switch {
+	case e.On:
+		return "Bluetooth is on"
+	case e.Err != nil:
+		return fmt.Sprintf("%v, waiting for it to come back", e.Err)
+	case e.Blocked:
+		return "Bluetooth is blocked, waiting for it to be unblocked"
+	}
+	return "Bluetooth is off, waiting for it to be switched on"
+}
+
+// Notice is any other status line, from the Peer or its transport.
+type Notice struct {
+	Text string
+}
+
+func (e Notice) Status() string { return e.Text }
+
+// eventBus fans events out to subscribers. A subscriber that falls behind
+// misses events rather than holding up the Peer.
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan PeerEvent]struct{}
+}
+
+// Subscribe returns a channel receiving every event from now on, buffering
+// up to buffer of them, and a func that ends the subscription and closes the
+// channel. Events that find the buffer full are dropped and counted in
+// DropStats.
+func (p *Peer) Subscribe(buffer int) (events <-chan PeerEvent, cancel func()) {
+	ch := make(chan PeerEvent, max(buffer, 1))
+	p.events.mu.Lock()
+	if p.events.subs == nil {
+		p.events.subs = make(map[chan PeerEvent]struct{})
+	}
+	p.events.subs[ch] = struct{}{}
+	p.events.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			p.events.mu.Lock()
+			delete(p.events.subs, ch)
+			p.events.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// emit reports ev to subscribers and its status line, if it has one, on the
+// status channel.
+func (p *Peer) emit(ev PeerEvent) {
+	p.events.mu.Lock()
+	for ch := range p.events.subs {
+		select {
+		case ch <- ev:
+		default:
+			p.droppedStatus.Add(1)
+		}
+	}
+	p.events.mu.Unlock()
+	p.notify(ev)
+
+	if msg := ev.Status(); msg != "" && p.statusCh != nil && !transport.OfferStatus(p.statusCh, msg) {
+		p.droppedStatus.Add(1)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build linux || windows || darwin
+
+package peer
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Bluetooth 5 extended advertising lifts the 31-byte limit of legacy adverts,
+// so where the stack offers it a peer advertises its whole nickname and its
+// identity fingerprint next to the room ID. Scanners on older controllers
+// never see extended adverts, so every extended set takes turns on air with
+// its legacy form.
+//
+// The fingerprint goes in service data keyed by the RX characteristic UUID,
+// which keeps it apart from the room ID under the service UUID and is as
+// private to a deployment as the service itself.
+
+// advFingerprintSize is the fingerprint an extended advert carries: the raw
+// bytes of transport.Identity.Fingerprint.
+const advFingerprintSize = 8
+
+// withFingerprint adds the fingerprint of identity key to an advertisement
+// set as service data of rx. A peer with no identity advertises none.
+func withFingerprint(data AdvertisementData, rx []byte, key ed25519.PublicKey) AdvertisementData {
+	if key == nil {
+		return data
+	}
+	sum := sha256.Sum256(key)
+	data.ServiceData = maps.Clone(data.ServiceData)
+	if data.ServiceData == nil {
+		data.ServiceData = make(map[string][]byte)
+	}
+	data.ServiceData[bytesToUUID(rx).String()] = sum[:advFingerprintSize]
+	return data
+}
+
+// advertisedFingerprint returns the identity fingerprint a scan result
+// carries as service data of rx, or "" if it carries none.
+func advertisedFingerprint(result bluetooth.ScanResult, rx bluetooth.UUID) string {
+	for _, sd := range result.ServiceData() {
+		if sd.UUID == rx && len(sd.Data) >= advFingerprintSize {
+			return hex.EncodeToString(sd.Data[:advFingerprintSize])
+		}
+	}
+	return ""
+}
+
+// legacyForm cuts an extended advertisement set down to what a legacy advert
+// holds, for stacks that turn out unable to send extended ones.
+func (p *Peer) legacyForm(data AdvertisementData) AdvertisementData {
+	data.extended = false
+	data.LocalName = truncateUTF8(data.LocalName, localNameMax)
+	if payload, ok := data.ManufacturerData[bluetalkCompanyID]; ok {
+		if _, nickname, ok := parseNicknamePayload(payload); ok {
+			data.ManufacturerData = maps.Clone(data.ManufacturerData)
+			data.ManufacturerData[bluetalkCompanyID] = nicknamePayload(nickname, advNicknameMax)
+		}
+	}
+	rx := bytesToUUID(p.currentConfig().RXUUID).String()
+	if _, ok := data.ServiceData[rx]; ok {
+		data.ServiceData = maps.Clone(data.ServiceData)
+		delete(data.ServiceData, rx)
+	}
+	return data
+}
+
+// interleave alternates the sets of a and b, starting with a.
+func interleave(a, b []AdvertisementData) []AdvertisementData {
+	out := make([]AdvertisementData, 0, len(a)+len(b))
+	for i := range max(len(a), len(b)) {
+		if i < len(a) {
+			out = append(out, a[i])
+		}
+		if i < len(b) {
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	extAdvRoot  = dbus.ObjectPath("/org/bluetalk/advertisement")
+	extAdvIface = "org.bluez.LEAdvertisement1"
+
+	// extAdvChannel is the PHY extended adverts are sent on after the
+	// primary channels. 1M is the one every Bluetooth 5 controller has.
+	extAdvChannel = "1M"
+)
+
+// extAdvState is the extended advert bluetoothd currently holds for us, if
+// any. tinygo only registers legacy adverts, so extended ones are exported
+// here directly, as the advertisement monitor is.
+var extAdvState struct {
+	mu     sync.Mutex
+	conn   *dbus.Conn
+	advert *extAdvert
+}
+
+// extAdvSeq numbers exported adverts, so a new one never reuses the path of
+// one bluetoothd may still be releasing.
+var extAdvSeq atomic.Uint64
+
+// extAdvert is an org.bluez.LEAdvertisement1 asking for an extended advert.
+type extAdvert struct {
+	path  dbus.ObjectPath
+	props map[string]dbus.Variant
+}
+
+// extendedAdvertising reports whether the controller can send extended
+// adverts, which bluetoothd reports by listing secondary channels.
+func (p *Peer) extendedAdvertising() bool {
+	if p.extAdvFailed.Load() {
+		return false
+	}
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return false
+	}
+	v, err := conn.Object("org.bluez", adapterPath()).
+		GetProperty("org.bluez.LEAdvertisingManager1.SupportedSecondaryChannels")
+	if err != nil {
+		return false
+	}
+	channels, _ := v.Value().([]string)
+	return len(channels) > 0
+}
+
+// startExtendedAdvertising registers data as an extended advert, replacing
+// any earlier one.
+func (p *Peer) startExtendedAdvertising(data AdvertisementData) error {
+	p.stopExtendedAdvertising()
+
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return fmt.Errorf("system bus: %w", err)
+	}
+	a := &extAdvert{
+		path:  extAdvRoot + dbus.ObjectPath(fmt.Sprintf("/%d", extAdvSeq.Add(1))),
+		props: extAdvProperties(data, p.currentConfig().ServiceUUID),
+	}
+	if err := conn.Export(a, a.path, extAdvIface); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err := conn.Export(a, a.path, "org.freedesktop.DBus.Properties"); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	err = conn.Object("org.bluez", adapterPath()).
+		Call("org.bluez.LEAdvertisingManager1.RegisterAdvertisement", 0, a.path, map[string]dbus.Variant{}).Err
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("register extended advertisement: %w", mapPlatformError(err))
+	}
+
+	extAdvState.mu.Lock()
+	extAdvState.conn, extAdvState.advert = conn, a
+	extAdvState.mu.Unlock()
+	return nil
+}
+
+// stopExtendedAdvertising withdraws the extended advert, if one is up.
+func (p *Peer) stopExtendedAdvertising() {
+	extAdvState.mu.Lock()
+	conn, a := extAdvState.conn, extAdvState.advert
+	extAdvState.conn, extAdvState.advert = nil, nil
+	extAdvState.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	_ = conn.Object("org.bluez", adapterPath()).
+		Call("org.bluez.LEAdvertisingManager1.UnregisterAdvertisement", 0, a.path).Err
+	_ = conn.Close()
+}
+
+// extAdvProperties lays data out as LEAdvertisement1 properties. Setting
+// SecondaryChannel is what makes bluetoothd send the advert extended, with
+// room for the whole payload.
+func extAdvProperties(data AdvertisementData, service []byte) map[string]dbus.Variant {
+	manufacturer := make(map[uint16]dbus.Variant, len(data.ManufacturerData))
+	for id, payload := range data.ManufacturerData {
+		manufacturer[id] = dbus.MakeVariant(payload)
+	}
+	serviceData := make(map[string]dbus.Variant, len(data.ServiceData))
+	for uuid, payload := range data.ServiceData {
+		serviceData[uuid] = dbus.MakeVariant(payload)
+	}
+	props := map[string]dbus.Variant{
+		"Type":             dbus.MakeVariant("peripheral"),
+		"ServiceUUIDs":     dbus.MakeVariant([]string{bytesToUUID(service).String()}),
+		"ManufacturerData": dbus.MakeVariant(manufacturer),
+		"ServiceData":      dbus.MakeVariant(serviceData),
+		"SecondaryChannel": dbus.MakeVariant(extAdvChannel),
+	}
+	if data.LocalName != "" {
+		props["LocalName"] = dbus.MakeVariant(data.LocalName)
+	}
+	return props
+}
+
+// Get implements org.freedesktop.DBus.Properties.
+func (a *extAdvert) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	v, ok := a.props[name]
+	if iface != extAdvIface || !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{"no such property " + name})
+	}
+	return v, nil
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.
+func (a *extAdvert) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if iface != extAdvIface {
+		return nil, nil
+	}
+	return a.props, nil
+}
+
+// Release is called by bluetoothd when it drops the advert.
+func (a *extAdvert) Release() *dbus.Error {
+	return nil
+}
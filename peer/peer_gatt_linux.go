@@ -0,0 +1,102 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"fmt"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// gattNotifier sends packets to a connected central as notifications on the
+// TX characteristic of the BlueTalk GATT service.
+type gattNotifier struct {
+	tx     *bluetooth.Characteristic
+	device bluetooth.Device
+}
+
+func (n gattNotifier) Write(data []byte) (int, error) {
+	return n.tx.Write(data)
+}
+
+// Close disconnects the central. The service stays registered for the next
+// one.
+func (n gattNotifier) Close() error {
+	return n.device.Disconnect()
+}
+
+// servePeripheral registers the BlueTalk GATT service with bluetoothd, so a
+// central that connects while we advertise can write packets to RX and
+// subscribe to TX, and starts accepting those connections. It must run
+// before the first advertisement.
+func (p *Peer) servePeripheral() error {
+	cfg := p.currentConfig()
+	tx := new(bluetooth.Characteristic)
+	err := adapter.AddService(&bluetooth.Service{
+		UUID: bytesToUUID(cfg.ServiceUUID),
+		Characteristics: []bluetooth.CharacteristicConfig{
+			{
+				UUID:  bytesToUUID(cfg.RXUUID),
+				Flags: bluetooth.CharacteristicWritePermission | bluetooth.CharacteristicWriteWithoutResponsePermission,
+				WriteEvent: func(_ bluetooth.Connection, _ int, value []byte) {
+					p.receivePacket(value)
+				},
+			},
+			{
+				Handle: tx,
+				UUID:   bytesToUUID(cfg.TXUUID),
+				Flags:  bluetooth.CharacteristicReadPermission | bluetooth.CharacteristicNotifyPermission,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("register GATT service: %w", mapPlatformError(err))
+	}
+
+	adapter.SetConnectHandler(func(device bluetooth.Device, connected bool) {
+		// tinygo calls this from inside Device.Disconnect too, which
+		// handleDisconnect reaches with p.mu held.
+		if connected {
+			go p.acceptCentral(device, tx)
+		} else {
+			go p.centralGone(device.Address.String())
+		}
+	})
+	return nil
+}
+
+// acceptCentral takes a connection from a central that found our
+// advertisement. Connections tinygo reports for our own outgoing connects
+// arrive while we are not advertising and are left to the central path. One
+// that collides with our own connection to the same peer is settled by
+// takeIncoming; it shares that connection's link, so it is not disconnected
+// when it loses. Any other central we do not take is disconnected: tinygo
+// does not say which central an RX write comes from, so one left connected
+// could write into the current peer's link.
+func (p *Peer) acceptCentral(device bluetooth.Device, tx *bluetooth.Characteristic) {
+	if !p.advertising.Load() {
+		return
+	}
+	id := device.Address.String()
+	if !p.currentAccessList().admitsAddress(id) {
+		_ = device.Disconnect()
+		p.publishStatus(fmt.Sprintf("Refused connection from %s: not allowed", id))
+		return
+	}
+	if p.connected.Load() && !p.takeIncoming(id) {
+		if !p.collides(id) {
+			_ = device.Disconnect()
+			p.publishStatus(fmt.Sprintf("Refused connection from %s: already connected", id))
+		}
+		return
+	}
+
+	p.peripheralNotifierMu.Lock()
+	p.peripheralNotifier = gattNotifier{tx: tx, device: device}
+	p.peripheralNotifierMu.Unlock()
+
+	// tinygo does not report a central's ATT MTU to the server; the link
+	// takes the one the central announces in its HELLO.
+	p.setConnectedAsPeripheral(id, 0)
+	p.emit(Connected{Peer: id})
+}
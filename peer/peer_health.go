@@ -0,0 +1,61 @@
+package peer
+
+import (
+	"fmt"
+	"time"
+)
+
+// HealthWatchdog configures the connection health watchdog. BLE links can go
+// dead without the platform ever reporting a disconnect: writes keep
+// succeeding and nothing arrives. Every Interval the watchdog checks when a
+// packet, ACKs and keepalive replies included, last arrived from the
+// connected peer; once none has for Silence, it disconnects and sends
+// discovery back to scanning. It is a backstop to the transport keepalive,
+// which drops a silent peer sooner but only while keepalives are on. A zero
+// Silence turns the watchdog off.
+type HealthWatchdog struct {
+	Interval time.Duration
+	Silence  time.Duration
+}
+
+var defaultHealthWatchdog = HealthWatchdog{
+	Interval: time.Second,
+	Silence:  20 * time.Second,
+}
+
+// SetHealthWatchdog replaces the watchdog settings. It takes effect on the
+// next connection.
+func (p *Peer) SetHealthWatchdog(w HealthWatchdog) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.watchdog = w
+}
+
+func (p *Peer) currentHealthWatchdog() HealthWatchdog {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.watchdog
+}
+
+// watchHealth drops link's peer id once nothing has been heard from it for
+// the watchdog's Silence, until the link goes away.
+func (p *Peer) watchHealth(link *bleLink, id string) {
+	w := p.currentHealthWatchdog()
+	if w.Silence <= 0 {
+		return
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = defaultHealthWatchdog.Interval
+	}
+	for p.pause(interval) && p.link.Load() == link {
+		heard := p.transport.LastHeard(id)
+		if heard.IsZero() {
+			continue
+		}
+		if silent := time.Since(heard); silent >= w.Silence {
+			p.rediscover(fmt.Sprintf("Disconnected from %s: nothing heard for %s", id, silent.Round(time.Second)))
+			return
+		}
+	}
+}
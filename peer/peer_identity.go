@@ -0,0 +1,99 @@
+package peer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"bluetalk/transport"
+)
+
+// identityFile is where the identity key is kept, under the user's config
+// directory.
+const identityFile = "bluetalk/identity.key"
+
+// DefaultIdentityPath returns where this installation's identity key lives.
+func DefaultIdentityPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("find config directory: %w", err)
+	}
+	return filepath.Join(dir, identityFile), nil
+}
+
+// LoadIdentity reads the Ed25519 identity key stored at path, generating and
+// saving a new one the first time. The file holds the 32-byte seed and is
+// readable only by its owner.
+func LoadIdentity(path string) (ed25519.PrivateKey, error) {
+	seed, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("identity key %s: want %d bytes, have %d", path, ed25519.SeedSize, len(seed))
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("read identity key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate identity key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("save identity key: %w", err)
+	}
+	if err := os.WriteFile(path, key.Seed(), 0o600); err != nil {
+		return nil, fmt.Errorf("save identity key: %w", err)
+	}
+	return key, nil
+}
+
+// SetIdentity sets the identity key and display name the peer announces on
+// every connection, so the other side recognises it whatever address it
+// connects from.
+func (p *Peer) SetIdentity(key ed25519.PrivateKey, name string) {
+	p.mu.Lock()
+	p.identityKey = nil
+	if key != nil {
+		p.identityKey = key.Public().(ed25519.PublicKey)
+	}
+	p.mu.Unlock()
+	p.transport.SetIdentity(key, name)
+}
+
+// PeerIdentity returns the identity the connected peer proved, if it has.
+func (p *Peer) PeerIdentity() (transport.Identity, bool) {
+	return p.transport.PeerIdentity("")
+}
+
+// OnIdentity registers fn to be called when a connected peer proves an
+// identity the trust policy accepts. See transport.Transport.OnIdentity.
+func (p *Peer) OnIdentity(fn func(addr string, peer transport.Identity)) {
+	if fn == nil {
+		p.onIdentity.Store(nil)
+		return
+	}
+	p.onIdentity.Store(&fn)
+}
+
+func (p *Peer) identified(addr string, id transport.Identity) {
+	if !p.checkTrust(addr, id) {
+		// This runs on the receive path, which disconnecting waits for.
+		go p.handleDisconnect("Disconnected: " + ErrKeyMismatch.Error())
+		return
+	}
+	if !p.currentAccessList().admitsPeer(addr, id.Fingerprint()) {
+		go p.handleDisconnect(fmt.Sprintf("Disconnected from %s: %s is not allowed", addr, id.Name))
+		return
+	}
+	p.presenceIdentified(addr, id)
+	go p.rememberIdentity(addr, id)
+	if fn := p.onIdentity.Load(); fn != nil {
+		(*fn)(addr, id)
+	}
+}
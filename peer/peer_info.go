@@ -0,0 +1,52 @@
+package peer
+
+import "time"
+
+// ConnectionInfo describes the live connection. Name is how to show the
+// peer, as notification hooks get it, and Identity the fingerprint of the
+// identity it proved, empty until it has. MTU is the packet size settled
+// with the peer, and RSSI the last signal reading in dBm, zero before the
+// first or where the platform takes none.
+type ConnectionInfo struct {
+	Address   string
+	Name      string
+	Identity  string
+	Central   bool
+	MTU       int
+	Version   int
+	Encrypted bool
+	RSSI      int16
+	Since     time.Time
+}
+
+// Uptime is how long the connection has been up.
+func (ci ConnectionInfo) Uptime() time.Duration {
+	return time.Since(ci.Since)
+}
+
+// Info describes the current connection, and reports false while there is
+// none.
+func (p *Peer) Info() (ConnectionInfo, bool) {
+	p.mu.Lock()
+	link, id, central := p.link.Load(), p.linkID, p.isCentral
+	p.mu.Unlock()
+	if link == nil || !p.connected.Load() {
+		return ConnectionInfo{}, false
+	}
+
+	info := ConnectionInfo{
+		Address: id,
+		Name:    p.displayName(id),
+		Central: central,
+		MTU:     link.mtu,
+		RSSI:    int16(link.rssi.Load()),
+		Since:   link.since,
+	}
+	if li, ok := p.transport.LinkInfo(id); ok {
+		info.MTU, info.Version, info.Encrypted = li.MTU, li.Version, li.Encrypted
+	}
+	if peer, ok := p.transport.PeerIdentity(id); ok {
+		info.Identity = peer.Fingerprint()
+	}
+	return info, true
+}
@@ -0,0 +1,246 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// btSndMTU is BT_SNDMTU from <bluetooth/bluetooth.h>: the largest SDU
+	// the channel sends.
+	btSndMTU = 12
+
+	// bulkDialTimeout bounds opening the side channel; file transfers stay
+	// on GATT if it takes longer.
+	bulkDialTimeout = 5 * time.Second
+
+	// bulkPollInterval is how often the listener checks whether the Peer
+	// has stopped.
+	bulkPollInterval = 500 * time.Millisecond
+
+	// bulkReadSize fits any packet the transport sends.
+	bulkReadSize = 4096
+)
+
+var _ bulkLink = (*l2capLink)(nil)
+
+// l2capLink is an LE credit-based L2CAP channel to the connected peer. It is
+// a SEQPACKET socket, so every read and write is one transport packet.
+type l2capLink struct {
+	f        *os.File
+	mtu      int
+	onPacket atomic.Pointer[func([]byte)]
+}
+
+func newL2CAPLink(fd int) *l2capLink {
+	mtu, err := unix.GetsockoptInt(fd, unix.SOL_BLUETOOTH, btSndMTU)
+	if err != nil {
+		mtu = 0
+	}
+	return &l2capLink{f: os.NewFile(uintptr(fd), "l2cap"), mtu: mtu}
+}
+
+// Write sends one packet. A channel that fails to write is closed, which
+// ends its read loop.
+func (l *l2capLink) Write(packet []byte) error {
+	if _, err := l.f.Write(packet); err != nil {
+		_ = l.f.Close()
+		return err
+	}
+	return nil
+}
+
+func (l *l2capLink) OnPacket(fn func(packet []byte)) {
+	if fn == nil {
+		l.onPacket.Store(nil)
+		return
+	}
+	l.onPacket.Store(&fn)
+}
+
+func (l *l2capLink) MTU() int {
+	return l.mtu
+}
+
+func (l *l2capLink) Close() error {
+	return l.f.Close()
+}
+
+// readLoop hands packets from the channel to the transport until it closes,
+// then moves bulk data back to GATT.
+func (l *l2capLink) readLoop(p *Peer) {
+	defer p.dropBulk(l)
+	buf := make([]byte, bulkReadSize)
+	for {
+		n, err := l.f.Read(buf)
+		if err != nil {
+			return
+		}
+		if fn := l.onPacket.Load(); fn != nil {
+			(*fn)(buf[:n])
+		}
+	}
+}
+
+// listenBulk opens the configured L2CAP channel for connected centrals to
+// move file transfers to, and tells peers it is there. With no channel
+// configured it does nothing.
+func (p *Peer) listenBulk() error {
+	psm := p.currentConfig().BulkPSM
+	if psm == 0 {
+		return nil
+	}
+	fd, err := l2capSocket()
+	if err != nil {
+		return err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrL2{PSM: psm, AddrType: unix.BDADDR_LE_PUBLIC}); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("bind L2CAP channel %#x: %w", psm, err)
+	}
+	if err := unix.Listen(fd, 1); err != nil {
+		unix.Close(fd)
+		return fmt.Errorf("listen on L2CAP channel %#x: %w", psm, err)
+	}
+	p.transport.EnableBulkLinks(true)
+	p.wg.Go(func() { p.acceptBulk(fd) })
+	return nil
+}
+
+// acceptBulk takes side channels from the central we are serving until the
+// Peer stops. Anyone else is hung up on.
+func (p *Peer) acceptBulk(fd int) {
+	defer unix.Close(fd)
+	defer p.transport.EnableBulkLinks(false)
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for p.ctx.Err() == nil {
+		n, err := unix.Poll(fds, int(bulkPollInterval/time.Millisecond))
+		if errors.Is(err, unix.EINTR) || (err == nil && n == 0) {
+			continue
+		}
+		if err != nil {
+			p.emit(Error{Op: "Side channel listener failed", Err: err})
+			return
+		}
+		nfd, sa, err := unix.Accept4(fd, unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		if err != nil {
+			continue
+		}
+		l2, ok := sa.(*unix.SockaddrL2)
+		if !ok || !p.servingCentral(l2capAddress(l2.Addr)) {
+			unix.Close(nfd)
+			continue
+		}
+		p.startBulk(l2capAddress(l2.Addr), nfd)
+	}
+}
+
+// openBulk opens a side channel to peripheral id, which said it takes one.
+// Only the central opens it, so a link never gets two.
+func (p *Peer) openBulk(id string) {
+	psm := p.currentConfig().BulkPSM
+	if psm == 0 || !p.connectedTo(id) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(p.ctx, bulkDialTimeout)
+	defer cancel()
+	fd, err := dialL2CAP(ctx, id, p.addressType(id), psm)
+	if err != nil {
+		p.publishStatus(fmt.Sprintf("File transfers stay on GATT: %v", err))
+		return
+	}
+	p.startBulk(id, fd)
+}
+
+// startBulk wraps an open channel to peer id and hands it to the transport.
+// The channel is closed when the Peer stops, so its read loop ends.
+func (p *Peer) startBulk(id string, fd int) {
+	link := newL2CAPLink(fd)
+	p.attachBulk(id, link)
+	p.wg.Go(func() {
+		defer context.AfterFunc(p.ctx, func() { _ = link.Close() })()
+		link.readLoop(p)
+	})
+}
+
+// l2capSocket returns a non-blocking L2CAP SEQPACKET socket, which the
+// runtime poller can wait on.
+func l2capSocket() (int, error) {
+	fd, err := unix.Socket(unix.AF_BLUETOOTH, unix.SOCK_SEQPACKET|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, unix.BTPROTO_L2CAP)
+	if err != nil {
+		return -1, fmt.Errorf("L2CAP socket: %w", err)
+	}
+	return fd, nil
+}
+
+// dialL2CAP connects to channel psm on the LE device at addr, whose BlueZ
+// address type is addrType.
+func dialL2CAP(ctx context.Context, addr, addrType string, psm uint16) (int, error) {
+	mac, err := net.ParseMAC(addr)
+	if err != nil || len(mac) != 6 {
+		return -1, fmt.Errorf("L2CAP address %q: not a MAC address", addr)
+	}
+	remote := &unix.SockaddrL2{PSM: psm, AddrType: unix.BDADDR_LE_PUBLIC}
+	copy(remote.Addr[:], mac)
+	if addrType == "random" {
+		remote.AddrType = unix.BDADDR_LE_RANDOM
+	}
+
+	fd, err := l2capSocket()
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrL2{AddrType: unix.BDADDR_LE_PUBLIC}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("bind L2CAP socket: %w", err)
+	}
+	if err := unix.Connect(fd, remote); err != nil && !errors.Is(err, unix.EINPROGRESS) {
+		unix.Close(fd)
+		return -1, fmt.Errorf("connect L2CAP channel %#x: %w", psm, err)
+	}
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLOUT}}
+	for {
+		if ctx.Err() != nil {
+			unix.Close(fd)
+			return -1, fmt.Errorf("connect L2CAP channel %#x: %w", psm, ctx.Err())
+		}
+		n, err := unix.Poll(fds, int(bulkPollInterval/time.Millisecond))
+		if errors.Is(err, unix.EINTR) || (err == nil && n == 0) {
+			continue
+		}
+		if err == nil {
+			var soErr int
+			soErr, err = unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_ERROR)
+			if err == nil && soErr != 0 {
+				err = unix.Errno(soErr)
+			}
+		}
+		if err != nil {
+			unix.Close(fd)
+			return -1, fmt.Errorf("connect L2CAP channel %#x: %w", psm, err)
+		}
+		return fd, nil
+	}
+}
+
+// l2capAddress formats an address the kernel reports, least significant
+// byte first, the way tinygo prints addresses.
+func l2capAddress(b [6]uint8) string {
+	parts := make([]string, len(b))
+	for i := range b {
+		parts[i] = fmt.Sprintf("%02X", b[len(b)-1-i])
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,12 @@
+//go:build !linux || simble
+
+package peer
+
+// listenBulk does nothing: only BlueZ lets BlueTalk open L2CAP channels, so
+// file transfers stay on GATT and peers are never told otherwise.
+func (p *Peer) listenBulk() error {
+	return nil
+}
+
+// openBulk is never called, since no side channel is announced.
+func (p *Peer) openBulk(id string) {}
@@ -0,0 +1,93 @@
+package peer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"bluetalk/transport"
+)
+
+var _ transport.Link = (*bleLink)(nil)
+
+const (
+	// mtuSettleTime is how long after connecting the ATT MTU is watched.
+	// No stack tinygo drives lets an application ask for an MTU; each
+	// exchanges its own maximum on connect, sometimes after the connection
+	// is reported up.
+	mtuSettleTime   = 3 * time.Second
+	mtuPollInterval = 250 * time.Millisecond
+)
+
+// bleLink is the transport's view of one BLE connection. Writes go through
+// the Peer's current connection, and notifications reach the transport
+// while the link is the Peer's current one. since is when it came up, and
+// rssi the last signal reading of the peer.
+type bleLink struct {
+	p        *Peer
+	mtu      int
+	since    time.Time
+	rssi     atomic.Int32
+	onPacket atomic.Pointer[func([]byte)]
+}
+
+func (l *bleLink) Write(packet []byte) error {
+	if l.p.link.Load() != l {
+		return transport.ErrNotConnected
+	}
+	return l.p.writeRaw(packet)
+}
+
+func (l *bleLink) OnPacket(fn func(packet []byte)) {
+	if fn == nil {
+		l.onPacket.Store(nil)
+		return
+	}
+	l.onPacket.Store(&fn)
+}
+
+func (l *bleLink) MTU() int {
+	return l.mtu
+}
+
+// attach hands a new connection to peer id, carrying packets of up to mtu
+// bytes, to the transport. Callers hold p.mu.
+func (p *Peer) attach(id string, mtu int) {
+	link := &bleLink{p: p, mtu: mtu, since: time.Now()}
+	p.link.Store(link)
+	p.transport.Attach(id, link)
+	if p.access.needsIdentity(id) {
+		go p.requireIdentity(link, id)
+	}
+	go p.watchRSSI(link, id)
+	go p.watchHealth(link, id)
+}
+
+// receivePacket passes a notification from the connected peer to the
+// transport.
+func (p *Peer) receivePacket(packet []byte) {
+	link := p.link.Load()
+	if link == nil {
+		return
+	}
+	if fn := link.onPacket.Load(); fn != nil {
+		(*fn)(packet)
+	}
+}
+
+// watchMTU follows the MTU of a new central connection until it settles and
+// feeds every change to the transport, so packets grow as soon as the stack
+// has exchanged a larger MTU.
+func (p *Peer) watchMTU(link *bleLink, client centralConn, id string) {
+	last := link.mtu
+	for deadline := time.Now().Add(mtuSettleTime); time.Now().Before(deadline); {
+		if !p.pause(mtuPollInterval) || p.link.Load() != link {
+			return
+		}
+		if n := client.MaxWriteLen(); n != last {
+			last = n
+			p.transport.SetMTU(id, n)
+			p.publishStatus(fmt.Sprintf("Link MTU is now %d bytes", n))
+		}
+	}
+}
@@ -0,0 +1,226 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+	"tinygo.org/x/bluetooth"
+)
+
+const (
+	advMonitorRoot  = dbus.ObjectPath("/org/bluetalk/monitor")
+	advMonitorPath  = advMonitorRoot + "/0"
+	advMonitorIface = "org.bluez.AdvertisementMonitor1"
+
+	// adTypeComplete128 is the AD type for a complete list of 128-bit
+	// service class UUIDs.
+	adTypeComplete128 = 0x07
+)
+
+// advPattern is one (start, AD type, value) entry of an or_patterns monitor.
+type advPattern struct {
+	Start  byte
+	ADType byte
+	Value  []byte
+}
+
+// advMonitor is an org.bluez.AdvertisementMonitor1 that matches adverts
+// carrying the BlueTalk service UUID. The controller (or bluetoothd) filters
+// adverts for us, so no StartDiscovery loop has to run while it is active.
+type advMonitor struct {
+	conn        *dbus.Conn
+	adapterPath dbus.ObjectPath
+	service     []byte
+	onFound     func(bluetooth.ScanResult)
+}
+
+// startMonitor registers an advertisement monitor on the adapter and calls
+// onFound with the advert BlueZ holds for every BlueTalk device the monitor
+// reports. The returned stop function unregisters it. ErrUnsupported is
+// returned when bluetoothd does not provide the AdvertisementMonitor API.
+func (p *Peer) startMonitor(onFound func(bluetooth.ScanResult)) (stop func(), err error) {
+	caps, err := detectBlueZCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	if err := requireBlueZ("advertisement monitor", caps.AdvertisementMonitor, minBlueZAdvMonitor, caps.Version); err != nil {
+		return nil, err
+	}
+
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, fmt.Errorf("system bus: %w", err)
+	}
+
+	m := &advMonitor{
+		conn:        conn,
+		adapterPath: adapterPath(),
+		service:     p.currentConfig().ServiceUUID,
+		onFound:     onFound,
+	}
+	if err := m.register(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = conn.Object("org.bluez", m.adapterPath).
+			Call("org.bluez.AdvertisementMonitorManager1.UnregisterMonitor", 0, advMonitorRoot).Err
+		_ = conn.Close()
+	}, nil
+}
+
+func (m *advMonitor) register() error {
+	if err := m.conn.Export(m, advMonitorPath, advMonitorIface); err != nil {
+		return err
+	}
+	if err := m.conn.Export(m, advMonitorPath, "org.freedesktop.DBus.Properties"); err != nil {
+		return err
+	}
+	if err := m.conn.Export(m, advMonitorRoot, "org.freedesktop.DBus.ObjectManager"); err != nil {
+		return err
+	}
+
+	err := m.conn.Object("org.bluez", m.adapterPath).
+		Call("org.bluez.AdvertisementMonitorManager1.RegisterMonitor", 0, advMonitorRoot).Err
+	if err != nil {
+		return fmt.Errorf("register advertisement monitor: %w", mapPlatformError(err))
+	}
+	return nil
+}
+
+func (m *advMonitor) properties() map[string]dbus.Variant {
+	uuid := bytesToUUID(m.service).Bytes()
+	return map[string]dbus.Variant{
+		"Type": dbus.MakeVariant("or_patterns"),
+		"Patterns": dbus.MakeVariant([]advPattern{{
+			Start:  0,
+			ADType: adTypeComplete128,
+			Value:  uuid[:],
+		}}),
+	}
+}
+
+// GetManagedObjects implements org.freedesktop.DBus.ObjectManager.
+func (m *advMonitor) GetManagedObjects() (map[dbus.ObjectPath]map[string]map[string]dbus.Variant, *dbus.Error) {
+	return map[dbus.ObjectPath]map[string]map[string]dbus.Variant{
+		advMonitorPath: {advMonitorIface: m.properties()},
+	}, nil
+}
+
+// Get implements org.freedesktop.DBus.Properties.
+func (m *advMonitor) Get(iface, name string) (dbus.Variant, *dbus.Error) {
+	v, ok := m.properties()[name]
+	if iface != advMonitorIface || !ok {
+		return dbus.Variant{}, dbus.NewError("org.freedesktop.DBus.Error.InvalidArgs", []any{"no such property " + name})
+	}
+	return v, nil
+}
+
+// GetAll implements org.freedesktop.DBus.Properties.
+func (m *advMonitor) GetAll(iface string) (map[string]dbus.Variant, *dbus.Error) {
+	if iface != advMonitorIface {
+		return nil, nil
+	}
+	return m.properties(), nil
+}
+
+// Release is called by bluetoothd when the monitor is removed.
+func (m *advMonitor) Release() *dbus.Error {
+	return nil
+}
+
+// Activate is called by bluetoothd once the monitor is in effect.
+func (m *advMonitor) Activate() *dbus.Error {
+	return nil
+}
+
+// DeviceFound is called for each device whose adverts match the patterns.
+func (m *advMonitor) DeviceFound(device dbus.ObjectPath) *dbus.Error {
+	var props map[string]dbus.Variant
+	err := m.conn.Object("org.bluez", device).
+		Call("org.freedesktop.DBus.Properties.GetAll", 0, "org.bluez.Device1").Store(&props)
+	if err != nil {
+		return nil
+	}
+	addrStr, _ := props["Address"].Value().(string)
+	mac, err := bluetooth.ParseMAC(addrStr)
+	if err != nil {
+		return nil
+	}
+
+	rssi, _ := props["RSSI"].Value().(int16)
+	result := bluetooth.ScanResult{
+		Address:              bluetooth.Address{MACAddress: bluetooth.MACAddress{MAC: mac}},
+		RSSI:                 rssi,
+		AdvertisementPayload: newMonitorPayload(props),
+	}
+	go m.onFound(result)
+	return nil
+}
+
+// monitorPayload is the advertisement data BlueZ keeps for a device a monitor
+// matched, in the form scan results carry it.
+type monitorPayload struct {
+	name         string
+	uuids        []bluetooth.UUID
+	manufacturer []bluetooth.ManufacturerDataElement
+	service      []bluetooth.ServiceDataElement
+}
+
+func newMonitorPayload(props map[string]dbus.Variant) *monitorPayload {
+	payload := &monitorPayload{}
+	if name, ok := props["Name"].Value().(string); ok {
+		payload.name = strings.TrimSpace(name)
+	}
+	if uuids, ok := props["UUIDs"].Value().([]string); ok {
+		for _, s := range uuids {
+			if uuid, err := bluetooth.ParseUUID(s); err == nil {
+				payload.uuids = append(payload.uuids, uuid)
+			}
+		}
+	}
+	if md, ok := props["ManufacturerData"].Value().(map[uint16]dbus.Variant); ok {
+		for id, v := range md {
+			if data, ok := v.Value().([]byte); ok {
+				payload.manufacturer = append(payload.manufacturer, bluetooth.ManufacturerDataElement{CompanyID: id, Data: data})
+			}
+		}
+	}
+	if sd, ok := props["ServiceData"].Value().(map[string]dbus.Variant); ok {
+		for s, v := range sd {
+			uuid, err := bluetooth.ParseUUID(s)
+			data, ok := v.Value().([]byte)
+			if err == nil && ok {
+				payload.service = append(payload.service, bluetooth.ServiceDataElement{UUID: uuid, Data: data})
+			}
+		}
+	}
+	return payload
+}
+
+func (m *monitorPayload) LocalName() string { return m.name }
+
+func (m *monitorPayload) HasServiceUUID(uuid bluetooth.UUID) bool {
+	return slices.Contains(m.uuids, uuid)
+}
+
+func (m *monitorPayload) ServiceUUIDs() []bluetooth.UUID { return m.uuids }
+
+// Bytes is nil: BlueZ does not keep the raw advert.
+func (m *monitorPayload) Bytes() []byte { return nil }
+
+func (m *monitorPayload) ManufacturerData() []bluetooth.ManufacturerDataElement {
+	return m.manufacturer
+}
+
+func (m *monitorPayload) ServiceData() []bluetooth.ServiceDataElement { return m.service }
+
+// DeviceLost is called when a matched device stops advertising.
+func (m *advMonitor) DeviceLost(device dbus.ObjectPath) *dbus.Error {
+	return nil
+}
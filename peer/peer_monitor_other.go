@@ -0,0 +1,14 @@
+//go:build !linux || simble
+
+package peer
+
+import (
+	"fmt"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// startMonitor is only available with BlueZ's AdvertisementMonitor API.
+func (p *Peer) startMonitor(onFound func(bluetooth.ScanResult)) (stop func(), err error) {
+	return nil, fmt.Errorf("advertisement monitor: %w", ErrUnsupported)
+}
@@ -0,0 +1,83 @@
+package peer
+
+import (
+	"maps"
+	"unicode/utf8"
+
+	"bluetalk/transport"
+)
+
+const (
+	// bluetalkCompanyID keys BlueTalk's manufacturer data. 0xFFFF is the
+	// ID the Bluetooth SIG reserves for unassigned use.
+	bluetalkCompanyID uint16 = 0xFFFF
+
+	// advNicknameMax bounds the nickname carried in manufacturer data, so
+	// it fits a legacy 31-byte advertisement next to the flags, the 128-bit
+	// service UUID and the protocol version. The local name, where the stack
+	// sends one, carries up to localNameMax. Extended adverts carry up to
+	// extNameMax in both.
+	advNicknameMax = 8
+	localNameMax   = 24
+	extNameMax     = 64
+)
+
+// SetNickname sets the display name advertised to scanners, so they can tell
+// peers apart before connecting. It goes in the local name, where the
+// platform sends one, and with the protocol version in BlueTalk's
+// manufacturer data, which Windows also carries. It takes effect the next
+// time the peer starts advertising; an empty name advertises as BlueTalk.
+// Connected peers are sent the new name with our presence at once.
+func (p *Peer) SetNickname(name string) {
+	p.advMu.Lock()
+	p.nickname = name
+	p.advMu.Unlock()
+	p.announcePresence()
+}
+
+// withNickname adds the nickname to an advertisement set, unless the set
+// names itself, other than with the generic name, or already carries
+// BlueTalk manufacturer data. An extended advert has room for more of it.
+func withNickname(data AdvertisementData, nickname, generic string, extended bool) AdvertisementData {
+	if nickname == "" {
+		return data
+	}
+	nameMax, payloadMax := localNameMax, advNicknameMax
+	if extended {
+		nameMax, payloadMax = extNameMax, extNameMax
+	}
+	if data.LocalName == "" || data.LocalName == generic {
+		data.LocalName = truncateUTF8(nickname, nameMax)
+	}
+	if _, ok := data.ManufacturerData[bluetalkCompanyID]; !ok {
+		data.ManufacturerData = maps.Clone(data.ManufacturerData)
+		if data.ManufacturerData == nil {
+			data.ManufacturerData = make(map[uint16][]byte)
+		}
+		data.ManufacturerData[bluetalkCompanyID] = nicknamePayload(nickname, payloadMax)
+	}
+	return data
+}
+
+// nicknamePayload is BlueTalk's manufacturer data: the protocol version
+// followed by up to max bytes of the nickname.
+func nicknamePayload(nickname string, max int) []byte {
+	return append([]byte{transport.ProtocolVersion}, truncateUTF8(nickname, max)...)
+}
+
+// parseNicknamePayload reverses nicknamePayload.
+func parseNicknamePayload(b []byte) (version byte, nickname string, ok bool) {
+	if len(b) == 0 {
+		return 0, "", false
+	}
+	return b[0], string(b[1:]), true
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character.
+func truncateUTF8(s string, n int) string {
+	for len(s) > n {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	return s
+}
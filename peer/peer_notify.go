@@ -0,0 +1,91 @@
+package peer
+
+import "bluetalk/transport"
+
+// NotificationHooks let a frontend raise desktop notifications for what the
+// user would want to hear about without reading status lines. Each hook
+// runs on a goroutine of its own, so it may block on the notification
+// daemon, and nil hooks are skipped. Peer is the remote address and Name
+// how to show it: the nickname it announced, the name it proved, the name
+// it advertises, or else its address.
+type NotificationHooks struct {
+	OnMessage       func(msg MessageNotification)
+	OnPeerConnected func(peer, name string)
+	OnPeerLost      func(peer, name, reason string)
+}
+
+// MessageNotification is a chat message as OnMessage hooks receive it.
+type MessageNotification struct {
+	Peer string
+	Name string
+	ID   uint32
+	Text string
+}
+
+// SetNotificationHooks replaces the notification hooks.
+func (p *Peer) SetNotificationHooks(h NotificationHooks) {
+	p.hooks.Store(&h)
+}
+
+// notify runs the hook for ev, if it has one.
+func (p *Peer) notify(ev PeerEvent) {
+	h := p.hooks.Load()
+	if h == nil {
+		return
+	}
+	switch ev := ev.(type) {
+	case Connected:
+		if fn := h.OnPeerConnected; fn != nil {
+			go fn(ev.Peer, p.displayName(ev.Peer))
+		}
+	case Disconnected:
+		if fn := h.OnPeerLost; fn != nil && ev.Peer != "" {
+			go fn(ev.Peer, p.displayName(ev.Peer), ev.Reason)
+		}
+	}
+}
+
+// messageReceived passes incoming messages to the OnMessage callback, and
+// chat on as a MessageReceived event and to the OnMessage notification
+// hook.
+func (p *Peer) messageReceived(m transport.Message) {
+	if fn := p.onMessage.Load(); fn != nil {
+		(*fn)(m)
+	}
+	if m.Kind != transport.KindChat {
+		return
+	}
+	name := p.displayName(m.From)
+	p.emit(MessageReceived{Peer: m.From, Name: name, ID: m.ID, Text: string(m.Data)})
+	if h := p.hooks.Load(); h != nil && h.OnMessage != nil {
+		go h.OnMessage(MessageNotification{Peer: m.From, Name: name, ID: m.ID, Text: string(m.Data)})
+	}
+}
+
+// displayName is how to show the peer connected as addr. Its presence
+// record is looked up by address, as the link may already be gone.
+func (p *Peer) displayName(addr string) string {
+	var latest *PeerPresence
+	p.presence.mu.Lock()
+	for _, pp := range p.presence.peers {
+		if pp.Address == addr && (latest == nil || pp.LastSeen.After(latest.LastSeen)) {
+			latest = pp
+		}
+	}
+	nickname := ""
+	if latest != nil {
+		nickname = latest.Nickname
+	}
+	p.presence.mu.Unlock()
+
+	if nickname != "" {
+		return nickname
+	}
+	if id, ok := p.transport.PeerIdentity(addr); ok && id.Name != "" {
+		return id.Name
+	}
+	if name := p.scanCache.nameOf(addr); name != "" {
+		return name
+	}
+	return addr
+}
@@ -0,0 +1,43 @@
+package peer
+
+import (
+	"errors"
+	"time"
+)
+
+// radioPollInterval is how often a Peer waiting on a radio that is off, or
+// watching one the platform does not report on, checks it again.
+const radioPollInterval = 2 * time.Second
+
+// radioOffErr reports whether err means the radio is off or blocked, which
+// fixes itself once the user switches it back on.
+func radioOffErr(err error) bool {
+	return errors.Is(err, ErrPoweredOff) || errors.Is(err, ErrRadioBlocked)
+}
+
+// waitRadio polls the radio until it is usable, reporting false if the peer
+// is stopped first.
+func (p *Peer) waitRadio() bool {
+	for radioOffErr(radioReady()) {
+		if !p.pause(radioPollInterval) {
+			return false
+		}
+	}
+	return true
+}
+
+// watchRadio reports the radio going off and coming back while the peer
+// runs, on platforms that do not report it themselves.
+func (p *Peer) watchRadio() {
+	if platformReportsPower {
+		return
+	}
+	on := true
+	for p.pause(radioPollInterval) {
+		err := radioReady()
+		if now := !radioOffErr(err); now != on {
+			on = now
+			p.emit(PowerChanged{On: on, Blocked: errors.Is(err, ErrRadioBlocked), Err: err})
+		}
+	}
+}
@@ -0,0 +1,60 @@
+//go:build linux && !simble
+
+package peer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// platformReportsPower is false: BlueZ signals Powered changes, but polling
+// the property and rfkill also catches blocks that never reach bluetoothd.
+const platformReportsPower = false
+
+// radioReady returns ErrRadioBlocked while rfkill blocks Bluetooth and
+// ErrPoweredOff while the adapter is powered off. It returns nil when the
+// radio is on or its state cannot be read, leaving the failure to whatever
+// uses the radio next.
+func radioReady() error {
+	if soft, hard := rfkillBlocked(); hard {
+		return fmt.Errorf("%w by a hardware switch", ErrRadioBlocked)
+	} else if soft {
+		return fmt.Errorf("%w (rfkill unblock bluetooth)", ErrRadioBlocked)
+	}
+
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil
+	}
+	v, err := conn.Object("org.bluez", adapterPath()).GetProperty("org.bluez.Adapter1.Powered")
+	if err != nil {
+		return nil
+	}
+	if powered, ok := v.Value().(bool); ok && !powered {
+		return fmt.Errorf("%w (bluetoothctl power on)", ErrPoweredOff)
+	}
+	return nil
+}
+
+// rfkillBlocked reports whether any Bluetooth rfkill switch is soft or hard
+// blocked.
+func rfkillBlocked() (soft, hard bool) {
+	switches, _ := filepath.Glob("/sys/class/rfkill/rfkill*")
+	for _, dir := range switches {
+		if readSysfs(filepath.Join(dir, "type")) != "bluetooth" {
+			continue
+		}
+		soft = soft || readSysfs(filepath.Join(dir, "soft")) == "1"
+		hard = hard || readSysfs(filepath.Join(dir, "hard")) == "1"
+	}
+	return soft, hard
+}
+
+func readSysfs(path string) string {
+	b, _ := os.ReadFile(path)
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,153 @@
+package peer
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"bluetalk/transport"
+)
+
+// PeerPresence is what is known of a peer's availability. Peers are told
+// apart by identity Fingerprint when they have proved one, so a peer keeps
+// its record when it reconnects from another address; otherwise by Address.
+// LastSeen is when the peer was last heard from, and Online whether it is
+// connected now.
+type PeerPresence struct {
+	Address     string
+	Fingerprint string
+	Nickname    string
+	Status      transport.PresenceStatus
+	Online      bool
+	LastSeen    time.Time
+}
+
+// Label names the peer for display: its nickname, or else its address.
+func (pp PeerPresence) Label() string {
+	if pp.Nickname != "" {
+		return pp.Nickname
+	}
+	return pp.Address
+}
+
+// PresenceChanged is reported when a peer comes online, goes offline, or
+// changes its status or nickname.
+type PresenceChanged struct {
+	Presence PeerPresence
+}
+
+func (e PresenceChanged) Status() string {
+	if !e.Presence.Online || e.Presence.Status == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s is %s", e.Presence.Label(), e.Presence.Status)
+}
+
+// presenceBook tracks the presence of every peer seen since start.
+type presenceBook struct {
+	mu    sync.Mutex
+	peers map[string]*PeerPresence
+}
+
+// SetPresence sets the status announced to peers, along with the nickname
+// from SetNickname. Peers start out online.
+func (p *Peer) SetPresence(status transport.PresenceStatus) {
+	p.advMu.Lock()
+	p.presenceStatus = status
+	nickname := p.nickname
+	p.advMu.Unlock()
+	p.transport.SetPresence(transport.Presence{Status: status, Nickname: nickname})
+}
+
+// announcePresence resends our presence after the nickname changed.
+func (p *Peer) announcePresence() {
+	p.advMu.Lock()
+	status, nickname := p.presenceStatus, p.nickname
+	p.advMu.Unlock()
+	p.transport.SetPresence(transport.Presence{Status: status, Nickname: nickname})
+}
+
+// Presence lists every peer seen since the Peer was created, most recently
+// seen first.
+func (p *Peer) Presence() []PeerPresence {
+	p.presence.mu.Lock()
+	out := make([]PeerPresence, 0, len(p.presence.peers))
+	for _, pp := range p.presence.peers {
+		out = append(out, *pp)
+	}
+	p.presence.mu.Unlock()
+	slices.SortFunc(out, func(a, b PeerPresence) int {
+		return cmp.Compare(b.LastSeen.UnixNano(), a.LastSeen.UnixNano())
+	})
+	return out
+}
+
+// updatePresence applies change to the record of the peer connected as
+// addr, creating it if needed, and reports the change if it is one a UI
+// shows.
+func (p *Peer) updatePresence(addr string, change func(pp *PeerPresence)) {
+	key := addr
+	if id, ok := p.transport.PeerIdentity(addr); ok {
+		key = id.Fingerprint()
+	}
+
+	p.presence.mu.Lock()
+	if p.presence.peers == nil {
+		p.presence.peers = make(map[string]*PeerPresence)
+	}
+	pp, ok := p.presence.peers[key]
+	if !ok {
+		pp = &PeerPresence{Address: addr}
+		p.presence.peers[key] = pp
+	}
+	before := *pp
+	change(pp)
+	pp.LastSeen = time.Now()
+	after := *pp
+	p.presence.mu.Unlock()
+
+	if before.Online != after.Online || before.Status != after.Status || before.Nickname != after.Nickname {
+		p.emit(PresenceChanged{Presence: after})
+	}
+}
+
+// presenceReceived records a presence announcement from peer addr.
+func (p *Peer) presenceReceived(addr string, presence transport.Presence) {
+	p.updatePresence(addr, func(pp *PeerPresence) {
+		pp.Online = true
+		pp.Status = presence.Status
+		pp.Nickname = presence.Nickname
+	})
+}
+
+// presenceIdentified moves the record of the peer connected as addr under
+// the identity it proved, merging it into the one kept from earlier
+// connections.
+func (p *Peer) presenceIdentified(addr string, id transport.Identity) {
+	fp := id.Fingerprint()
+	p.presence.mu.Lock()
+	if p.presence.peers == nil {
+		p.presence.peers = make(map[string]*PeerPresence)
+	}
+	current := p.presence.peers[addr]
+	delete(p.presence.peers, addr)
+	pp, ok := p.presence.peers[fp]
+	if !ok {
+		pp = &PeerPresence{}
+		p.presence.peers[fp] = pp
+	}
+	if current != nil {
+		*pp = *current
+	}
+	pp.Address, pp.Fingerprint, pp.LastSeen = addr, fp, time.Now()
+	p.presence.mu.Unlock()
+}
+
+// presenceOffline marks the peer connected as addr as gone.
+func (p *Peer) presenceOffline(addr string) {
+	p.updatePresence(addr, func(pp *PeerPresence) {
+		pp.Online = false
+	})
+}
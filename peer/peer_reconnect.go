@@ -0,0 +1,212 @@
+//go:build linux || windows || darwin
+
+package peer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bluetalk/transport"
+)
+
+// lastPeerFile is where the last connected peer is remembered, under the
+// user's config directory.
+const lastPeerFile = "bluetalk/last_peer.json"
+
+// LastPeer is the peer we were last connected to. AddressType is BlueZ's
+// "public" or "random", where known; Fingerprint and Name are those of the
+// identity it proved, if any.
+type LastPeer struct {
+	Address     string    `json:"address"`
+	AddressType string    `json:"address_type,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	Seen        time.Time `json:"seen"`
+}
+
+// label names the peer for status lines.
+func (l LastPeer) label() string {
+	if l.Name != "" {
+		return fmt.Sprintf("%s (%s)", l.Name, l.Address)
+	}
+	return l.Address
+}
+
+// ReconnectPolicy is how the Peer gets back to the last peer it was
+// connected to. After losing the link, and on Run when a last peer was
+// saved, it connects to that peer directly, without discovery, retrying per
+// Retry, and only then goes back to discovering. A zero Retry.Attempts turns
+// reconnecting off. Path is the file the last peer is kept in across
+// restarts; empty keeps it in memory only.
+type ReconnectPolicy struct {
+	Path  string
+	Retry RetryPolicy
+}
+
+var defaultReconnectPolicy = ReconnectPolicy{
+	Retry: RetryPolicy{
+		Attempts:       4,
+		Backoff:        time.Second,
+		MaxBackoff:     8 * time.Second,
+		Jitter:         500 * time.Millisecond,
+		AttemptTimeout: 10 * time.Second,
+	},
+}
+
+// DefaultReconnectPolicy returns the policy a new Peer starts with: a few
+// quick attempts, with the last peer kept in memory only.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return defaultReconnectPolicy
+}
+
+// DefaultLastPeerPath returns where the last connected peer is remembered.
+func DefaultLastPeerPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("find config directory: %w", err)
+	}
+	return filepath.Join(dir, lastPeerFile), nil
+}
+
+// SetReconnectPolicy replaces the reconnect policy, loading the last peer
+// saved at policy.Path if there is one. It must be called before Run.
+func (p *Peer) SetReconnectPolicy(policy ReconnectPolicy) error {
+	var last *LastPeer
+	if policy.Path != "" {
+		data, err := os.ReadFile(policy.Path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+		case err != nil:
+			return fmt.Errorf("read last peer: %w", err)
+		default:
+			last = new(LastPeer)
+			if err := json.Unmarshal(data, last); err != nil {
+				return fmt.Errorf("parse last peer %s: %w", policy.Path, err)
+			}
+		}
+	}
+
+	p.mu.Lock()
+	p.reconnectPolicy = policy
+	p.mu.Unlock()
+	if last != nil {
+		p.lastPeerMu.Lock()
+		p.lastPeer = last
+		p.lastPeerMu.Unlock()
+		p.reconnectPending.Store(true)
+	}
+	return nil
+}
+
+func (p *Peer) currentReconnectPolicy() ReconnectPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reconnectPolicy
+}
+
+// LastPeer returns the peer we were last connected to, if any.
+func (p *Peer) LastPeer() (LastPeer, bool) {
+	p.lastPeerMu.Lock()
+	defer p.lastPeerMu.Unlock()
+	if p.lastPeer == nil {
+		return LastPeer{}, false
+	}
+	return *p.lastPeer, true
+}
+
+// rememberPeer records a new connection to id as the last peer and saves it
+// in the background. Callers may hold p.mu.
+func (p *Peer) rememberPeer(id string) {
+	p.lastPeerMu.Lock()
+	if p.lastPeer == nil || p.lastPeer.Address != id {
+		p.lastPeer = &LastPeer{Address: id}
+	}
+	p.lastPeer.Seen = time.Now()
+	p.lastPeerMu.Unlock()
+	go func() { p.saveLastPeer(id, p.addressType(id)) }()
+}
+
+// rememberIdentity adds the identity the peer at addr proved to the last
+// peer record.
+func (p *Peer) rememberIdentity(addr string, id transport.Identity) {
+	p.lastPeerMu.Lock()
+	if p.lastPeer == nil || p.lastPeer.Address != addr {
+		p.lastPeerMu.Unlock()
+		return
+	}
+	p.lastPeer.Fingerprint = id.Fingerprint()
+	p.lastPeer.Name = id.Name
+	p.lastPeerMu.Unlock()
+	p.saveLastPeer(addr, "")
+}
+
+// saveLastPeer writes the last peer to the policy's file, if it is still
+// the peer at addr, filling in its address type if given.
+func (p *Peer) saveLastPeer(addr, addrType string) {
+	path := p.currentReconnectPolicy().Path
+
+	p.lastPeerMu.Lock()
+	defer p.lastPeerMu.Unlock()
+	if p.lastPeer == nil || p.lastPeer.Address != addr {
+		return
+	}
+	if addrType != "" {
+		p.lastPeer.AddressType = addrType
+	}
+	if path == "" {
+		return
+	}
+	if err := writeLastPeer(path, *p.lastPeer); err != nil {
+		p.publishStatus(fmt.Sprintf("Could not save the last peer: %v", err))
+	}
+}
+
+func writeLastPeer(path string, last LastPeer) error {
+	data, err := json.MarshalIndent(last, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// reconnect connects straight back to the last peer after the link to it
+// was lost, before discovery starts over. It reports whether it connected.
+func (p *Peer) reconnect() bool {
+	if !p.reconnectPending.Swap(false) || p.currentConfig().Role == RoleHost {
+		return false
+	}
+	last, ok := p.LastPeer()
+	policy := p.currentReconnectPolicy()
+	if !ok || policy.Retry.Attempts <= 0 || !p.currentAccessList().admitsPeer(last.Address, last.Fingerprint) {
+		return false
+	}
+	addr, err := parseAddress(last.Address)
+	if err != nil {
+		return false
+	}
+
+	p.publishStatus(fmt.Sprintf("Reconnecting to %s...", last.label()))
+	err = p.prepareDirectConnect(last.Address, last.AddressType)
+	if err == nil {
+		err = p.connectWith(addr, policy.Retry)
+	}
+	if err != nil {
+		if p.ctx.Err() == nil {
+			p.emit(Error{Op: "Reconnect failed, looking for peers instead", Err: err})
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+//go:build linux || windows || darwin
+
+package peer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"maps"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// roomIDSize is the length of the room ID carried in service data: enough
+// to keep a handful of groups in one room apart, small enough to fit next to
+// everything else an advertisement holds.
+const roomIDSize = 4
+
+// roomID derives the advertised ID of a named room.
+func roomID(room string) []byte {
+	sum := sha256.Sum256([]byte("bluetalk room\x00" + room))
+	return sum[:roomIDSize]
+}
+
+// SetRoom puts the peer in a named room, so several BlueTalk groups can share
+// a space without connecting across. The room's ID is advertised as service
+// data of the BlueTalk service, and discovery only reports peers advertising
+// the same one. An empty room is the default lobby, which only matches peers
+// advertising no room. Only BlueZ sends service data, so Windows and macOS
+// peers can only be found from the lobby. It takes effect on the next scan
+// and advertisement.
+func (p *Peer) SetRoom(room string) {
+	var id []byte
+	if room != "" {
+		id = roomID(room)
+	}
+	p.advMu.Lock()
+	defer p.advMu.Unlock()
+	p.room = id
+}
+
+func (p *Peer) currentRoom() []byte {
+	p.advMu.Lock()
+	defer p.advMu.Unlock()
+	return p.room
+}
+
+// withRoom adds the room ID to an advertisement set, as service data of
+// service.
+func withRoom(data AdvertisementData, service, room []byte) AdvertisementData {
+	if room == nil {
+		return data
+	}
+	key := bytesToUUID(service).String()
+	data.ServiceData = maps.Clone(data.ServiceData)
+	if data.ServiceData == nil {
+		data.ServiceData = make(map[string][]byte)
+	}
+	data.ServiceData[key] = room
+	return data
+}
+
+// advertisedRoom returns the room ID a scan result carries as service data
+// of service, or nil for the lobby.
+func advertisedRoom(result bluetooth.ScanResult, service []byte) []byte {
+	svc := bytesToUUID(service)
+	for _, sd := range result.ServiceData() {
+		if sd.UUID == svc && len(sd.Data) >= roomIDSize {
+			return sd.Data[:roomIDSize]
+		}
+	}
+	return nil
+}
+
+// scanMatcher returns the test a scan result must pass to be reported: the
+// scan filter, the access list, checked against the advertised fingerprint
+// too, and the room, as they are when scanning starts.
+func (p *Peer) scanMatcher() func(bluetooth.ScanResult) bool {
+	filter, access, room := p.currentScanFilter(), p.currentAccessList(), p.currentRoom()
+	cfg := p.currentConfig()
+	rx := bytesToUUID(cfg.RXUUID)
+	return func(result bluetooth.ScanResult) bool {
+		return filter.matches(result) &&
+			access.admitsAddress(result.Address.String()) &&
+			!access.blocksFingerprint(advertisedFingerprint(result, rx)) &&
+			bytes.Equal(advertisedRoom(result, cfg.ServiceUUID), room)
+	}
+}
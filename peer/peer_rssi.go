@@ -0,0 +1,97 @@
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// rssiHysteresis is how far above the weak threshold the signal must climb
+// before the link counts as healthy again, so a signal hovering at the
+// threshold does not warn on every reading.
+const rssiHysteresis = 5
+
+// RSSIMonitor configures how the connected peer's signal strength is
+// followed. Every Interval the RSSI is read and reported as a SignalStrength
+// event; when it drops below WeakBelow (dBm) a WeakLink warning is sent, and
+// another once it recovers. A zero Interval turns monitoring off, and a zero
+// WeakBelow the warnings.
+type RSSIMonitor struct {
+	Interval  time.Duration
+	WeakBelow int16
+}
+
+var defaultRSSIMonitor = RSSIMonitor{
+	Interval:  2 * time.Second,
+	WeakBelow: -85,
+}
+
+// SetRSSIMonitor replaces the signal strength monitor settings. It takes
+// effect on the next connection.
+func (p *Peer) SetRSSIMonitor(m RSSIMonitor) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rssiMonitor = m
+}
+
+func (p *Peer) currentRSSIMonitor() RSSIMonitor {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rssiMonitor
+}
+
+// SignalStrength is reported with every RSSI reading of the connected peer.
+type SignalStrength struct {
+	Peer string
+	RSSI int16
+}
+
+func (SignalStrength) Status() string { return "" }
+
+// WeakLink is reported when the connected peer's signal falls below the
+// RSSIMonitor threshold, and with Recovered set once it is back above it.
+type WeakLink struct {
+	Peer      string
+	RSSI      int16
+	Recovered bool
+}
+
+func (e WeakLink) Status() string {
+	if e.Recovered {
+		return fmt.Sprintf("Signal from %s has recovered (%d dBm)", e.Peer, e.RSSI)
+	}
+	return fmt.Sprintf("Weak signal from %s (%d dBm): moving further away may drop the connection", e.Peer, e.RSSI)
+}
+
+// watchRSSI reads the signal strength of link's peer id until the link goes
+// away or the platform turns out unable to report it. Failed readings, as
+// when the stack has no recent value, are skipped.
+func (p *Peer) watchRSSI(link *bleLink, id string) {
+	m := p.currentRSSIMonitor()
+	if m.Interval <= 0 {
+		return
+	}
+	weak := false
+	for p.pause(m.Interval) && p.link.Load() == link {
+		rssi, err := p.readRSSI(id)
+		if errors.Is(err, ErrUnsupported) {
+			return
+		}
+		if err != nil || rssi == 0 {
+			continue
+		}
+		link.rssi.Store(int32(rssi))
+		p.emit(SignalStrength{Peer: id, RSSI: rssi})
+		if m.WeakBelow == 0 {
+			continue
+		}
+		switch {
+		case !weak && rssi < m.WeakBelow:
+			weak = true
+			p.emit(WeakLink{Peer: id, RSSI: rssi})
+		case weak && rssi >= m.WeakBelow+rssiHysteresis:
+			weak = false
+			p.emit(WeakLink{Peer: id, RSSI: rssi, Recovered: true})
+		}
+	}
+}
@@ -0,0 +1,682 @@
+//go:build simble
+
+package peer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// Builds tagged simble replace Bluetooth with a simulated adapter, so the chat
+// stack can be developed and demoed on one machine. A peer advertises by
+// keeping a file in a shared directory up to date, which scanning peers read,
+// and takes connections over TCP on localhost. Peers in one process and peers
+// in separate processes find each other alike, as long as they share the
+// directory: $BLUETALK_SIM_DIR, or bluetalk-sim in the temporary directory.
+
+const (
+	// simMTU is the largest packet a simulated link carries, that of a
+	// BLE link with the largest common ATT MTU.
+	simMTU = 244
+
+	// simRSSI is the signal strength every simulated peer reports.
+	simRSSI = -50
+
+	// simAdvertRefresh is how often an advertising peer rewrites its
+	// advert, and simAdvertExpiry how old an advert may get before
+	// scanners take it for a peer that stopped advertising or crashed.
+	simAdvertRefresh = time.Second
+	simAdvertExpiry  = 3 * time.Second
+
+	// simScanInterval is how often scanning and connecting peers read the
+	// adverts.
+	simScanInterval = 200 * time.Millisecond
+
+	// simHandshakeTimeout bounds a new connection exchanging addresses.
+	simHandshakeTimeout = 5 * time.Second
+)
+
+// simRadios holds the simulated radio of every running Peer, keyed by *Peer.
+var simRadios sync.Map
+
+// simRadio is one Peer's simulated adapter: its address, the directory it
+// shares adverts through and the listener centrals connect to.
+type simRadio struct {
+	addr string
+	dir  string
+	ln   net.Listener
+
+	mu       sync.Mutex
+	advStop  chan struct{}
+	advDone  chan struct{}
+	scanStop chan struct{}
+}
+
+// simAdvert is the advert a simulated peripheral publishes. Listen is the TCP
+// address it takes connections on.
+type simAdvert struct {
+	Address          string
+	Listen           string
+	LocalName        string
+	ServiceUUIDs     []string
+	ManufacturerData map[uint16][]byte
+	ServiceData      map[string][]byte
+	Updated          time.Time
+}
+
+func (p *Peer) sim() (*simRadio, error) {
+	if r, ok := simRadios.Load(p); ok {
+		return r.(*simRadio), nil
+	}
+	return nil, ErrNotReady
+}
+
+// simDir returns the directory simulated peers share adverts through.
+func simDir() string {
+	if dir := os.Getenv("BLUETALK_SIM_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "bluetalk-sim")
+}
+
+func bytesToUUID(b []byte) bluetooth.UUID {
+	var arr [16]byte
+	copy(arr[:], b)
+	return bluetooth.NewUUID(arr)
+}
+
+func (p *Peer) setupPlatform() error {
+	dir := simDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("simulated adapter: %w", err)
+	}
+	addr, err := newSimAddress()
+	if err != nil {
+		return fmt.Errorf("simulated adapter: %w", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("simulated adapter: %w", err)
+	}
+
+	r := &simRadio{addr: addr, dir: dir, ln: ln}
+	simRadios.Store(p, r)
+	context.AfterFunc(p.ctx, func() {
+		_ = ln.Close()
+		simRadios.Delete(p)
+	})
+	p.wg.Go(func() { p.serveSim(r) })
+	p.publishStatus(fmt.Sprintf("Simulated adapter enabled, sharing adverts in %s", dir))
+	return nil
+}
+
+const platformReportsPower = false
+
+// radioReady always succeeds: the simulated radio cannot be switched off.
+func radioReady() error {
+	return nil
+}
+
+func (p *Peer) startAdvertising(data AdvertisementData) error {
+	r, err := p.sim()
+	if err != nil {
+		return err
+	}
+	adv := simAdvert{
+		Address:          r.addr,
+		Listen:           r.ln.Addr().String(),
+		LocalName:        data.LocalName,
+		ServiceUUIDs:     []string{bytesToUUID(p.currentConfig().ServiceUUID).String()},
+		ManufacturerData: data.ManufacturerData,
+		ServiceData:      data.ServiceData,
+	}
+	for uuidStr := range data.ServiceData {
+		if _, err := bluetooth.ParseUUID(uuidStr); err != nil {
+			return fmt.Errorf("invalid service data UUID %q: %w", uuidStr, err)
+		}
+	}
+
+	r.stopAdvert()
+	if err := r.writeAdvert(adv); err != nil {
+		return fmt.Errorf("advertise: %w", err)
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	r.mu.Lock()
+	r.advStop, r.advDone = stop, done
+	r.mu.Unlock()
+	go r.refreshAdvert(p.ctx, adv, stop, done)
+	return nil
+}
+
+// extendedAdvertising is true: simulated adverts have no size limit.
+func (p *Peer) extendedAdvertising() bool {
+	return true
+}
+
+func (p *Peer) stopAdvertising() error {
+	if r, err := p.sim(); err == nil {
+		r.stopAdvert()
+	}
+	return nil
+}
+
+// refreshAdvert keeps adv fresh until stop is closed or ctx ends, and then
+// withdraws it.
+func (r *simRadio) refreshAdvert(ctx context.Context, adv simAdvert, stop, done chan struct{}) {
+	defer close(done)
+	defer os.Remove(r.advertPath(r.addr))
+
+	ticker := time.NewTicker(simAdvertRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_ = r.writeAdvert(adv)
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stopAdvert withdraws the advert, if any, and waits until it is gone.
+func (r *simRadio) stopAdvert() {
+	r.mu.Lock()
+	stop, done := r.advStop, r.advDone
+	r.advStop, r.advDone = nil, nil
+	r.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (r *simRadio) advertPath(addr string) string {
+	return filepath.Join(r.dir, strings.NewReplacer(":", "", "-", "").Replace(addr)+".json")
+}
+
+// writeAdvert publishes adv, stamped with the current time. The file is
+// replaced whole, so scanners never read half of it.
+func (r *simRadio) writeAdvert(adv simAdvert) error {
+	adv.Updated = time.Now()
+	data, err := json.Marshal(adv)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(r.dir, ".advert-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), r.advertPath(adv.Address))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// readAdvert returns the current advert of peer addr, if it is advertising.
+func (r *simRadio) readAdvert(addr string) (simAdvert, bool) {
+	var adv simAdvert
+	data, err := os.ReadFile(r.advertPath(addr))
+	if err != nil || json.Unmarshal(data, &adv) != nil {
+		return adv, false
+	}
+	return adv, time.Since(adv.Updated) < simAdvertExpiry
+}
+
+// adverts returns the current adverts of every other simulated peer.
+func (r *simRadio) adverts() []simAdvert {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil
+	}
+	var advs []simAdvert
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if adv, ok := r.readAdvert(name); ok && adv.Address != r.addr {
+			advs = append(advs, adv)
+		}
+	}
+	return advs
+}
+
+func (p *Peer) startScanning(callback func(bluetooth.ScanResult)) error {
+	r, err := p.sim()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	if r.scanStop != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("scan: %w", ErrInProgress)
+	}
+	stop := make(chan struct{})
+	r.scanStop = stop
+	r.mu.Unlock()
+
+	match := p.scanMatcher()
+	ticker := time.NewTicker(simScanInterval)
+	defer ticker.Stop()
+	for {
+		for _, adv := range r.adverts() {
+			addr, err := parseAddress(adv.Address)
+			if err != nil {
+				continue
+			}
+			result := bluetooth.ScanResult{
+				Address:              addr,
+				RSSI:                 simRSSI,
+				AdvertisementPayload: newSimPayload(adv),
+			}
+			if match(result) {
+				callback(result)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return nil
+		case <-p.ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (p *Peer) stopScan() error {
+	r, err := p.sim()
+	if err != nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.scanStop != nil {
+		close(r.scanStop)
+		r.scanStop = nil
+	}
+	return nil
+}
+
+// simPayload is a simulated advert in the form scan results carry it.
+type simPayload struct {
+	name         string
+	uuids        []bluetooth.UUID
+	manufacturer []bluetooth.ManufacturerDataElement
+	service      []bluetooth.ServiceDataElement
+}
+
+func newSimPayload(adv simAdvert) *simPayload {
+	payload := &simPayload{name: adv.LocalName}
+	for _, s := range adv.ServiceUUIDs {
+		if uuid, err := bluetooth.ParseUUID(s); err == nil {
+			payload.uuids = append(payload.uuids, uuid)
+		}
+	}
+	for id, data := range adv.ManufacturerData {
+		payload.manufacturer = append(payload.manufacturer, bluetooth.ManufacturerDataElement{CompanyID: id, Data: data})
+	}
+	for s, data := range adv.ServiceData {
+		if uuid, err := bluetooth.ParseUUID(s); err == nil {
+			payload.service = append(payload.service, bluetooth.ServiceDataElement{UUID: uuid, Data: data})
+		}
+	}
+	return payload
+}
+
+func (m *simPayload) LocalName() string { return m.name }
+
+func (m *simPayload) HasServiceUUID(uuid bluetooth.UUID) bool {
+	return slices.Contains(m.uuids, uuid)
+}
+
+func (m *simPayload) ServiceUUIDs() []bluetooth.UUID { return m.uuids }
+
+// Bytes is nil: simulated adverts have no over-the-air form.
+func (m *simPayload) Bytes() []byte { return nil }
+
+func (m *simPayload) ManufacturerData() []bluetooth.ManufacturerDataElement {
+	return m.manufacturer
+}
+
+func (m *simPayload) ServiceData() []bluetooth.ServiceDataElement { return m.service }
+
+// simConn is a simulated link: packets travel over TCP, each prefixed with
+// its length.
+type simConn struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+func (c *simConn) writePacket(data []byte) error {
+	if len(data) > simMTU {
+		return fmt.Errorf("packet of %d bytes exceeds the %d byte MTU", len(data), simMTU)
+	}
+	frame := binary.LittleEndian.AppendUint16(make([]byte, 0, 2+len(data)), uint16(len(data)))
+	frame = append(frame, data...)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *simConn) readPacket() ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.conn, hdr[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.LittleEndian.Uint16(hdr[:]))
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Write notifies the connected central of a packet.
+func (c *simConn) Write(data []byte) (int, error) {
+	if err := c.writePacket(data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (c *simConn) Close() error {
+	return c.conn.Close()
+}
+
+// serveSim takes connections from centrals until the Peer stops.
+func (p *Peer) serveSim(r *simRadio) {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.acceptSim(r, &simConn{conn: conn})
+	}
+}
+
+// acceptSim takes a connection from a central that found our advert, which
+// opens by sending its address; we answer with ours once we accept it. Like
+// acceptCentral, it only accepts while advertising.
+func (p *Peer) acceptSim(r *simRadio, c *simConn) {
+	_ = c.conn.SetDeadline(time.Now().Add(simHandshakeTimeout))
+	hello, err := c.readPacket()
+	if err != nil {
+		_ = c.Close()
+		return
+	}
+	id := string(hello)
+	if !p.advertising.Load() {
+		_ = c.Close()
+		return
+	}
+	if !p.currentAccessList().admitsAddress(id) {
+		_ = c.Close()
+		p.publishStatus(fmt.Sprintf("Refused connection from %s: not allowed", id))
+		return
+	}
+	if p.connected.Load() && !p.takeIncoming(id) {
+		_ = c.Close()
+		return
+	}
+	if err := c.writePacket([]byte(r.addr)); err != nil {
+		_ = c.Close()
+		return
+	}
+	_ = c.conn.SetDeadline(time.Time{})
+
+	p.peripheralNotifierMu.Lock()
+	p.peripheralNotifier = c
+	p.peripheralNotifierMu.Unlock()
+
+	p.setConnectedAsPeripheral(id, simMTU)
+	p.emit(Connected{Peer: id})
+
+	for {
+		packet, err := c.readPacket()
+		if err != nil {
+			p.centralGone(id)
+			return
+		}
+		if p.servingCentral(id) {
+			p.receivePacket(packet)
+		}
+	}
+}
+
+// dialSim connects to simulated peer id, waiting until it advertises, as
+// BlueZ does.
+func (r *simRadio) dialSim(ctx context.Context, id string) (*simConn, error) {
+	ticker := time.NewTicker(simScanInterval)
+	defer ticker.Stop()
+	adv, ok := r.readAdvert(id)
+	for !ok {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("connect to %s: %w", id, ctx.Err())
+		}
+		adv, ok = r.readAdvert(id)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", adv.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", id, err)
+	}
+	c := &simConn{conn: conn}
+	deadline := time.Now().Add(simHandshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	err = c.writePacket([]byte(r.addr))
+	if err == nil {
+		_, err = c.readPacket()
+	}
+	if err != nil {
+		_ = c.Close()
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("connect to %s: connection refused", id)
+		}
+		return nil, fmt.Errorf("connect to %s: %w", id, err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return c, nil
+}
+
+func (p *Peer) connectAndSubscribePlatform(ctx context.Context, addr bluetooth.Address) error {
+	r, err := p.sim()
+	if err != nil {
+		return err
+	}
+	id := addr.String()
+	c, err := r.dialSim(ctx, id)
+	if err != nil {
+		return fmt.Errorf("connection failed: %w", err)
+	}
+
+	client := &CentralClient{conn: c, disconnectedCh: make(chan struct{})}
+	go func() {
+		defer client.signalDisconnect()
+		for {
+			packet, err := c.readPacket()
+			if err != nil {
+				return
+			}
+			p.receivePacket(packet)
+		}
+	}()
+	go func() {
+		<-client.Disconnected()
+		p.mu.Lock()
+		current := p.centralClient == centralConn(client)
+		p.mu.Unlock()
+		if current {
+			p.handleDisconnect(fmt.Sprintf("Disconnected from %s", id))
+		}
+	}()
+
+	p.setConnectedAsCentral(client, id)
+	p.emit(Connected{Peer: id, Central: true})
+	return nil
+}
+
+// CentralClient is a simulated connection to a peripheral.
+type CentralClient struct {
+	conn           *simConn
+	disconnectedCh chan struct{}
+	once           sync.Once
+}
+
+func (c *CentralClient) WriteNoResponse(data []byte) error {
+	err := c.conn.writePacket(data)
+	if err != nil {
+		c.signalDisconnect()
+	}
+	return err
+}
+
+func (c *CentralClient) MaxWriteLen() int {
+	return simMTU
+}
+
+func (c *CentralClient) Close() error {
+	c.signalDisconnect()
+	return c.conn.Close()
+}
+
+// Release is Close: a simulated connection shares no link with the other
+// role.
+func (c *CentralClient) Release() error {
+	return c.Close()
+}
+
+func (c *CentralClient) Disconnected() <-chan struct{} {
+	return c.disconnectedCh
+}
+
+func (c *CentralClient) signalDisconnect() {
+	c.once.Do(func() { close(c.disconnectedCh) })
+}
+
+func (p *Peer) runDiscoveryAndConnection() {
+	var rest time.Duration
+	for p.ctx.Err() == nil {
+		if p.connected.Load() {
+			p.waitUntilDisconnected()
+			continue
+		}
+		if p.connectRequested() || p.reconnect() {
+			continue
+		}
+
+		role := p.currentConfig().Role
+		if role != RoleHost {
+			p.emit(ScanStarted{})
+			devices := p.scanWindows(p.currentScanWindows())
+			if p.ctx.Err() != nil {
+				return
+			}
+			if len(devices) > 0 {
+				selected := devices[0]
+				p.publishStatus(fmt.Sprintf("Connecting to %s (%s)...", selected.Name, selected.Address.String()))
+				err := p.connectWithRetry(selected.Address)
+				if err != nil && p.ctx.Err() == nil {
+					p.emit(Error{Op: "Connection failed", Err: err})
+					p.pause(connectRetryDelay(err))
+				}
+				continue
+			}
+		}
+		if role == RoleClient {
+			rest = p.restRadio(rest)
+			continue
+		}
+
+		if role == RoleHost {
+			p.publishStatus("Advertising...")
+		} else {
+			p.publishStatus("No peers found. Advertising...")
+		}
+		if err := p.advertiseFor(p.advertisePhase()); err != nil {
+			p.emit(Error{Op: "Advertising failed", Err: err})
+		}
+		if !p.connected.Load() {
+			rest = p.restRadio(rest)
+		}
+	}
+}
+
+// writePeripheral notifies the connected central of a packet. Callers hold
+// p.mu.
+func (p *Peer) writePeripheral(data []byte) (int, error) {
+	p.peripheralNotifierMu.Lock()
+	n := p.peripheralNotifier
+	p.peripheralNotifierMu.Unlock()
+	if n == nil {
+		return 0, ErrNotConnected
+	}
+	return n.Write(data)
+}
+
+// adapterInfo describes the simulated adapter.
+func (p *Peer) adapterInfo() (AdapterInfo, error) {
+	r, err := p.sim()
+	if err != nil {
+		return AdapterInfo{}, err
+	}
+	return AdapterInfo{Address: r.addr, Name: "simulated"}, nil
+}
+
+func (p *Peer) bondedDevices() ([]BondedDevice, error) {
+	return nil, fmt.Errorf("bonded devices: %w", ErrUnsupported)
+}
+
+func (p *Peer) forget(addr string) error {
+	return fmt.Errorf("forget: %w", ErrUnsupported)
+}
+
+// pair is not needed: simulated links are never encrypted.
+func (p *Peer) pair(addr string) error {
+	return fmt.Errorf("pair: %w", ErrUnsupported)
+}
+
+func (p *Peer) addressType(addr string) string {
+	return ""
+}
+
+// prepareDirectConnect has nothing to do: connecting waits for the peer to
+// advertise.
+func (p *Peer) prepareDirectConnect(addr, addrType string) error {
+	return nil
+}
+
+func (p *Peer) readRSSI(addr string) (int16, error) {
+	return simRSSI, nil
+}
+
+func (p *Peer) setPeerAlias(addr, alias string) error {
+	return fmt.Errorf("set alias: %w", ErrUnsupported)
+}
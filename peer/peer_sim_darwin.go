@@ -0,0 +1,28 @@
+//go:build simble
+
+package peer
+
+import (
+	"crypto/rand"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// parseAddress parses a peer identifier as reported in scan results.
+func parseAddress(s string) (bluetooth.Address, error) {
+	uuid, err := bluetooth.ParseUUID(s)
+	if err != nil {
+		return bluetooth.Address{}, err
+	}
+	return bluetooth.Address{UUID: uuid}, nil
+}
+
+// newSimAddress returns a random peer identifier, the form CoreBluetooth
+// gives addresses in.
+func newSimAddress() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return bluetooth.NewUUID(b).String(), nil
+}
@@ -0,0 +1,29 @@
+//go:build simble && !darwin
+
+package peer
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// parseAddress parses a peer address as reported in scan results.
+func parseAddress(s string) (bluetooth.Address, error) {
+	mac, err := bluetooth.ParseMAC(s)
+	if err != nil {
+		return bluetooth.Address{}, err
+	}
+	return bluetooth.Address{MACAddress: bluetooth.MACAddress{MAC: mac}}, nil
+}
+
+// newSimAddress returns a random static device address.
+func newSimAddress() (string, error) {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[0] |= 0xc0
+	return fmt.Sprintf("%02X:%02X:%02X:%02X:%02X:%02X", b[0], b[1], b[2], b[3], b[4], b[5]), nil
+}
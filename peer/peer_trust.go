@@ -0,0 +1,183 @@
+package peer
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bluetalk/transport"
+)
+
+// knownPeersFile is where pinned peer keys are kept, under the user's config
+// directory.
+const knownPeersFile = "bluetalk/known_peers.json"
+
+// ErrKeyMismatch is the error of a peer that presents a name pinned to a
+// different identity key.
+var ErrKeyMismatch = errors.New("peer's identity key does not match the one pinned for its name")
+
+// TrustResult is what checking a peer's identity against the known peers
+// found.
+type TrustResult int
+
+const (
+	// TrustNew means the name was seen for the first time and its key is
+	// now pinned.
+	TrustNew TrustResult = iota
+	// TrustKnown means the key is the one pinned for the name.
+	TrustKnown
+	// TrustMismatch means the name is pinned to another key: someone may be
+	// impersonating the peer, or it reinstalled.
+	TrustMismatch
+)
+
+// knownPeer is one pinned identity as saved.
+type knownPeer struct {
+	Key       ed25519.PublicKey `json:"key"`
+	Address   string            `json:"address,omitempty"`
+	FirstSeen time.Time         `json:"first_seen"`
+}
+
+// KnownPeers pins each peer name to the identity key it first presented,
+// trust on first use, and persists the pins to a file.
+type KnownPeers struct {
+	path string
+
+	mu    sync.Mutex
+	peers map[string]knownPeer
+}
+
+// DefaultKnownPeersPath returns where pinned peer keys are kept.
+func DefaultKnownPeersPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("find config directory: %w", err)
+	}
+	return filepath.Join(dir, knownPeersFile), nil
+}
+
+// LoadKnownPeers reads the known peers saved at path. A missing file is an
+// empty store that is created on the first pin.
+func LoadKnownPeers(path string) (*KnownPeers, error) {
+	k := &KnownPeers{path: path, peers: make(map[string]knownPeer)}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return k, nil
+	case err != nil:
+		return nil, fmt.Errorf("read known peers: %w", err)
+	}
+	if err := json.Unmarshal(data, &k.peers); err != nil {
+		return nil, fmt.Errorf("parse known peers %s: %w", path, err)
+	}
+	return k, nil
+}
+
+// Check compares a peer's identity with the key pinned for its name,
+// pinning it if the name is new. addr is where it connected from, kept for
+// reference. A mismatch leaves the pin as it was.
+func (k *KnownPeers) Check(addr string, id transport.Identity) (TrustResult, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if known, ok := k.peers[id.Name]; ok {
+		if !known.Key.Equal(id.Key) {
+			return TrustMismatch, nil
+		}
+		return TrustKnown, nil
+	}
+	k.peers[id.Name] = knownPeer{Key: id.Key, Address: addr, FirstSeen: time.Now()}
+	return TrustNew, k.saveLocked()
+}
+
+// Pinned returns the fingerprint of the key pinned for name, if any.
+func (k *KnownPeers) Pinned(name string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	known, ok := k.peers[name]
+	if !ok {
+		return "", false
+	}
+	return transport.Identity{Key: known.Key, Name: name}.Fingerprint(), true
+}
+
+// Forget removes the pin for name, so the next key it presents is trusted.
+func (k *KnownPeers) Forget(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.peers[name]; !ok {
+		return nil
+	}
+	delete(k.peers, name)
+	return k.saveLocked()
+}
+
+func (k *KnownPeers) saveLocked() error {
+	data, err := json.MarshalIndent(k.peers, "", "\t")
+	if err != nil {
+		return fmt.Errorf("save known peers: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+		return fmt.Errorf("save known peers: %w", err)
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("save known peers: %w", err)
+	}
+	if err := os.Rename(tmp, k.path); err != nil {
+		return fmt.Errorf("save known peers: %w", err)
+	}
+	return nil
+}
+
+// TrustPolicy is how the Peer checks identities against the known peers.
+// With RefuseMismatch a peer whose key does not match its pinned name is
+// disconnected; otherwise it is only warned about.
+type TrustPolicy struct {
+	Known          *KnownPeers
+	RefuseMismatch bool
+}
+
+// SetTrustPolicy sets the known peers store identities are checked against.
+// A nil store checks nothing.
+func (p *Peer) SetTrustPolicy(policy TrustPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.trust = policy
+}
+
+func (p *Peer) currentTrustPolicy() TrustPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.trust
+}
+
+// checkTrust pins or verifies the identity a peer just proved and reports
+// whether the connection may go on.
+func (p *Peer) checkTrust(addr string, id transport.Identity) bool {
+	policy := p.currentTrustPolicy()
+	if policy.Known == nil {
+		return true
+	}
+	result, err := policy.Known.Check(addr, id)
+	if err != nil {
+		p.publishStatus(fmt.Sprintf("Could not pin %s: %v", id.Name, err))
+	}
+	switch result {
+	case TrustNew:
+		p.publishStatus(fmt.Sprintf("First contact with %s, pinned key %s", id.Name, id.Fingerprint()))
+	case TrustMismatch:
+		pinned, _ := policy.Known.Pinned(id.Name)
+		p.publishStatus(fmt.Sprintf("WARNING: %s presented key %s, but %s is pinned for that name. "+
+			"Someone may be impersonating them.", id.Name, id.Fingerprint(), pinned))
+		if policy.RefuseMismatch {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,435 @@
+//go:build linux || windows || darwin
+
+package peer
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+func (f ScanFilter) matches(result bluetooth.ScanResult) bool {
+	if len(f.ServiceUUIDs) == 0 && f.NamePrefix == "" {
+		return true
+	}
+	for _, uuid := range f.ServiceUUIDs {
+		if result.HasServiceUUID(bytesToUUID(uuid)) {
+			return true
+		}
+	}
+	return f.NamePrefix != "" && strings.HasPrefix(result.LocalName(), f.NamePrefix)
+}
+
+// scanEntry is the de-duplicated view of one advertising device. Name is the
+// nickname a BlueTalk peer advertises, if any, and otherwise its local name;
+// Version is its advertised protocol version, zero if unknown, and
+// Fingerprint the identity fingerprint it advertises, empty if none.
+type scanEntry struct {
+	Address     bluetooth.Address
+	Name        string
+	Version     byte
+	Fingerprint string
+	RSSI        int16
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// defaultScanExpiry is how long a device stays in the scan cache after its
+// last advertisement: longer than a discovery round with the radio resting,
+// so the list of nearby peers does not empty between rounds.
+const defaultScanExpiry = 90 * time.Second
+
+// scanCache de-duplicates scan results by address and keeps them across
+// discovery rounds until they expire. The first advertisement from a device
+// is reported through onNew; later advertisements that change its name or
+// RSSI are reported through onUpdate, and its expiry through onLost. Any
+// callback may be nil.
+type scanCache struct {
+	mu      sync.Mutex
+	entries map[string]*scanEntry
+	expiry  time.Duration
+
+	// generic is the local name every peer advertises before it has a
+	// nickname, and identity the UUID fingerprints are advertised under.
+	generic  string
+	identity bluetooth.UUID
+
+	onNew    func(scanEntry)
+	onUpdate func(scanEntry)
+	onLost   func(scanEntry)
+}
+
+func newScanCache(onNew, onUpdate, onLost func(scanEntry)) *scanCache {
+	return &scanCache{
+		entries:  make(map[string]*scanEntry),
+		expiry:   defaultScanExpiry,
+		generic:  serviceName,
+		identity: bytesToUUID(rxUUID),
+		onNew:    onNew,
+		onUpdate: onUpdate,
+		onLost:   onLost,
+	}
+}
+
+// observe records a scan result. It must be called from the scan callback,
+// as the advertisement payload is only valid until the callback returns.
+func (c *scanCache) observe(result bluetooth.ScanResult) {
+	now := time.Now()
+	key := result.Address.String()
+
+	c.mu.Lock()
+	name, version := advertisedName(result, c.generic)
+	fp := advertisedFingerprint(result, c.identity)
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &scanEntry{
+			Address:     result.Address,
+			Name:        name,
+			Version:     version,
+			Fingerprint: fp,
+			RSSI:        result.RSSI,
+			FirstSeen:   now,
+			LastSeen:    now,
+		}
+		c.entries[key] = entry
+		snapshot := *entry
+		c.mu.Unlock()
+		if c.onNew != nil {
+			c.onNew(snapshot)
+		}
+		return
+	}
+
+	// A peer alternating extended and legacy adverts sends its name
+	// truncated in every other one, which says nothing new.
+	renamed := name != "" && !strings.HasPrefix(entry.Name, name)
+	changed := entry.RSSI != result.RSSI || renamed
+	entry.LastSeen = now
+	entry.RSSI = result.RSSI
+	if renamed {
+		entry.Name = name
+	}
+	if version != 0 {
+		entry.Version = version
+	}
+	if fp != "" {
+		entry.Fingerprint = fp
+	}
+	snapshot := *entry
+	c.mu.Unlock()
+
+	if changed && c.onUpdate != nil {
+		c.onUpdate(snapshot)
+	}
+}
+
+// configure takes the local name that says nothing about a peer and the
+// UUID fingerprints are advertised under from cfg.
+func (c *scanCache) configure(cfg PeerConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generic = cfg.Name
+	c.identity = bytesToUUID(cfg.RXUUID)
+}
+
+// advertisedName picks the name to show for a scan result: the local name,
+// unless it is missing or the generic one, in which case the nickname from
+// BlueTalk's manufacturer data is used.
+func advertisedName(result bluetooth.ScanResult, generic string) (name string, version byte) {
+	name = result.LocalName()
+	for _, md := range result.ManufacturerData() {
+		if md.CompanyID != bluetalkCompanyID {
+			continue
+		}
+		v, nickname, ok := parseNicknamePayload(md.Data)
+		if !ok {
+			continue
+		}
+		version = v
+		if nickname != "" && (name == "" || name == generic) {
+			name = nickname
+		}
+	}
+	return name, version
+}
+
+// nameOf returns the name device addr advertises, or "" if discovery has
+// not seen it or it advertises only the generic name.
+func (c *scanCache) nameOf(addr string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[addr]; ok && e.Name != c.generic {
+		return e.Name
+	}
+	return ""
+}
+
+// setExpiry changes how long devices are kept after their last
+// advertisement.
+func (c *scanCache) setExpiry(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiry = d
+}
+
+// expire drops the devices not heard from within the expiry and reports
+// them through onLost.
+func (c *scanCache) expire() {
+	c.mu.Lock()
+	cutoff := time.Now().Add(-c.expiry)
+	var lost []scanEntry
+	for key, entry := range c.entries {
+		if entry.LastSeen.Before(cutoff) {
+			lost = append(lost, *entry)
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+
+	if c.onLost != nil {
+		for _, entry := range lost {
+			c.onLost(entry)
+		}
+	}
+}
+
+// seenSince returns the devices advertised at or after t, in the order they
+// were first discovered.
+func (c *scanCache) seenSince(t time.Time) []scanEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []scanEntry
+	for _, entry := range c.entries {
+		if !entry.LastSeen.Before(t) {
+			out = append(out, *entry)
+		}
+	}
+	slices.SortFunc(out, func(a, b scanEntry) int {
+		return a.FirstSeen.Compare(b.FirstSeen)
+	})
+	return out
+}
+
+// NearbyPeer is a device discovery has seen advertising recently. Age is
+// how long ago it last advertised. Fingerprint is the identity fingerprint
+// it advertises, which only extended adverts carry; it is a claim until the
+// peer proves it on connecting.
+type NearbyPeer struct {
+	Address     string
+	Name        string
+	Version     byte
+	Fingerprint string
+	RSSI        int16
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	Age         time.Duration
+}
+
+// passiveScanWindow listens for d through an advertisement monitor, which
+// only passes on adverts carrying the BlueTalk service. The results still go
+// through the scan filter, access list and room.
+func (p *Peer) passiveScanWindow(d time.Duration, observe func(bluetooth.ScanResult)) (bool, error) {
+	match := p.scanMatcher()
+	stop, err := p.startMonitor(func(result bluetooth.ScanResult) {
+		if match(result) {
+			observe(result)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	defer stop()
+	return p.pause(d), nil
+}
+
+// Nearby lists the devices seen advertising within the nearby expiry, in the
+// order they were first discovered. The list carries over between discovery
+// rounds, so it does not empty while the Peer advertises or rests.
+func (p *Peer) Nearby() []NearbyPeer {
+	p.scanCache.expire()
+	now := time.Now()
+	entries := p.scanCache.seenSince(time.Time{})
+	out := make([]NearbyPeer, len(entries))
+	for i, e := range entries {
+		out[i] = NearbyPeer{
+			Address:     e.Address.String(),
+			Name:        e.Name,
+			Version:     e.Version,
+			Fingerprint: e.Fingerprint,
+			RSSI:        e.RSSI,
+			FirstSeen:   e.FirstSeen,
+			LastSeen:    e.LastSeen,
+			Age:         now.Sub(e.LastSeen),
+		}
+	}
+	return out
+}
+
+// SetNearbyExpiry sets how long a device stays in Nearby after its last
+// advertisement; PeerLost is reported when it is dropped. Zero or less
+// restores the default.
+func (p *Peer) SetNearbyExpiry(d time.Duration) {
+	if d <= 0 {
+		d = defaultScanExpiry
+	}
+	p.scanCache.setExpiry(d)
+}
+
+// ConnectionPolicy decides which discovered devices are worth connecting
+// to. Devices weaker than MinRSSI (in dBm) are passed over; zero accepts
+// any signal. Devices not heard from within MaxAge are passed over too,
+// even if an earlier discovery round found them; zero only considers those
+// seen in the current round. With PreferStrongest the strongest device is
+// tried first, otherwise the first one discovered.
+type ConnectionPolicy struct {
+	MinRSSI         int16
+	MaxAge          time.Duration
+	PreferStrongest bool
+}
+
+var defaultConnectionPolicy = ConnectionPolicy{MaxAge: 10 * time.Second, PreferStrongest: true}
+
+// SetConnectionPolicy replaces the policy used to pick a device to connect
+// to.
+func (p *Peer) SetConnectionPolicy(policy ConnectionPolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connPolicy = policy
+}
+
+func (p *Peer) currentConnectionPolicy() ConnectionPolicy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connPolicy
+}
+
+// candidates orders devices for connection attempts, dropping those too
+// weak to bother with.
+func (policy ConnectionPolicy) candidates(devices []scanEntry) []scanEntry {
+	if policy.MinRSSI != 0 {
+		devices = slices.DeleteFunc(devices, func(e scanEntry) bool {
+			return e.RSSI < policy.MinRSSI
+		})
+	}
+	if policy.PreferStrongest {
+		slices.SortStableFunc(devices, func(a, b scanEntry) int {
+			return int(b.RSSI) - int(a.RSSI)
+		})
+	}
+	return devices
+}
+
+// ScanWindowConfig is the discovery duty cycle: up to Windows scans of length
+// Window, with the radio idle for Pause between them. Discovery stops after
+// the first window that finds a device.
+type ScanWindowConfig struct {
+	Window  time.Duration
+	Pause   time.Duration
+	Windows int
+}
+
+var defaultScanWindows = ScanWindowConfig{
+	Window:  5 * time.Second,
+	Windows: 1,
+}
+
+// SetScanWindows replaces the discovery duty cycle.
+func (p *Peer) SetScanWindows(cfg ScanWindowConfig) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scanWindowCfg = cfg
+}
+
+func (p *Peer) currentScanWindows() ScanWindowConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.scanWindowCfg
+}
+
+// Scan brings up the adapter, listens for adverts for d and returns the
+// peers heard, without connecting to any. It is for a Peer that is not run;
+// on a stopped Peer it returns nothing.
+func (p *Peer) Scan(d time.Duration) ([]NearbyPeer, error) {
+	p.mu.Lock()
+	if p.ctx.Err() != nil {
+		p.mu.Unlock()
+		return nil, nil
+	}
+	p.wg.Add(1)
+	p.mu.Unlock()
+	defer p.wg.Done()
+
+	if err := p.setupPlatform(); err != nil {
+		return nil, fmt.Errorf("BLE setup failed: %w", err)
+	}
+	p.scanWindow(d)
+	return p.Nearby(), nil
+}
+
+// scanWindows runs the configured discovery windows and returns the devices
+// seen during them that the connection policy accepts, best first. It returns
+// nil once the peer is stopped.
+func (p *Peer) scanWindows(cfg ScanWindowConfig) []scanEntry {
+	policy := p.currentConnectionPolicy()
+	start := time.Now()
+	p.scanCache.expire()
+	for i := range max(cfg.Windows, 1) {
+		if i > 0 && !p.pause(cfg.Pause) {
+			return nil
+		}
+		if !p.scanWindow(cfg.Window) {
+			return nil
+		}
+		seen := slices.DeleteFunc(p.scanCache.seenSince(policy.since(start)), p.passedOver)
+		if devices := policy.candidates(seen); len(devices) > 0 {
+			return devices
+		}
+	}
+	return nil
+}
+
+// since returns how far back a device may have been seen to be a candidate,
+// for a discovery round that began at start.
+func (policy ConnectionPolicy) since(start time.Time) time.Time {
+	if policy.MaxAge <= 0 {
+		return start
+	}
+	return time.Now().Add(-policy.MaxAge)
+}
+
+// scanWindow scans for d and waits for the scan to wind down, so the next
+// window does not collide with a scan that is still stopping. It cuts the
+// scan short and reports false if the peer is stopped.
+func (p *Peer) scanWindow(d time.Duration) bool {
+	observe := func(result bluetooth.ScanResult) {
+		p.noteActivity()
+		p.scanCache.observe(result)
+	}
+	if p.currentConfig().ScanMode == ScanPassive && !p.passiveFailed.Load() {
+		ok, err := p.passiveScanWindow(d, observe)
+		if err == nil {
+			return ok
+		}
+		p.passiveFailed.Store(true)
+		p.publishStatus(fmt.Sprintf("Passive scanning unavailable, scanning actively: %v", err))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.startScanning(observe)
+	}()
+	ok := p.pause(d)
+	_ = p.stopScan()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+	return ok
+}
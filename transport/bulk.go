@@ -0,0 +1,101 @@
+package transport
+
+import "fmt"
+
+// A session may have a second, faster link to its peer, such as an L2CAP
+// channel next to a GATT connection. Bulk messages, file chunks and streams,
+// go over it while it is attached; everything else, ACKs included, stays on
+// the main link. Fragments are sized to the main link either way, so a bulk
+// message carries on over the main link if the side link fails.
+
+// EnableBulkLinks sets whether the owner can open a side link for bulk data,
+// which peers are told in every HELLO sent from then on. See OnBulkReady.
+func (t *Transport) EnableBulkLinks(on bool) {
+	t.bulkLinks.Store(on)
+}
+
+// OnBulkReady registers fn to be called when peer id announces that it takes
+// bulk data over a side link too, while EnableBulkLinks is on. fn should open
+// one and hand it to AttachBulk; it runs on a goroutine of its own.
+func (t *Transport) OnBulkReady(fn func(id string)) {
+	if fn == nil {
+		t.onBulkReady.Store(nil)
+		return
+	}
+	t.onBulkReady.Store(&fn)
+}
+
+// AttachBulk sends peer id's bulk messages over link from now on, replacing
+// any side link attached before. Packets link delivers are handled like those
+// of the main link. A side link whose Write fails is detached; closing it is
+// up to the caller, as it is after DetachBulk.
+func (t *Transport) AttachBulk(id string, link Link) error {
+	s := t.route(id)
+	if s == nil || s.link.Load() == nil {
+		return ErrNotConnected
+	}
+	link.OnPacket(s.receive)
+	if old := s.bulk.Swap(&link); old != nil {
+		(*old).OnPacket(nil)
+	}
+	return nil
+}
+
+// DetachBulk moves peer id's bulk messages back to its main link.
+func (t *Transport) DetachBulk(id string) {
+	if s := t.route(id); s != nil {
+		s.detachBulk(s.bulk.Load())
+	}
+}
+
+// detachBulk detaches link if it is still the session's side link.
+func (s *peerSession) detachBulk(link *Link) bool {
+	if link == nil || !s.bulk.CompareAndSwap(link, nil) {
+		return false
+	}
+	(*link).OnPacket(nil)
+	return true
+}
+
+// features returns the feature flags we announce: what this build can
+// receive, and a side link if the owner can open one.
+func (t *Transport) features() byte {
+	f := localFeatures
+	if t.bulkLinks.Load() {
+		f |= featBulkLink
+	}
+	return f
+}
+
+// bulkReady tells the owner peer id can take a side link, if it can open
+// one.
+func (t *Transport) bulkReady(id string) {
+	if !t.bulkLinks.Load() {
+		return
+	}
+	if fn := t.onBulkReady.Load(); fn != nil {
+		go (*fn)(id)
+	}
+}
+
+// writeFragment builds a data fragment and writes it, over the side link if
+// the fragment is bulk data and one is attached. A side link that fails is
+// detached and the fragment goes over the main link instead.
+func (s *peerSession) writeFragment(bulk bool, build func(dst []byte) []byte) error {
+	link := s.bulk.Load()
+	if !bulk || link == nil {
+		return s.writeWith(build)
+	}
+
+	buf := getPacketBuf()
+	defer putPacketBuf(buf)
+	*buf = build((*buf)[:0])
+	err := (*link).Write(*buf)
+	if err == nil {
+		return nil
+	}
+	if s.detachBulk(link) {
+		s.t.publishStatus(fmt.Sprintf("Side channel failed, bulk data is back on the main link: %v", err))
+	}
+	return s.write(*buf)
+}
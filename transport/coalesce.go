@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Packets for a peer go through a queue that a writer goroutine of the
+// session drains, so senders never wait on the radio and a slow link holds up
+// only its own peer. The writer leaves the pacing gap between link writes,
+// and small packets that queue up meanwhile go out together as one batch to
+// peers that announce featCoalesce: ACKs, pings and short chat messages then
+// share a write instead of taking one each.
+
+const (
+	// sendQueueSize bounds the packets waiting for a session's link. A
+	// fragment that finds the queue full is retried like one whose write
+	// failed.
+	sendQueueSize = 64
+
+	// batchEntryMax is the largest packet a batch carries, since entries
+	// are prefixed with a one-byte length.
+	batchEntryMax = 255
+)
+
+// errQueueFull is returned by write while the session's link is not keeping
+// up with its senders.
+var errQueueFull = errors.New("send queue full")
+
+// sendQueue holds packets waiting for the session's writer. stop is nil while
+// no writer runs.
+type sendQueue struct {
+	mu      sync.Mutex
+	packets []*[]byte
+	wake    chan struct{}
+	stop    chan struct{}
+}
+
+// startWriter starts the writer for a new connection, dropping anything
+// queued for the previous one.
+func (s *peerSession) startWriter() {
+	q := &s.out
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopLocked()
+	q.stop = make(chan struct{})
+	q.wake = make(chan struct{}, 1)
+	go s.runWriter(q.stop, q.wake)
+}
+
+func (s *peerSession) stopWriter() {
+	q := &s.out
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopLocked()
+}
+
+func (q *sendQueue) stopLocked() {
+	if q.stop != nil {
+		close(q.stop)
+		q.stop = nil
+	}
+	for _, buf := range q.packets {
+		putPacketBuf(buf)
+	}
+	q.packets = q.packets[:0]
+}
+
+// push queues a copy of packet and wakes the writer.
+func (q *sendQueue) push(packet []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stop == nil {
+		return ErrNotConnected
+	}
+	if len(q.packets) >= sendQueueSize {
+		return errQueueFull
+	}
+	buf := getPacketBuf()
+	*buf = append((*buf)[:0], packet...)
+	q.packets = append(q.packets, buf)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next removes the next write from the queue: the first packet on its own,
+// or batched with those after it while they fit in limit bytes. limit is zero
+// for a peer that takes no batches. The caller returns the buffer to the
+// pool; next returns nil when the queue is empty.
+func (q *sendQueue) next(limit int) *[]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.packets) == 0 {
+		return nil
+	}
+
+	n, size := 0, headerSize
+	for _, p := range q.packets {
+		if len(*p) > batchEntryMax || size+1+len(*p) > limit || n == 255 {
+			break
+		}
+		size += 1 + len(*p)
+		n++
+	}
+	if n < 2 {
+		first := q.packets[0]
+		q.packets = q.packets[1:]
+		return first
+	}
+
+	batch := getPacketBuf()
+	*batch = appendHeader((*batch)[:0], packetBatch, 0, 0, 0, uint8(n))
+	for _, p := range q.packets[:n] {
+		*batch = append(*batch, byte(len(*p)))
+		*batch = append(*batch, *p...)
+		putPacketBuf(p)
+	}
+	q.packets = q.packets[n:]
+	return batch
+}
+
+// runWriter writes queued packets to the link until stop is closed, leaving
+// the pacing gap between writes.
+func (s *peerSession) runWriter(stop, wake <-chan struct{}) {
+	var last time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		}
+
+		for {
+			if gap := s.cc.pacing(s.t.config()) - time.Since(last); gap > 0 {
+				select {
+				case <-stop:
+					return
+				case <-time.After(gap):
+				}
+			}
+			buf := s.out.next(s.batchLimit())
+			if buf == nil {
+				break
+			}
+			if link := s.link.Load(); link != nil {
+				_ = (*link).Write(*buf)
+			}
+			putPacketBuf(buf)
+			last = time.Now()
+		}
+	}
+}
+
+// batchLimit is the largest batch the peer takes, zero if it takes none.
+func (s *peerSession) batchLimit() int {
+	if !s.peerSupports(featCoalesce) {
+		return 0
+	}
+	return s.payloadSize() + headerSize
+}
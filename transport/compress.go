@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// compressThreshold is the smallest message worth compressing; shorter
+	// ones rarely shrink by enough to save a fragment.
+	compressThreshold = 64
+
+	// maxInflatedSize bounds a decompressed message, so a small malicious
+	// message cannot expand without limit.
+	maxInflatedSize = 1 << 20
+)
+
+var errInflatedTooLarge = errors.New("decompressed message too large")
+
+// EnableCompression turns on deflate compression of outgoing messages larger
+// than compressThreshold. Messages are only compressed for peers that
+// advertised support in their HELLO.
+func (t *Transport) EnableCompression(on bool) {
+	t.compress.Store(on)
+}
+
+// compressOutgoing deflates a message when it is enabled, the peer supports
+// it and the result is smaller. It returns the header flags to send it with.
+func (s *peerSession) compressOutgoing(body []byte) (byte, []byte) {
+	if !s.t.compress.Load() || len(body) < compressThreshold || !s.peerSupports(featDeflate) {
+		return 0, body
+	}
+
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return 0, body
+	}
+	if _, err := w.Write(body); err != nil {
+		return 0, body
+	}
+	if err := w.Close(); err != nil || buf.Len() >= len(body) {
+		return 0, body
+	}
+	return frameCompressed, buf.Bytes()
+}
+
+func inflate(body []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(body))
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, maxInflatedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	if len(out) > maxInflatedSize {
+		return nil, errInflatedTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// minRTO and maxRTO bound the retransmission timeout derived from
+	// measured round trips.
+	minRTO = 150 * time.Millisecond
+	maxRTO = 8 * time.Second
+
+	// initialWindow is the congestion window a new session starts from; it
+	// grows towards TransportConfig.WindowSize as fragments are acknowledged.
+	initialWindow = 2
+)
+
+// congestion adapts a session's sending to the link. Round-trip times of
+// acknowledged fragments give a retransmission timeout (RFC 6298), and an
+// AIMD congestion window limits fragments in flight: it grows by one
+// fragment per round trip while ACKs arrive and halves on loss. Pacing
+// spreads each window over a round trip instead of bursting it.
+type congestion struct {
+	mu      sync.Mutex
+	srtt    time.Duration
+	rttvar  time.Duration
+	cwnd    float64
+	lastCut time.Time
+}
+
+func newCongestion() *congestion {
+	return &congestion{cwnd: initialWindow}
+}
+
+// reset forgets what was learned about the previous link.
+func (c *congestion) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.srtt, c.rttvar, c.cwnd, c.lastCut = 0, 0, initialWindow, time.Time{}
+}
+
+// window returns how many fragments may be unacknowledged at once.
+func (c *congestion) window(cfg TransportConfig) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return max(1, min(int(c.cwnd), cfg.WindowSize))
+}
+
+// rto returns the retransmission timeout. Until a round trip has been
+// measured it is cfg.AckTimeout.
+func (c *congestion) rto(cfg TransportConfig) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.srtt == 0 {
+		return cfg.AckTimeout
+	}
+	return min(max(c.srtt+4*c.rttvar, minRTO), maxRTO)
+}
+
+// pacing returns the gap to leave between link writes: the smoothed
+// round trip spread over the window, but never less than cfg.Pacing, which
+// keeps the controller's transmit queue from overrunning.
+func (c *congestion) pacing(cfg TransportConfig) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.srtt == 0 {
+		return cfg.Pacing
+	}
+	return max(cfg.Pacing, c.srtt/time.Duration(2*max(int(c.cwnd), 1)))
+}
+
+// onAck records n newly acknowledged fragments and, when non-zero, a
+// round-trip sample from a fragment that was only sent once.
+func (c *congestion) onAck(n int, rtt time.Duration, cfg TransportConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if rtt > 0 {
+		if c.srtt == 0 {
+			c.srtt, c.rttvar = rtt, rtt/2
+		} else {
+			diff := c.srtt - rtt
+			if diff < 0 {
+				diff = -diff
+			}
+			c.rttvar = (3*c.rttvar + diff) / 4
+			c.srtt = (7*c.srtt + rtt) / 8
+		}
+	}
+	for range n {
+		c.cwnd = min(c.cwnd+1/c.cwnd, float64(cfg.WindowSize))
+	}
+}
+
+// onLoss halves the window, at most once per round trip so the losses of
+// one burst count once.
+func (c *congestion) onLoss() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.lastCut) < max(c.srtt, minRTO) {
+		return
+	}
+	c.lastCut = now
+	c.cwnd = max(c.cwnd/2, 1)
+}
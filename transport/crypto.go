@@ -0,0 +1,302 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	// nonceCounterSize is the explicit per-direction message counter that
+	// prefixes every sealed message and forms the low bytes of its nonce.
+	nonceCounterSize = 8
+
+	hkdfInfo = "bluetalk e2e v1"
+)
+
+var (
+	errHandshakeTimeout  = fmt.Errorf("encryption handshake: %w", ErrTimeout)
+	errPlaintextRejected = errors.New("unencrypted message on an encrypted session")
+	errDecrypt           = errors.New("message failed authentication")
+)
+
+// cryptoSession is the encryption state of one connection. Each side sends an
+// ephemeral X25519 public key in a handshake frame. Once a side has the peer's
+// key and its own key has been acknowledged, the session is ready and every
+// message is sealed with ChaCha20-Poly1305 under its own key, drawn from a
+// per-direction HKDF-SHA256 chain.
+type cryptoSession struct {
+	priv  *ecdh.PrivateKey
+	ready chan struct{}
+
+	mu        sync.Mutex
+	sent      bool
+	delivered bool
+	send      *chainRatchet
+	recv      *chainRatchet
+	peerPub   []byte
+	replay    replayWindow
+}
+
+func newCryptoSession() (*cryptoSession, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate session key: %w", err)
+	}
+	return &cryptoSession{priv: priv, ready: make(chan struct{})}, nil
+}
+
+func (s *cryptoSession) publicKey() []byte {
+	return s.priv.PublicKey().Bytes()
+}
+
+// markSent records that our handshake is going out; it reports false if it
+// already was.
+func (s *cryptoSession) markSent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sent {
+		return false
+	}
+	s.sent = true
+	return true
+}
+
+func (s *cryptoSession) markDelivered() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delivered = true
+	s.checkReadyLocked()
+}
+
+// negotiating reports whether either side has started a handshake.
+func (s *cryptoSession) negotiating() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent || s.recv != nil
+}
+
+func (s *cryptoSession) hasPeerKey() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recv != nil
+}
+
+// setPeerKey completes key agreement with the peer's public key. The side
+// with the lexicographically lower public key sends on the first derived
+// chain, so both ends agree on directions without further messages.
+func (s *cryptoSession) setPeerKey(peerPub []byte) error {
+	peerKey, err := ecdh.X25519().NewPublicKey(peerPub)
+	if err != nil {
+		return fmt.Errorf("invalid peer key: %w", err)
+	}
+	shared, err := s.priv.ECDH(peerKey)
+	if err != nil {
+		return fmt.Errorf("key agreement: %w", err)
+	}
+
+	own := s.publicKey()
+	lo, hi := own, peerPub
+	if bytes.Compare(own, peerPub) > 0 {
+		lo, hi = peerPub, own
+	}
+	keys, err := hkdf.Key(sha256.New, shared, append(bytes.Clone(lo), hi...), hkdfInfo, 2*chacha20poly1305.KeySize)
+	if err != nil {
+		return fmt.Errorf("derive keys: %w", err)
+	}
+	clear(shared)
+	sendRoot, recvRoot := keys[:chacha20poly1305.KeySize], keys[chacha20poly1305.KeySize:]
+	if bytes.Equal(own, hi) {
+		sendRoot, recvRoot = recvRoot, sendRoot
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recv != nil {
+		return nil
+	}
+	s.send, s.recv = newChainRatchet(sendRoot), newChainRatchet(recvRoot)
+	s.peerPub = bytes.Clone(peerPub)
+	s.checkReadyLocked()
+	return nil
+}
+
+// peerKey returns the peer's handshake key, or nil before it has arrived.
+func (s *cryptoSession) peerKey() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peerPub
+}
+
+func (s *cryptoSession) checkReadyLocked() {
+	if !s.delivered || s.recv == nil {
+		return
+	}
+	select {
+	case <-s.ready:
+	default:
+		close(s.ready)
+	}
+}
+
+func nonceFor(ctr uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.LittleEndian.PutUint64(nonce[chacha20poly1305.NonceSize-nonceCounterSize:], ctr)
+	return nonce
+}
+
+func (s *cryptoSession) seal(plaintext []byte) ([]byte, error) {
+	s.mu.Lock()
+	ctr, key := s.send.next()
+	s.mu.Unlock()
+	defer clear(key)
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	out := binary.LittleEndian.AppendUint64(nil, ctr)
+	return aead.Seal(out, nonceFor(ctr), plaintext, nil), nil
+}
+
+func (s *cryptoSession) open(msg []byte) ([]byte, error) {
+	if len(msg) < nonceCounterSize+chacha20poly1305.Overhead {
+		return nil, errDecrypt
+	}
+	ctr := binary.LittleEndian.Uint64(msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.replay.check(ctr); err != nil {
+		return nil, err
+	}
+	key, commit, err := s.recv.keyFor(ctr)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonceFor(ctr), msg[nonceCounterSize:], nil)
+	if err != nil {
+		return nil, errDecrypt
+	}
+	commit()
+	s.replay.accept(ctr)
+	s.recv.dropBefore(s.replay.floor())
+	return plaintext, nil
+}
+
+// EnableEncryption turns on end-to-end encryption for future connections.
+// A peer that receives a handshake answers it even with encryption off, so
+// one side opting in is enough to encrypt the session.
+func (t *Transport) EnableEncryption(on bool) {
+	t.encrypt.Store(on)
+}
+
+// startCrypto starts the handshake for a new connection if encryption is
+// enabled.
+func (s *peerSession) startCrypto() {
+	sess := s.ensureCrypto()
+	if sess != nil && s.t.encrypt.Load() {
+		go s.sendHandshake(sess)
+	}
+}
+
+// ensureCrypto returns the session's key agreement state, creating it on
+// first use. The peer's handshake can arrive before our own side has seen the
+// connection come up, so either path may create it.
+func (s *peerSession) ensureCrypto() *cryptoSession {
+	if sess := s.crypto.Load(); sess != nil {
+		return sess
+	}
+	sess, err := newCryptoSession()
+	if err != nil {
+		s.t.publishStatus(fmt.Sprintf("Encryption unavailable: %v", err))
+		return nil
+	}
+	if !s.crypto.CompareAndSwap(nil, sess) {
+		return s.crypto.Load()
+	}
+	return sess
+}
+
+func (s *peerSession) sendHandshake(sess *cryptoSession) {
+	if !sess.markSent() {
+		return
+	}
+	if err := s.sendFrame(packetHandshake, frameChecksum, sess.publicKey(), false, nil); err != nil {
+		s.t.publishStatus(fmt.Sprintf("Encryption handshake failed: %v", err))
+		return
+	}
+	sess.markDelivered()
+}
+
+func (s *peerSession) onHandshake(body []byte) {
+	sess := s.ensureCrypto()
+	if sess == nil {
+		return
+	}
+	if err := sess.setPeerKey(body); err != nil {
+		s.t.publishStatus(fmt.Sprintf("Encryption handshake rejected: %v", err))
+		return
+	}
+	go s.sendHandshake(sess)
+	if s.identityUnbound.Swap(false) {
+		go s.sendIdentity()
+	}
+}
+
+// sealOutgoing encrypts a message body when the session is, or is becoming,
+// encrypted, waiting for the handshake to finish first. sealed reports
+// whether it did.
+func (s *peerSession) sealOutgoing(body []byte, cancel <-chan struct{}) (out []byte, sealed bool, err error) {
+	sess := s.crypto.Load()
+	if sess == nil {
+		if s.t.encrypt.Load() {
+			return nil, false, errHandshakeTimeout
+		}
+		return body, false, nil
+	}
+	if !s.t.encrypt.Load() && !sess.negotiating() {
+		return body, false, nil
+	}
+
+	select {
+	case <-sess.ready:
+	case <-time.After(s.t.config().HandshakeTimeout):
+		return nil, false, errHandshakeTimeout
+	case <-cancel:
+		return nil, false, ErrCanceled
+	}
+	out, err = sess.seal(body)
+	return out, err == nil, err
+}
+
+// openIncoming decrypts a received message body sent with frameEncrypted.
+// Plaintext is only accepted while no key has been agreed and encryption is
+// not required locally.
+func (s *peerSession) openIncoming(body []byte, encrypted bool) ([]byte, error) {
+	sess := s.crypto.Load()
+	keyed := sess != nil && sess.hasPeerKey()
+	if !encrypted {
+		if keyed || s.t.encrypt.Load() {
+			return nil, errPlaintextRejected
+		}
+		return body, nil
+	}
+	if !keyed {
+		return nil, errDecrypt
+	}
+	return sess.open(body)
+}
@@ -0,0 +1,223 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outboxSize is how many messages of one kind may wait for their send lane
+// before Send blocks the caller.
+const outboxSize = 32
+
+// ErrCanceled is the error of a delivery stopped by Cancel.
+var ErrCanceled = errors.New("send canceled")
+
+// Delivery tracks one outgoing message. Done is closed once every fragment
+// has been acknowledged by the peer or the send has failed; Err then reports
+// which. For chat messages, Read is closed when the peer reports the message
+// as displayed. Cancel abandons a send that is queued or in flight.
+type Delivery struct {
+	ID uint32
+
+	to   string
+	kind MessageKind
+	data []byte
+
+	done chan struct{}
+	err  error
+	read chan struct{}
+
+	cancel     chan struct{}
+	cancelOnce sync.Once
+	cancelErr  error
+
+	// expires is when an unsent message is given up on: the sender's TTL
+	// if it gave one, otherwise set from QueueConfig.TTL when queued.
+	expires time.Time
+}
+
+var nextDeliveryID atomic.Uint32
+
+func newDelivery(kind MessageKind, data []byte) *Delivery {
+	return &Delivery{
+		ID:     nextDeliveryID.Add(1),
+		kind:   kind,
+		data:   data,
+		done:   make(chan struct{}),
+		read:   make(chan struct{}),
+		cancel: make(chan struct{}),
+	}
+}
+
+// finishedDelivery returns a Delivery that has already completed with err.
+func finishedDelivery(err error) *Delivery {
+	d := newDelivery(0, nil)
+	d.finish(err)
+	return d
+}
+
+// Done is closed when the message has been delivered or has failed.
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Read is closed when the peer sends a read receipt for the message. Peers
+// only send them with read receipts enabled, so it may never close.
+func (d *Delivery) Read() <-chan struct{} {
+	return d.read
+}
+
+// Err returns nil once the message is delivered, the send error if it
+// failed, and nil while it is still in flight.
+func (d *Delivery) Err() error {
+	select {
+	case <-d.done:
+		return d.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the message is delivered or fails.
+func (d *Delivery) Wait() error {
+	<-d.done
+	return d.err
+}
+
+// Cancel stops the send. A message still queued is never sent; one in
+// flight stops between fragments, and the peer discards the part it has.
+// The delivery then fails with ErrCanceled. Cancel has no effect once the
+// message is delivered.
+func (d *Delivery) Cancel() {
+	d.cancelWith(ErrCanceled)
+}
+
+func (d *Delivery) cancelWith(err error) {
+	d.cancelOnce.Do(func() {
+		d.cancelErr = err
+		close(d.cancel)
+	})
+}
+
+// canceled returns the cancellation error, or nil if not canceled.
+func (d *Delivery) canceled() error {
+	select {
+	case <-d.cancel:
+		return d.cancelErr
+	default:
+		return nil
+	}
+}
+
+// expired reports whether d has outlived its TTL.
+func (d *Delivery) expired() bool {
+	return !d.expires.IsZero() && !time.Now().Before(d.expires)
+}
+
+func (d *Delivery) finish(err error) {
+	d.err = err
+	close(d.done)
+}
+
+// sendLoop sends the messages queued on a lane one at a time, so they reach
+// the peer in the order they were submitted. Messages for a peer that is not
+// connected, or that disconnects while they are sent, are held in the
+// outbound queue when it is enabled; a message cut off part way is sent
+// again in full after the reconnect.
+func (t *Transport) sendLoop(outbox <-chan *Delivery) {
+	for d := range outbox {
+		if err := d.canceled(); err != nil {
+			d.finish(err)
+			continue
+		}
+		if d.expired() {
+			d.finish(ErrExpired)
+			continue
+		}
+		s := t.route(d.to)
+		if s == nil {
+			if !t.queue.park(d) {
+				d.finish(ErrNotConnected)
+			}
+			continue
+		}
+		if d.kind == KindChat {
+			s.awaitRead(d)
+		}
+		err := s.sendNow(d)
+		if errors.Is(err, ErrCanceled) {
+			err = d.canceled()
+		}
+		if err != nil {
+			s.forgetRead(d)
+			if d.canceled() == nil && t.route(d.to) != s && t.queue.park(d) {
+				continue
+			}
+		}
+		d.finish(err)
+	}
+}
+
+// SendContext is SendTo that cancels the send when ctx is done, failing it
+// with ctx's cause.
+func (t *Transport) SendContext(ctx context.Context, id string, kind MessageKind, data []byte) *Delivery {
+	d := t.SendTo(id, kind, data)
+	stop := context.AfterFunc(ctx, func() { d.cancelWith(context.Cause(ctx)) })
+	go func() {
+		<-d.done
+		stop()
+	}()
+	return d
+}
+
+// SendTTL is SendTo for a message only worth sending within ttl, such as
+// "are you there?". If it has not gone out by then, whether it was waiting
+// for the peer to reconnect or behind other messages, it is dropped and the
+// delivery fails with ErrExpired. A ttl of zero or less means no limit
+// beyond the queue's.
+func (t *Transport) SendTTL(id string, kind MessageKind, data []byte, ttl time.Duration) *Delivery {
+	d := newDelivery(kind, data)
+	d.to = id
+	if ttl > 0 {
+		d.expires = time.Now().Add(ttl)
+	}
+	t.submit(d)
+	return d
+}
+
+// fairQueue grants turns in the order they are asked for. A sender that
+// takes a turn for every fragment goes to the back of the line after each
+// one, so concurrent messages share the link round-robin.
+type fairQueue struct {
+	mu      sync.Mutex
+	busy    bool
+	waiters []chan struct{}
+}
+
+func (q *fairQueue) acquire() {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	q.waiters = append(q.waiters, ch)
+	q.mu.Unlock()
+	<-ch
+}
+
+// release passes the turn to the longest waiter, if any.
+func (q *fairQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		q.busy = false
+		return
+	}
+	close(q.waiters[0])
+	q.waiters = q.waiters[1:]
+}
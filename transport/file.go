@@ -0,0 +1,461 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File transfer control messages, sent as KindControl with the op first.
+const (
+	ctlFileOffer  byte = 0x01 // id, size, sha256, name
+	ctlFileAccept byte = 0x02 // id, offset to resume from
+	ctlFileReject byte = 0x03 // id, reason
+	ctlFileDone   byte = 0x04 // id
+	ctlFileResult byte = 0x05 // id, ok, reason
+)
+
+const (
+	fileIDSize = 16
+
+	// fileChunkSize is the file data carried by one file-chunk message. It
+	// stays under the 255-fragment message limit at the smallest MTU with
+	// encryption on.
+	fileChunkSize = 2048
+
+	// fileOfferTimeout bounds how long SendFile waits for the receiver to
+	// accept, which may involve a person answering a prompt.
+	fileOfferTimeout = 2 * time.Minute
+
+	// fileResultTimeout bounds how long SendFile waits for the receiver to
+	// verify the hash of the finished file.
+	fileResultTimeout = 30 * time.Second
+)
+
+var (
+	ErrFileRejected  = errors.New("file rejected by peer")
+	errFileTimeout   = fmt.Errorf("file transfer: %w", ErrTimeout)
+	errHashMismatch  = errors.New("file hash mismatch")
+	errNoFileHandler = errors.New("not accepting files")
+	errNoDownloadDir = errors.New("no download directory")
+)
+
+// FileOffer describes a file a peer wants to send.
+type FileOffer struct {
+	ID   string
+	Name string
+	Size int64
+	Hash [sha256.Size]byte
+}
+
+// FileHandler receives incoming file transfers. Accept decides whether to
+// take an offer and may block, for example on a prompt; without it every
+// offer is rejected. Progress and Done are optional.
+type FileHandler struct {
+	Accept   func(offer FileOffer) bool
+	Progress func(offer FileOffer, received int64)
+	Done     func(offer FileOffer, path string, err error)
+}
+
+// fileTransfers runs the file transfer protocol for a Transport. Transfers
+// are identified by a hash of the file's contents and name, so offering the
+// same file again after a reconnect resumes into the partial file the
+// receiver kept.
+type fileTransfers struct {
+	t *Transport
+
+	mu       sync.Mutex
+	dir      string
+	handler  FileHandler
+	outgoing map[string]chan []byte
+	incoming map[string]incomingFile
+}
+
+// incomingFile is an accepted offer and the peer it came from, the only one
+// whose chunks are written into it.
+type incomingFile struct {
+	offer FileOffer
+	from  string
+}
+
+func newFileTransfers(t *Transport) *fileTransfers {
+	return &fileTransfers{
+		t:        t,
+		outgoing: make(map[string]chan []byte),
+		incoming: make(map[string]incomingFile),
+	}
+}
+
+// SetFileHandler replaces the handler for incoming files.
+func (t *Transport) SetFileHandler(h FileHandler) {
+	t.files.mu.Lock()
+	defer t.files.mu.Unlock()
+	t.files.handler = h
+}
+
+// SetDownloadDir sets where received files are saved, creating it when a file
+// arrives if need be. Partial files are kept there too, so an interrupted
+// transfer can resume. Until it is set, every offer is rejected.
+func (t *Transport) SetDownloadDir(dir string) {
+	t.files.mu.Lock()
+	defer t.files.mu.Unlock()
+	t.files.dir = dir
+}
+
+// SendFile offers the file at path to the active peer and, once accepted,
+// sends it, calling progress after each chunk. If the receiver already holds
+// part of the file from an interrupted transfer, sending resumes from there.
+// It returns once the receiver has verified the file's hash.
+func (t *Transport) SendFile(path string, progress func(sent, total int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("hash %s: %w", path, err)
+	}
+	offer := FileOffer{Name: filepath.Base(path), Size: info.Size()}
+	copy(offer.Hash[:], h.Sum(nil))
+	offer.ID = fileID(offer)
+
+	sess := t.route("")
+	if sess == nil {
+		return ErrNotConnected
+	}
+	replies := t.files.expect(offer.ID)
+	defer t.files.forget(offer.ID)
+
+	if err := t.SendTo(sess.id, KindControl, encodeOffer(offer)).Wait(); err != nil {
+		return fmt.Errorf("send offer: %w", err)
+	}
+	reply, err := awaitReply(replies, fileOfferTimeout)
+	if err != nil {
+		return err
+	}
+	switch reply[0] {
+	case ctlFileReject:
+		return fmt.Errorf("%w: %s", ErrFileRejected, reply[1+fileIDSize:])
+	case ctlFileAccept:
+	default:
+		return fmt.Errorf("unexpected reply to file offer")
+	}
+	if len(reply) < 1+fileIDSize+8 {
+		return fmt.Errorf("malformed file accept")
+	}
+
+	offset := int64(binary.LittleEndian.Uint64(reply[1+fileIDSize:]))
+	if offset < 0 || offset > offer.Size {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	id, _ := hex.DecodeString(offer.ID)
+	buf := make([]byte, fileChunkSize)
+	for offset < offer.Size {
+		n, err := io.ReadFull(f, buf)
+		if n == 0 {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		msg := binary.LittleEndian.AppendUint64(bytes.Clone(id), uint64(offset))
+		msg = append(msg, buf[:n]...)
+		if err := t.SendTo(sess.id, KindFileChunk, msg).Wait(); err != nil {
+			return fmt.Errorf("file transfer interrupted at %d of %d bytes: %w", offset, offer.Size, err)
+		}
+		offset += int64(n)
+		if progress != nil {
+			progress(offset, offer.Size)
+		}
+	}
+
+	if err := t.SendTo(sess.id, KindControl, append([]byte{ctlFileDone}, id...)).Wait(); err != nil {
+		return fmt.Errorf("send completion: %w", err)
+	}
+	reply, err = awaitReply(replies, fileResultTimeout)
+	if err != nil {
+		return err
+	}
+	if reply[0] != ctlFileResult || len(reply) < 2+fileIDSize {
+		return fmt.Errorf("unexpected reply to file completion")
+	}
+	if reply[1+fileIDSize] == 0 {
+		return fmt.Errorf("%w: %s", errHashMismatch, reply[2+fileIDSize:])
+	}
+	return nil
+}
+
+// fileID derives a transfer ID from the file's hash, name and size, so the
+// same file maps to the same partial file on the receiver.
+func fileID(offer FileOffer) string {
+	h := sha256.New()
+	h.Write(offer.Hash[:])
+	h.Write([]byte(offer.Name))
+	_ = binary.Write(h, binary.LittleEndian, offer.Size)
+	return hex.EncodeToString(h.Sum(nil)[:fileIDSize])
+}
+
+func encodeOffer(offer FileOffer) []byte {
+	id, _ := hex.DecodeString(offer.ID)
+	msg := append([]byte{ctlFileOffer}, id...)
+	msg = binary.LittleEndian.AppendUint64(msg, uint64(offer.Size))
+	msg = append(msg, offer.Hash[:]...)
+	return append(msg, offer.Name...)
+}
+
+func decodeOffer(data []byte) (FileOffer, bool) {
+	const fixed = fileIDSize + 8 + sha256.Size
+	if len(data) < fixed {
+		return FileOffer{}, false
+	}
+	offer := FileOffer{
+		ID:   hex.EncodeToString(data[:fileIDSize]),
+		Size: int64(binary.LittleEndian.Uint64(data[fileIDSize:])),
+		Name: string(data[fixed:]),
+	}
+	copy(offer.Hash[:], data[fileIDSize+8:])
+	return offer, offer.Size >= 0
+}
+
+func awaitReply(replies <-chan []byte, timeout time.Duration) ([]byte, error) {
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-time.After(timeout):
+		return nil, errFileTimeout
+	}
+}
+
+func (ft *fileTransfers) expect(id string) <-chan []byte {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ch := make(chan []byte, 1)
+	ft.outgoing[id] = ch
+	return ch
+}
+
+func (ft *fileTransfers) forget(id string) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	delete(ft.outgoing, id)
+}
+
+// onControl handles a file transfer control message and reports whether it
+// was one.
+func (ft *fileTransfers) onControl(s *peerSession, data []byte) bool {
+	if len(data) < 1+fileIDSize {
+		return false
+	}
+	op, id := data[0], hex.EncodeToString(data[1:1+fileIDSize])
+
+	switch op {
+	case ctlFileOffer:
+		if offer, ok := decodeOffer(data[1:]); ok {
+			go ft.handleOffer(s, offer)
+		}
+	case ctlFileAccept, ctlFileReject, ctlFileResult:
+		ft.mu.Lock()
+		ch := ft.outgoing[id]
+		ft.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- bytes.Clone(data):
+			default:
+			}
+		}
+	case ctlFileDone:
+		go ft.finish(s, id)
+	default:
+		return false
+	}
+	return true
+}
+
+func (ft *fileTransfers) partPath(id string) string {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	return filepath.Join(ft.dir, ".bluetalk-"+id+".part")
+}
+
+// handleOffer asks the handler about an offer and answers it, telling the
+// sender how much of the file a previous attempt already delivered.
+func (ft *fileTransfers) handleOffer(s *peerSession, offer FileOffer) {
+	ft.mu.Lock()
+	accept, dir := ft.handler.Accept, ft.dir
+	ft.mu.Unlock()
+
+	id, _ := hex.DecodeString(offer.ID)
+	reject := func(reason string) {
+		ft.t.SendTo(s.id, KindControl, append(append([]byte{ctlFileReject}, id...), reason...))
+	}
+	switch {
+	case accept == nil:
+		reject(errNoFileHandler.Error())
+		return
+	case dir == "":
+		reject(errNoDownloadDir.Error())
+		return
+	}
+	if !accept(offer) {
+		reject("declined")
+		return
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		reject(err.Error())
+		return
+	}
+
+	part := ft.partPath(offer.ID)
+	var offset int64
+	if info, err := os.Stat(part); err == nil && info.Size() <= offer.Size {
+		offset = info.Size() - info.Size()%fileChunkSize
+	}
+	if err := os.Truncate(part, offset); err != nil && !errors.Is(err, os.ErrNotExist) {
+		reject(err.Error())
+		return
+	}
+
+	ft.mu.Lock()
+	ft.incoming[offer.ID] = incomingFile{offer: offer, from: s.id}
+	ft.mu.Unlock()
+
+	msg := binary.LittleEndian.AppendUint64(append([]byte{ctlFileAccept}, id...), uint64(offset))
+	ft.t.SendTo(s.id, KindControl, msg)
+}
+
+// onChunk writes a chunk received from s into the transfer's partial file.
+// Chunks from any peer but the one that offered the file are dropped.
+func (ft *fileTransfers) onChunk(s *peerSession, data []byte) {
+	if len(data) < fileIDSize+8 {
+		return
+	}
+	id := hex.EncodeToString(data[:fileIDSize])
+	offset := int64(binary.LittleEndian.Uint64(data[fileIDSize:]))
+	chunk := data[fileIDSize+8:]
+
+	ft.mu.Lock()
+	in, ok := ft.incoming[id]
+	progress := ft.handler.Progress
+	ft.mu.Unlock()
+	offer := in.offer
+	if !ok || in.from != s.id || offset < 0 || offset > offer.Size-int64(len(chunk)) {
+		return
+	}
+
+	f, err := os.OpenFile(ft.partPath(id), os.O_WRONLY|os.O_CREATE, 0o600)
+	if err != nil {
+		ft.t.publishStatus(fmt.Sprintf("File %s: %v", offer.Name, err))
+		return
+	}
+	_, err = f.WriteAt(chunk, offset)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		ft.t.publishStatus(fmt.Sprintf("File %s: %v", offer.Name, err))
+		return
+	}
+	if progress != nil {
+		progress(offer, offset+int64(len(chunk)))
+	}
+}
+
+// finish verifies a completed transfer's hash, moves it into place and tells
+// the sender the outcome. Only the peer that offered the file can finish it.
+func (ft *fileTransfers) finish(s *peerSession, id string) {
+	ft.mu.Lock()
+	in, ok := ft.incoming[id]
+	ok = ok && in.from == s.id
+	if ok {
+		delete(ft.incoming, id)
+	}
+	dir, done := ft.dir, ft.handler.Done
+	ft.mu.Unlock()
+	if !ok {
+		return
+	}
+	offer := in.offer
+
+	part := ft.partPath(id)
+	path, err := verifyAndPlace(part, dir, offer)
+	if err != nil && errors.Is(err, errHashMismatch) {
+		_ = os.Remove(part)
+	}
+
+	rawID, _ := hex.DecodeString(id)
+	msg := append([]byte{ctlFileResult}, rawID...)
+	if err != nil {
+		msg = append(append(msg, 0), err.Error()...)
+	} else {
+		msg = append(msg, 1)
+	}
+	ft.t.SendTo(s.id, KindControl, msg)
+
+	if done != nil {
+		done(offer, path, err)
+	}
+}
+
+func verifyAndPlace(part, dir string, offer FileOffer) (string, error) {
+	f, err := os.Open(part)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+	if n != offer.Size || !bytes.Equal(h.Sum(nil), offer.Hash[:]) {
+		return "", errHashMismatch
+	}
+
+	path := uniquePath(dir, safeFileName(offer.Name))
+	if err := os.Rename(part, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// safeFileName keeps only the last element of a name the peer sent, so it
+// cannot write outside the download directory. Names starting with a dot
+// are replaced too, so a peer can neither hide a file nor overwrite one of
+// our partial files.
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "/" || name == "" || strings.HasPrefix(name, ".") {
+		return "received-file"
+	}
+	return name
+}
+
+// uniquePath returns dir/name, or dir/name (n) with the first n not already
+// taken.
+func uniquePath(dir, name string) string {
+	path := filepath.Join(dir, name)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for n := 1; ; n++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, n, ext))
+	}
+}
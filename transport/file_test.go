@@ -0,0 +1,72 @@
+package transport
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"notes.txt", "notes.txt"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\Windows\win.ini`, "win.ini"},
+		{"/abs/path/photo.jpg", "photo.jpg"},
+		{"", "received-file"},
+		{".", "received-file"},
+		{"..", "received-file"},
+		{"/", "received-file"},
+		{".bashrc", "received-file"},
+		{"dir/.hidden", "received-file"},
+		{".bluetalk-0011.part", "received-file"},
+	}
+	for _, tt := range tests {
+		if got := safeFileName(tt.name); got != tt.want {
+			t.Errorf("safeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOnChunk(t *testing.T) {
+	const size = 100
+	chunk := []byte("0123456789")
+	tests := []struct {
+		name   string
+		from   string
+		offset uint64
+		want   int64 // size of the partial file afterwards, -1 for none
+	}{
+		{"first chunk", "a", 0, int64(len(chunk))},
+		{"last chunk", "a", size - uint64(len(chunk)), size},
+		{"past the end", "a", size - uint64(len(chunk)) + 1, -1},
+		{"offset overflows", "a", math.MaxInt64 - 1, -1},
+		{"negative offset", "a", math.MaxUint64 - 1, -1},
+		{"another peer", "b", 0, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := NewTransport(nil, nil, TransportConfig{})
+			tr.OnStatus(func(string) {})
+			tr.SetDownloadDir(t.TempDir())
+			offer := FileOffer{ID: "00112233445566778899aabbccddeeff", Name: "f", Size: size}
+			tr.files.incoming[offer.ID] = incomingFile{offer: offer, from: "a"}
+
+			id, _ := hex.DecodeString(offer.ID)
+			data := binary.LittleEndian.AppendUint64(id, tt.offset)
+			tr.files.onChunk(&peerSession{id: tt.from}, append(data, chunk...))
+
+			got := int64(-1)
+			if info, err := os.Stat(tr.files.partPath(offer.ID)); err == nil {
+				got = info.Size()
+			}
+			if got != tt.want {
+				t.Errorf("partial file size %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
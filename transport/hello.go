@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+const (
+	// protocolVersion is the wire format this build speaks, and
+	// minProtocolVersion the oldest it still talks to.
+	protocolVersion    = 1
+	minProtocolVersion = 1
+
+	// ProtocolVersion is protocolVersion for those advertising it, and
+	// MinProtocolVersion the oldest advertised version worth connecting to.
+	ProtocolVersion    byte = protocolVersion
+	MinProtocolVersion byte = minProtocolVersion
+
+	// helloSize is a HELLO body: version, the sender's link MTU (uint16
+	// little-endian) and its feature flags. Builds that gate compatibility
+	// add the oldest version they talk to and the features they refuse to
+	// go without; older builds stop at helloSize, which means neither.
+	helloSize     = 4
+	helloSizeGate = 6
+
+	featDeflate      byte = 1 << 0
+	featEncryption   byte = 1 << 1
+	featReadReceipts byte = 1 << 2
+	featAckPiggyback byte = 1 << 3
+	featTyping       byte = 1 << 4
+	featPresence     byte = 1 << 5
+	featBulkLink     byte = 1 << 6
+	featCoalesce     byte = 1 << 7
+
+	// localFeatures is everything this build can receive. featBulkLink is
+	// added while the owner can open side links; see EnableBulkLinks.
+	localFeatures = featDeflate | featEncryption | featReadReceipts | featAckPiggyback | featTyping | featPresence | featCoalesce
+)
+
+// hello is the version and feature announcement each side sends on connect.
+// minVersion is zero from a build that does not say.
+type hello struct {
+	version    byte
+	mtu        uint16
+	features   byte
+	minVersion byte
+	required   byte
+}
+
+func (h hello) encode() []byte {
+	b := []byte{h.version, 0, 0, h.features, h.minVersion, h.required}
+	binary.LittleEndian.PutUint16(b[1:], h.mtu)
+	return b
+}
+
+func decodeHello(b []byte) (hello, bool) {
+	if len(b) < helloSize {
+		return hello{}, false
+	}
+	h := hello{
+		version:  b[0],
+		mtu:      binary.LittleEndian.Uint16(b[1:]),
+		features: b[3],
+	}
+	if len(b) >= helloSizeGate {
+		h.minVersion, h.required = b[4], b[5]
+	}
+	return h, true
+}
+
+// featureNames names the feature flags for refusal reasons.
+var featureNames = [...]string{
+	"compression", "encryption", "read receipts", "piggybacked ACKs",
+	"typing indicators", "presence", "bulk links", "write coalescing",
+}
+
+// describeFeatures lists the features set in f.
+func describeFeatures(f byte) string {
+	var names []string
+	for bit, name := range featureNames {
+		if f&(1<<bit) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// sendHello announces our protocol version, MTU and features. A peer that
+// never acknowledges it predates HELLO; the session carries on with only the
+// features that need no negotiation.
+func (s *peerSession) sendHello() {
+	h := hello{
+		version:    protocolVersion,
+		mtu:        uint16(s.mtu.Load()),
+		features:   s.t.features(),
+		minVersion: minProtocolVersion,
+		required:   s.t.requiredFeatures(),
+	}
+	if err := s.sendFrame(packetHello, frameChecksum, h.encode(), false, nil); err != nil {
+		s.t.publishStatus(fmt.Sprintf("Peer did not answer HELLO, assuming an older build: %v", err))
+	}
+}
+
+// onHello applies the peer's announcement. A peer this build cannot talk to
+// is refused: one too old for us, one that finds us too old, one that
+// requires a feature we lack and one lacking a feature we require. Both
+// sides see the same HELLOs, so each refuses the other for the same reason.
+// Otherwise both sides use the lower version, the smaller MTU and the shared
+// features. A link that cannot report its own MTU grows to the peer's, and
+// says so in a HELLO of its own. HELLO may come again whenever an MTU
+// changes.
+func (s *peerSession) onHello(body []byte) {
+	h, ok := decodeHello(body)
+	if !ok {
+		return
+	}
+
+	if missing := h.required &^ s.t.features(); missing != 0 {
+		s.refuse(fmt.Sprintf("peer requires %s, which this build does not offer", describeFeatures(missing)))
+		return
+	}
+	switch {
+	case h.version < minProtocolVersion:
+		s.refuse(fmt.Sprintf("peer speaks protocol v%d, need at least v%d", h.version, minProtocolVersion))
+		return
+	case h.minVersion > protocolVersion:
+		s.refuse(fmt.Sprintf("peer needs protocol v%d or newer, this build speaks v%d", h.minVersion, protocolVersion))
+		return
+	case s.t.encrypt.Load() && h.features&featEncryption == 0:
+		s.refuse("peer does not support encryption")
+		return
+	}
+
+	s.peerVersion.Store(uint32(min(h.version, protocolVersion)))
+	prev := byte(s.peerCaps.Swap(uint32(h.features)))
+	if prev == 0 {
+		// The first HELLO of the connection: the peer can now be told
+		// what it supports hearing.
+		go s.sendPresence()
+	}
+	if prev&featBulkLink == 0 && h.features&featBulkLink != 0 {
+		s.t.bulkReady(s.id)
+	}
+	if int(h.mtu) >= minMTU {
+		s.peerMTU.Store(int32(h.mtu))
+		if s.adoptMTU.Load() && int32(h.mtu) > s.mtu.Load() && s.setMTU(int(h.mtu)) {
+			go s.sendHello()
+		}
+	}
+}
+
+// refuse gives up on a peer this build cannot talk to. The owner hears of
+// it through OnIncompatible, or OnDrop if it has not registered for that.
+func (s *peerSession) refuse(reason string) {
+	if fn := s.t.onIncompatible.Load(); fn != nil {
+		go (*fn)(s.id, reason)
+		return
+	}
+	s.drop(reason)
+}
+
+// OnIncompatible registers fn to be called instead of the OnDrop callback
+// when peer id's link is given up on because the peer cannot talk to this
+// build, with reason saying why. fn should close the link and call Detach.
+func (t *Transport) OnIncompatible(fn func(id, reason string)) {
+	if fn == nil {
+		t.onIncompatible.Store(nil)
+		return
+	}
+	t.onIncompatible.Store(&fn)
+}
+
+// requiredFeatures is what a peer must support for this build to talk to it.
+func (t *Transport) requiredFeatures() byte {
+	if t.encrypt.Load() {
+		return featEncryption
+	}
+	return 0
+}
+
+// peerSupports reports whether the peer announced feature f in its HELLO.
+func (s *peerSession) peerSupports(f byte) bool {
+	return byte(s.peerCaps.Load())&f != 0
+}
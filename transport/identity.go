@@ -0,0 +1,192 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxNameLen bounds the display name a peer may announce, in bytes.
+	maxNameLen = 64
+
+	identityContext = "bluetalk identity v1"
+)
+
+var errIdentitySignature = errors.New("identity signature does not verify")
+
+// Identity is who a peer is across connections: the Ed25519 public key it
+// keeps for the life of its installation, whatever BLE address it uses, and
+// the name it asks to be shown as. State that outlives a connection should
+// be keyed by Fingerprint, not by address.
+type Identity struct {
+	Key  ed25519.PublicKey
+	Name string
+}
+
+// Fingerprint is a short, stable ID for the identity key: the first 8 bytes
+// of its SHA-256, in hex.
+func (id Identity) Fingerprint() string {
+	return fingerprint(id.Key)
+}
+
+func fingerprint(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// localIdentity is the signing key and name this transport announces.
+type localIdentity struct {
+	key  ed25519.PrivateKey
+	name string
+}
+
+// SetIdentity sets the identity key and display name announced to peers from
+// the next connection on. A name longer than 64 bytes is cut short. A nil
+// key announces nothing, and peers only know this side by its address.
+func (t *Transport) SetIdentity(key ed25519.PrivateKey, name string) {
+	if key == nil {
+		t.identity.Store(nil)
+		return
+	}
+	t.identity.Store(&localIdentity{key: key, name: truncateName(name)})
+}
+
+// PeerIdentity returns the identity peer id proved on its current
+// connection, or the active peer's when id is empty. ok is false until the
+// peer has sent one.
+func (t *Transport) PeerIdentity(id string) (peer Identity, ok bool) {
+	s := t.route(id)
+	if s == nil {
+		return Identity{}, false
+	}
+	if p := s.peerIdentity.Load(); p != nil {
+		return *p, true
+	}
+	return Identity{}, false
+}
+
+// OnIdentity registers fn to be called when peer id proves its identity,
+// once per connection. fn runs on the receive path, so it must not block.
+func (t *Transport) OnIdentity(fn func(id string, peer Identity)) {
+	if fn == nil {
+		t.onIdentity.Store(nil)
+		return
+	}
+	t.onIdentity.Store(&fn)
+}
+
+func truncateName(name string) string {
+	for len(name) > maxNameLen {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// identitySigned is what an identity message signs: the name and, on an
+// encrypted session, both handshake keys, sender's first. Binding the
+// session keys stops a man in the middle from passing on someone else's
+// identity message as its own.
+func identitySigned(name string, ownKey, peerKey []byte) []byte {
+	b := append([]byte(identityContext), ownKey...)
+	b = append(b, peerKey...)
+	return append(b, name...)
+}
+
+// sessionKeys returns the handshake keys of an encrypted session, ours
+// first, waiting for the handshake if one is under way. Both are nil when
+// the session is not encrypted.
+func (s *peerSession) sessionKeys() (own, peer []byte) {
+	sess := s.crypto.Load()
+	if sess == nil || (!s.t.encrypt.Load() && !sess.negotiating()) {
+		return nil, nil
+	}
+	select {
+	case <-sess.ready:
+	case <-time.After(s.t.config().HandshakeTimeout):
+		return nil, nil
+	}
+	return sess.publicKey(), sess.peerKey()
+}
+
+// sendIdentity tells the peer who we are, once the session keys it is bound
+// to are agreed. The body is the public key, the signature and the name. One
+// sent before the peer started a handshake is sent again, bound, when it
+// does.
+func (s *peerSession) sendIdentity() {
+	local := s.t.identity.Load()
+	if local == nil {
+		return
+	}
+	s.identityUnbound.Store(true)
+	own, peer := s.sessionKeys()
+	if own != nil {
+		s.identityUnbound.Store(false)
+	}
+	sig := ed25519.Sign(local.key, identitySigned(local.name, own, peer))
+
+	pub := local.key.Public().(ed25519.PublicKey)
+	body := make([]byte, 0, len(pub)+len(sig)+len(local.name))
+	body = append(append(append(body, pub...), sig...), local.name...)
+	d := s.t.SendTo(s.id, KindIdentity, body)
+	if err := d.Wait(); err != nil {
+		s.t.publishStatus(fmt.Sprintf("Could not send our identity: %v", err))
+	}
+}
+
+// onIdentity checks the peer's identity message and records it. A peer that
+// changes key mid-connection is disconnected. A signature not bound to the
+// session is accepted only without encryption required locally, since the
+// session could not have been authenticated anyway; otherwise the bound one
+// the peer sends once it sees our handshake is awaited.
+func (s *peerSession) onIdentity(body []byte) {
+	if len(body) < ed25519.PublicKeySize+ed25519.SignatureSize {
+		s.t.publishStatus("Dropped peer identity: too short")
+		return
+	}
+	key := ed25519.PublicKey(bytes.Clone(body[:ed25519.PublicKeySize]))
+	sig := body[ed25519.PublicKeySize : ed25519.PublicKeySize+ed25519.SignatureSize]
+	name := string(body[ed25519.PublicKeySize+ed25519.SignatureSize:])
+
+	var own, peer []byte
+	if sess := s.crypto.Load(); sess != nil && sess.hasPeerKey() {
+		own, peer = sess.publicKey(), sess.peerKey()
+	}
+	ok := ed25519.Verify(key, identitySigned(name, peer, own), sig)
+	if !ok && own != nil && ed25519.Verify(key, identitySigned(name, nil, nil), sig) {
+		if s.t.encrypt.Load() {
+			return
+		}
+		ok = true
+	}
+	if !ok {
+		s.refuse(errIdentitySignature.Error())
+		return
+	}
+
+	id := &Identity{Key: key, Name: truncateName(name)}
+	if prev := s.peerIdentity.Swap(id); prev != nil {
+		if !prev.Key.Equal(key) {
+			s.refuse("peer changed identity key mid-connection")
+		}
+		return
+	}
+	if fn := s.t.onIdentity.Load(); fn != nil {
+		(*fn)(s.id, *id)
+	}
+}
+
+// fingerprint returns the fingerprint of the peer's identity, or "" before
+// it is known.
+func (s *peerSession) fingerprint() string {
+	if p := s.peerIdentity.Load(); p != nil {
+		return fingerprint(p.Key)
+	}
+	return ""
+}
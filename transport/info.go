@@ -0,0 +1,37 @@
+package transport
+
+// LinkInfo is what the transport has settled with a connected peer: the
+// largest packet both ends take, the protocol version both speak, and
+// whether the session keys are in place. MTU and Version stay at our own
+// until the peer's HELLO arrives.
+type LinkInfo struct {
+	Peer      string
+	MTU       int
+	Version   int
+	Encrypted bool
+}
+
+// LinkInfo returns what has been settled with peer id, or the active peer
+// when id is empty.
+func (t *Transport) LinkInfo(id string) (LinkInfo, bool) {
+	s := t.route(id)
+	if s == nil {
+		return LinkInfo{}, false
+	}
+	info := LinkInfo{
+		Peer:    s.id,
+		MTU:     s.payloadSize() + headerSize,
+		Version: protocolVersion,
+	}
+	if v := s.peerVersion.Load(); v != 0 {
+		info.Version = int(v)
+	}
+	if c := s.crypto.Load(); c != nil {
+		select {
+		case <-c.ready:
+			info.Encrypted = true
+		default:
+		}
+	}
+	return info, true
+}
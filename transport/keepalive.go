@@ -0,0 +1,102 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeepaliveConfig controls dead-peer detection. A ping is sent every
+// Interval; if nothing at all is heard from the peer for Misses intervals the
+// link is treated as lost. An Interval of zero disables keepalives.
+type KeepaliveConfig struct {
+	Interval time.Duration
+	Misses   int
+}
+
+var defaultKeepalive = KeepaliveConfig{
+	Interval: 2 * time.Second,
+	Misses:   3,
+}
+
+// SetKeepalive replaces the keepalive settings. They take effect on the next
+// connection.
+func (t *Transport) SetKeepalive(cfg KeepaliveConfig) {
+	t.keepMu.Lock()
+	defer t.keepMu.Unlock()
+	t.keepalive = cfg
+}
+
+func (t *Transport) keepaliveConfig() KeepaliveConfig {
+	t.keepMu.Lock()
+	defer t.keepMu.Unlock()
+	return t.keepalive
+}
+
+// LastHeard returns when a packet last arrived from peer id, or from the
+// active peer when id is empty. It is zero if that peer is not connected.
+func (t *Transport) LastHeard(id string) time.Time {
+	s := t.route(id)
+	if s == nil || s.link.Load() == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, s.lastHeard.Load())
+}
+
+// startKeepalive starts pinging the peer for a new connection, stopping any
+// loop left from the previous one.
+func (s *peerSession) startKeepalive() {
+	s.keepMu.Lock()
+	defer s.keepMu.Unlock()
+
+	if s.keepStop != nil {
+		close(s.keepStop)
+		s.keepStop = nil
+	}
+	s.lastHeard.Store(time.Now().UnixNano())
+
+	cfg := s.t.keepaliveConfig()
+	if cfg.Interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	s.keepStop = stop
+	go s.runKeepalive(cfg, stop)
+}
+
+func (s *peerSession) stopKeepalive() {
+	s.keepMu.Lock()
+	defer s.keepMu.Unlock()
+	if s.keepStop != nil {
+		close(s.keepStop)
+		s.keepStop = nil
+	}
+}
+
+func (s *peerSession) runKeepalive(cfg KeepaliveConfig, stop <-chan struct{}) {
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	deadAfter := time.Duration(max(cfg.Misses, 1)) * cfg.Interval
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		// Sweep here too, so a message whose sender went quiet is reported
+		// without waiting for the next packet.
+		s.rxMu.Lock()
+		s.expireReassembly(time.Now(), s.t.config().ReassemblyTimeout)
+		s.rxMu.Unlock()
+
+		silent := time.Since(time.Unix(0, s.lastHeard.Load()))
+		if silent >= deadAfter {
+			s.drop(fmt.Sprintf("no response from peer for %s", silent.Round(time.Second)))
+			return
+		}
+		s.stats.onPing()
+		_ = s.writeWith(func(dst []byte) []byte { return appendHeader(dst, packetPing, 0, 0, 0, 0) })
+		s.publishQuality()
+	}
+}
@@ -0,0 +1,22 @@
+package transport
+
+// Link is a connection to one peer that carries whole packets. BlueTalk runs
+// it over a BLE characteristic, but any byte-oriented link will do: the
+// transport adds sequencing, acknowledgement, retransmission and encryption
+// on top.
+type Link interface {
+	// Write sends one packet of at most MTU bytes. The transport reuses the
+	// packet's memory once Write returns.
+	Write(packet []byte) error
+
+	// OnPacket registers fn to receive every packet the link delivers,
+	// replacing any earlier fn; nil stops delivery. fn must not keep the
+	// packet after it returns.
+	OnPacket(fn func(packet []byte))
+
+	// MTU returns the largest packet the link carries. A link that cannot
+	// tell, like the peripheral end of a BLE connection on a stack that
+	// hides the ATT MTU, returns 0 and is sized to the MTU the peer
+	// announces, since both ends of a link share one.
+	MTU() int
+}
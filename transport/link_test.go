@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// faults describes how a lossyLink mistreats the packets written to it. The
+// probabilities apply to each packet independently.
+type faults struct {
+	drop      float64
+	duplicate float64
+	reorder   float64
+	corrupt   float64
+
+	// latency is how long every packet takes to arrive; a reordered one
+	// takes up to reorderDelay longer, letting later packets overtake it.
+	latency      time.Duration
+	reorderDelay time.Duration
+}
+
+// lossyLink is one end of an in-memory Link. Packets written to it arrive at
+// the other end after the configured latency, in order unless they are
+// reordered, and subject to the configured faults. Corruption flips a bit in
+// the payload of a data fragment, which only the message checksum can catch.
+type lossyLink struct {
+	mtu    int
+	faults faults
+	peer   *lossyLink
+
+	// filter, if set, sees every packet written and drops those it returns
+	// true for, before any random fault applies.
+	filter func(p *Packet) bool
+
+	mu  sync.Mutex
+	rng *rand.Rand
+	fn  func(packet []byte)
+
+	// recvMu serialises deliveries, as a radio stack does.
+	recvMu sync.Mutex
+
+	queue chan timedPacket
+	stop  chan struct{}
+}
+
+type timedPacket struct {
+	at     time.Time
+	packet []byte
+}
+
+// newLossyPair returns two connected ends with the same MTU, each mistreating
+// what it sends as its faults say. Close both when done.
+func newLossyPair(mtu int, seed uint64, aToB, bToA faults) (a, b *lossyLink) {
+	a = newLossyLink(mtu, aToB, seed)
+	b = newLossyLink(mtu, bToA, seed+1)
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func newLossyLink(mtu int, f faults, seed uint64) *lossyLink {
+	l := &lossyLink{
+		mtu:    mtu,
+		faults: f,
+		rng:    rand.New(rand.NewPCG(seed, seed)),
+		queue:  make(chan timedPacket, 1024),
+		stop:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *lossyLink) MTU() int {
+	return l.mtu
+}
+
+func (l *lossyLink) OnPacket(fn func(packet []byte)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fn = fn
+}
+
+func (l *lossyLink) Write(packet []byte) error {
+	if l.filter != nil {
+		if p, err := DecodePacket(packet); err == nil && l.filter(p) {
+			return nil
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f := l.faults
+	if l.rng.Float64() < f.drop {
+		return nil
+	}
+	copies := 1
+	if l.rng.Float64() < f.duplicate {
+		copies = 2
+	}
+	for range copies {
+		buf := append([]byte(nil), packet...)
+		if l.rng.Float64() < f.corrupt && buf[offType] == packetData && len(buf) > headerSize {
+			buf[headerSize] ^= 1 << l.rng.IntN(8)
+		}
+		if l.rng.Float64() < f.reorder && f.reorderDelay > 0 {
+			delay := f.latency + time.Duration(l.rng.Int64N(int64(f.reorderDelay)))
+			time.AfterFunc(delay, func() { l.peer.deliver(buf) })
+			continue
+		}
+		select {
+		case l.queue <- timedPacket{at: time.Now().Add(f.latency), packet: buf}:
+		case <-l.stop:
+		}
+	}
+	return nil
+}
+
+// run delivers packets that were not reordered, in the order written.
+func (l *lossyLink) run() {
+	for {
+		select {
+		case <-l.stop:
+			return
+		case tp := <-l.queue:
+			time.Sleep(time.Until(tp.at))
+			l.peer.deliver(tp.packet)
+		}
+	}
+}
+
+func (l *lossyLink) deliver(packet []byte) {
+	l.recvMu.Lock()
+	defer l.recvMu.Unlock()
+	l.mu.Lock()
+	fn := l.fn
+	l.mu.Unlock()
+	if fn != nil {
+		fn(packet)
+	}
+}
+
+func (l *lossyLink) Close() {
+	close(l.stop)
+}
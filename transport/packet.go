@@ -0,0 +1,247 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Wire format
+//
+// Every packet starts with a six-byte header:
+//
+//	0  magic and wire version: 0xB0 | wireVersion
+//	1  packet type
+//	2  flags (frame*)
+//	3  message seq, 1-255 (0 for ping and pong)
+//	4  fragment count of the message
+//	5  fragment index (ACK: fragments received in order; NACK: entries)
+//
+// Data, handshake and HELLO packets carry one fragment after the header, and
+// every fragment of a message has the same flags apart from frameAck. A
+// message is the concatenation of its fragments, ending in a CRC-32 (IEEE)
+// of the rest when frameChecksum is set. frameEncrypted and frameCompressed
+// mark a message sealed with the session key and deflated (deflated first).
+// frameAck means the last 6 bytes of the packet are an ACK trailer: seq,
+// count received in order and bitmap. An ACK carries a 32-bit bitmap and a
+// NACK the indices of missing fragments. A batch carries whole packets of
+// any other type, each after a one-byte length, and their count in the index
+// field. All multi-byte integers are little-endian.
+//
+// A sender only sets a feature flag the peer announced support for in its
+// HELLO, so features are toggled per connection without a new version.
+//
+// The layout is frozen for a given wireVersion: any change to it, however
+// small, must bump the version, so builds that disagree reject each other's
+// packets instead of misreading them.
+const (
+	wireMagic   byte = 0xB0
+	wireVersion byte = 2
+
+	offWire  = 0
+	offType  = 1
+	offFlags = 2
+	offSeq   = 3
+	offTotal = 4
+	offIndex = 5
+)
+
+// Header flags.
+const (
+	frameChecksum   byte = 1 << 0
+	frameCompressed byte = 1 << 1
+	frameEncrypted  byte = 1 << 2
+	frameAck        byte = 1 << 3
+
+	frameKnown = frameChecksum | frameCompressed | frameEncrypted | frameAck
+)
+
+var (
+	// ErrMalformedPacket is wrapped by every error DecodePacket returns for a
+	// packet it cannot parse.
+	ErrMalformedPacket = errors.New("malformed packet")
+
+	// ErrWireVersion is wrapped by the error DecodePacket returns for a
+	// BlueTalk packet in a wire format this build does not speak.
+	ErrWireVersion = errors.New("unsupported wire format version")
+)
+
+// appendHeader appends a packet header to dst.
+func appendHeader(dst []byte, ptype, flags, seq, total, idx byte) []byte {
+	return append(dst, wireMagic|wireVersion, ptype, flags, seq, total, idx)
+}
+
+// PacketError describes why DecodePacket rejected a packet.
+type PacketError struct {
+	Type   byte
+	Reason string
+}
+
+func (e *PacketError) Error() string {
+	return fmt.Sprintf("%v (type 0x%02x): %s", ErrMalformedPacket, e.Type, e.Reason)
+}
+
+func (e *PacketError) Unwrap() error {
+	return ErrMalformedPacket
+}
+
+// Packet is one decoded transport packet.
+//
+// For data packets (data, handshake and HELLO) Index is the
+// fragment index and Payload the fragment. For an ACK, Index is the count of
+// fragments received in order and Bitmap the fragments received after the
+// first gap. For a NACK, Missing lists the fragments the peer lacks. For a
+// batch, Batch holds the packets it carries, still encoded. Ping and pong
+// carry nothing.
+type Packet struct {
+	Type    byte
+	Flags   byte
+	Seq     uint8
+	Total   uint8
+	Index   uint8
+	Payload []byte
+	Bitmap  uint32
+	Missing []uint8
+	Batch   [][]byte
+
+	// Ack is an ACK carried on a data packet, if there was one.
+	Ack *Packet
+}
+
+// DecodePacket parses and validates a packet as received from the link. It
+// never panics, and a packet it accepts is consistent: fragment indices are
+// below the fragment count and every length is in bounds. Payload, Missing
+// and Batch alias data.
+func DecodePacket(data []byte) (*Packet, error) {
+	if len(data) < headerSize {
+		var t byte
+		if len(data) > offType {
+			t = data[offType]
+		}
+		return nil, &PacketError{Type: t, Reason: fmt.Sprintf("%d bytes is shorter than the header", len(data))}
+	}
+	if data[offWire]&0xF0 != wireMagic {
+		return nil, &PacketError{Type: data[offType], Reason: "not a BlueTalk packet"}
+	}
+	if v := data[offWire] & 0x0F; v != wireVersion {
+		return nil, fmt.Errorf("%w: peer sent v%d, this build speaks v%d", ErrWireVersion, v, wireVersion)
+	}
+	if len(data) > maxPacketSize {
+		return nil, &PacketError{Type: data[offType], Reason: fmt.Sprintf("%d bytes is longer than any link carries", len(data))}
+	}
+
+	p := &Packet{
+		Type:  data[offType],
+		Flags: data[offFlags],
+		Seq:   data[offSeq],
+		Total: data[offTotal],
+		Index: data[offIndex],
+	}
+	fail := func(format string, args ...any) (*Packet, error) {
+		return nil, &PacketError{Type: p.Type, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if p.Flags&^frameKnown != 0 {
+		return fail("unknown flags 0x%02x", p.Flags&^frameKnown)
+	}
+	if p.Flags != 0 && !isDataPacket(p.Type) {
+		return fail("flags 0x%02x on a packet that takes none", p.Flags)
+	}
+	if p.Flags&frameAck != 0 {
+		if len(data) < headerSize+ackTrailerSize {
+			return fail("too short for its ACK trailer")
+		}
+		tr := data[len(data)-ackTrailerSize:]
+		p.Ack = &Packet{Type: packetAck, Seq: tr[0], Index: tr[1], Bitmap: binary.LittleEndian.Uint32(tr[2:])}
+		data = data[:len(data)-ackTrailerSize]
+	}
+
+	switch {
+	case p.Type == packetPing || p.Type == packetPong:
+	case p.Type == packetAck:
+		if len(data) < ackSize {
+			return fail("ACK of %d bytes, want %d", len(data), ackSize)
+		}
+		if p.Index > p.Total {
+			return fail("ACK covers %d of %d fragments", p.Index, p.Total)
+		}
+		p.Bitmap = binary.LittleEndian.Uint32(data[headerSize:])
+	case p.Type == packetNack:
+		n := int(p.Index)
+		if n == 0 || len(data) < headerSize+n {
+			return fail("NACK lists %d fragments in %d bytes", n, len(data)-headerSize)
+		}
+		p.Missing = data[headerSize : headerSize+n]
+		for _, idx := range p.Missing {
+			if idx >= p.Total {
+				return fail("NACK for fragment %d of %d", idx, p.Total)
+			}
+		}
+	case p.Type == packetBatch:
+		rest := data[headerSize:]
+		for range p.Index {
+			if len(rest) == 0 || len(rest) < 1+int(rest[0]) {
+				return fail("batch of %d packets ends early", p.Index)
+			}
+			n := int(rest[0])
+			if n < headerSize || rest[1+offType] == packetBatch {
+				return fail("batch entry is not a packet")
+			}
+			p.Batch = append(p.Batch, rest[1:1+n])
+			rest = rest[1+n:]
+		}
+		if p.Index == 0 || len(rest) != 0 {
+			return fail("batch of %d packets in %d bytes", p.Index, len(data)-headerSize)
+		}
+	case isDataPacket(p.Type):
+		if p.Total == 0 {
+			return fail("message of zero fragments")
+		}
+		if p.Index >= p.Total {
+			return fail("fragment %d of %d", p.Index, p.Total)
+		}
+		if len(data) == headerSize {
+			return fail("empty fragment")
+		}
+		p.Payload = data[headerSize:]
+	default:
+		return fail("unknown packet type")
+	}
+	return p, nil
+}
+
+// Append appends the wire encoding of p to dst. It is the inverse of
+// DecodePacket: decoding what it appends gives back an equal Packet.
+func (p *Packet) Append(dst []byte) []byte {
+	flags := p.Flags
+	if p.Ack != nil {
+		flags |= frameAck
+	}
+	dst = appendHeader(dst, p.Type, flags, p.Seq, p.Total, p.Index)
+	switch {
+	case p.Type == packetAck:
+		dst = binary.LittleEndian.AppendUint32(dst, p.Bitmap)
+	case p.Type == packetNack:
+		dst = append(dst, p.Missing...)
+	case p.Type == packetBatch:
+		for _, packet := range p.Batch {
+			dst = append(dst, byte(len(packet)))
+			dst = append(dst, packet...)
+		}
+	default:
+		dst = append(dst, p.Payload...)
+	}
+	if p.Ack != nil {
+		dst = append(dst, p.Ack.Seq, p.Ack.Index)
+		dst = binary.LittleEndian.AppendUint32(dst, p.Ack.Bitmap)
+	}
+	return dst
+}
+
+func isDataPacket(t byte) bool {
+	switch t {
+	case packetData, packetHandshake, packetHello:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// seedPackets returns well-formed packets of every type, as the transport
+// writes them.
+func seedPackets() [][]byte {
+	data := appendHeader(nil, packetData, frameChecksum, 7, 3, 1)
+	data = append(data, "hello"...)
+
+	withAck := appendHeader(nil, packetData, frameCompressed|frameAck, 9, 2, 0)
+	withAck = append(withAck, "payload"...)
+	withAck = append(withAck, 4, 2, 0x05, 0, 0, 0)
+
+	nack := appendHeader(nil, packetNack, 0, 7, 10, 3)
+	nack = append(nack, 2, 5, 9)
+
+	ping := appendHeader(nil, packetPing, 0, 0, 0, 0)
+	ack := appendAck(nil, 7, 10, ackInfo{cum: 4, bitmap: 0b1011})
+
+	batch := appendHeader(nil, packetBatch, 0, 0, 0, 2)
+	batch = append(batch, byte(len(ping)))
+	batch = append(batch, ping...)
+	batch = append(batch, byte(len(ack)))
+	batch = append(batch, ack...)
+
+	hello := appendHeader(nil, packetHello, frameChecksum, 1, 1, 0)
+	hello = append(hello, 1, 2, 3, 4)
+
+	return [][]byte{
+		data,
+		withAck,
+		nack,
+		ping,
+		appendHeader(nil, packetPong, 0, 0, 0, 0),
+		ack,
+		batch,
+		hello,
+	}
+}
+
+func TestDecodePacketRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   error
+	}{
+		{"empty", nil, ErrMalformedPacket},
+		{"short header", []byte{wireMagic | wireVersion, packetData}, ErrMalformedPacket},
+		{"not bluetalk", []byte{0x12, packetData, 0, 1, 1, 0, 'x'}, ErrMalformedPacket},
+		{"other version", []byte{wireMagic | (wireVersion + 1), packetData, 0, 1, 1, 0, 'x'}, ErrWireVersion},
+		{"too long", append(appendHeader(nil, packetData, 0, 1, 1, 0), make([]byte, maxPacketSize)...), ErrMalformedPacket},
+		{"unknown type", append(appendHeader(nil, 0x7f, 0, 1, 1, 0), 'x'), ErrMalformedPacket},
+		{"unknown flag", append(appendHeader(nil, packetData, 0x80, 1, 1, 0), 'x'), ErrMalformedPacket},
+		{"flags on ping", appendHeader(nil, packetPing, frameChecksum, 0, 0, 0), ErrMalformedPacket},
+		{"zero fragments", append(appendHeader(nil, packetData, 0, 1, 0, 0), 'x'), ErrMalformedPacket},
+		{"index past total", append(appendHeader(nil, packetData, 0, 1, 2, 2), 'x'), ErrMalformedPacket},
+		{"empty fragment", appendHeader(nil, packetData, 0, 1, 1, 0), ErrMalformedPacket},
+		{"short ack trailer", append(appendHeader(nil, packetData, frameAck, 1, 1, 0), 1, 2), ErrMalformedPacket},
+		{"short ack", appendHeader(nil, packetAck, 0, 1, 4, 2), ErrMalformedPacket},
+		{"ack past total", append(appendHeader(nil, packetAck, 0, 1, 4, 5), 0, 0, 0, 0), ErrMalformedPacket},
+		{"empty nack", appendHeader(nil, packetNack, 0, 1, 4, 0), ErrMalformedPacket},
+		{"nack past total", append(appendHeader(nil, packetNack, 0, 1, 4, 1), 4), ErrMalformedPacket},
+		{"nack ends early", append(appendHeader(nil, packetNack, 0, 1, 4, 3), 1), ErrMalformedPacket},
+		{"empty batch", appendHeader(nil, packetBatch, 0, 0, 0, 0), ErrMalformedPacket},
+		{"batch ends early", append(appendHeader(nil, packetBatch, 0, 0, 0, 1), 6, wireMagic|wireVersion), ErrMalformedPacket},
+		{"batch entry too short", append(appendHeader(nil, packetBatch, 0, 0, 0, 1), 1, 0), ErrMalformedPacket},
+		{"nested batch", append(appendHeader(nil, packetBatch, 0, 0, 0, 1), append([]byte{6}, appendHeader(nil, packetBatch, 0, 0, 0, 0)...)...), ErrMalformedPacket},
+		{"batch trailing bytes", append(append(appendHeader(nil, packetBatch, 0, 0, 0, 1), append([]byte{6}, appendHeader(nil, packetPing, 0, 0, 0, 0)...)...), 0), ErrMalformedPacket},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := DecodePacket(tt.packet)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("DecodePacket() = %+v, %v; want error %v", p, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	for _, packet := range seedPackets() {
+		p, err := DecodePacket(packet)
+		if err != nil {
+			t.Fatalf("DecodePacket(%x): %v", packet, err)
+		}
+		if got := p.Append(nil); !bytes.Equal(got, packet) {
+			t.Errorf("Append() = %x, want %x", got, packet)
+		}
+	}
+}
+
+func FuzzDecodePacket(f *testing.F) {
+	for _, packet := range seedPackets() {
+		f.Add(packet)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := DecodePacket(data)
+		if err != nil {
+			if p != nil {
+				t.Fatalf("DecodePacket returned a packet with error %v", err)
+			}
+			return
+		}
+		if isDataPacket(p.Type) && (p.Total == 0 || p.Index >= p.Total) {
+			t.Fatalf("accepted fragment %d of %d", p.Index, p.Total)
+		}
+		for _, idx := range p.Missing {
+			if idx >= p.Total {
+				t.Fatalf("accepted NACK for fragment %d of %d", idx, p.Total)
+			}
+		}
+
+		encoded := p.Append(nil)
+		again, err := DecodePacket(encoded)
+		if err != nil {
+			t.Fatalf("DecodePacket(Append(%+v)): %v", p, err)
+		}
+		if !reflect.DeepEqual(again, p) {
+			t.Fatalf("round trip changed the packet:\n got %+v\nwant %+v", again, p)
+		}
+		if got := again.Append(nil); !bytes.Equal(got, encoded) {
+			t.Fatalf("encoding is not stable: %x then %x", encoded, got)
+		}
+	})
+}
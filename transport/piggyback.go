@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const (
+	// ackTrailerSize is the ACK a fragment with frameAck carries after its
+	// payload: the acknowledged seq, cumulative count and bitmap (uint32
+	// little-endian).
+	ackTrailerSize = 6
+
+	// ackDelay is how long an ACK waits for an outgoing fragment to ride on
+	// before it is sent on its own.
+	ackDelay = 15 * time.Millisecond
+)
+
+// ackQueue holds ACKs waiting to ride on outgoing data. Only peers that
+// announce featAckPiggyback get delayed ACKs; everyone else is ACKed at once.
+type ackQueue struct {
+	mu      sync.Mutex
+	pending map[uint8]pendingAck
+	timer   *time.Timer
+}
+
+type pendingAck struct {
+	total uint8
+	info  ackInfo
+}
+
+func appendAck(dst []byte, seq, total uint8, ack ackInfo) []byte {
+	dst = appendHeader(dst, packetAck, 0, seq, total, ack.cum)
+	return binary.LittleEndian.AppendUint32(dst, ack.bitmap)
+}
+
+// sendAck acknowledges message seq, holding the ACK back for up to ackDelay
+// in case a fragment going the other way can carry it. A newer ACK for the
+// same message replaces the queued one.
+func (s *peerSession) sendAck(seq, total uint8, ack ackInfo) {
+	if !s.peerSupports(featAckPiggyback) {
+		_ = s.writeWith(func(dst []byte) []byte { return appendAck(dst, seq, total, ack) })
+		return
+	}
+
+	q := &s.acks
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		q.pending = make(map[uint8]pendingAck)
+	}
+	q.pending[seq] = pendingAck{total: total, info: ack}
+	if q.timer == nil {
+		q.timer = time.AfterFunc(ackDelay, s.flushAcks)
+	}
+}
+
+// forgetAck discards a queued ACK for message seq, so it cannot follow a NACK
+// that asks for the whole message again.
+func (s *peerSession) forgetAck(seq uint8) {
+	q := &s.acks
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pending, seq)
+}
+
+// flushAcks sends every queued ACK on its own.
+func (s *peerSession) flushAcks() {
+	q := &s.acks
+	q.mu.Lock()
+	pending := q.pending
+	q.pending = nil
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	q.mu.Unlock()
+
+	for seq, p := range pending {
+		_ = s.writeWith(func(dst []byte) []byte { return appendAck(dst, seq, p.total, p.info) })
+	}
+}
+
+// attachAck appends packet to dst followed by a queued ACK, if one is
+// waiting and fits within the link MTU.
+func (s *peerSession) attachAck(dst, packet []byte) []byte {
+	start := len(dst)
+	dst = append(dst, packet...)
+
+	q := &s.acks
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 || len(packet)+ackTrailerSize > s.payloadSize()+headerSize {
+		return dst
+	}
+
+	var seq uint8
+	var p pendingAck
+	for seq, p = range q.pending {
+		break
+	}
+	delete(q.pending, seq)
+	if len(q.pending) == 0 && q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+
+	dst[start+offFlags] |= frameAck
+	dst = append(dst, seq, p.info.cum)
+	return binary.LittleEndian.AppendUint32(dst, p.info.bitmap)
+}
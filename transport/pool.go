@@ -0,0 +1,41 @@
+package transport
+
+import "sync"
+
+// packetPool recycles packet-sized buffers for the receive and ACK paths,
+// which handle a packet per link notification and would otherwise allocate
+// for each one during a file transfer.
+var packetPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, maxPacketSize)
+		return &b
+	},
+}
+
+func getPacketBuf() *[]byte {
+	return packetPool.Get().(*[]byte)
+}
+
+func putPacketBuf(b *[]byte) {
+	*b = (*b)[:0]
+	packetPool.Put(b)
+}
+
+// writeWith builds a packet into a pooled buffer and writes it. The link
+// copies what it is given, so the buffer is reused once write returns.
+func (s *peerSession) writeWith(build func(dst []byte) []byte) error {
+	buf := getPacketBuf()
+	defer putPacketBuf(buf)
+	*buf = build((*buf)[:0])
+	return s.write(*buf)
+}
+
+// release returns a reassembly buffer's fragments to the pool.
+func (m *rxMessage) release() {
+	for i, f := range m.fragments {
+		if f != nil {
+			putPacketBuf(f)
+			m.fragments[i] = nil
+		}
+	}
+}
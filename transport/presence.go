@@ -0,0 +1,136 @@
+package transport
+
+import (
+	"fmt"
+	"time"
+)
+
+// presenceInterval is how often presence is announced to each peer while
+// connected, so its last-seen time stays current.
+const presenceInterval = 30 * time.Second
+
+// PresenceStatus is whether a user is available to chat.
+type PresenceStatus byte
+
+const (
+	PresenceOnline PresenceStatus = iota + 1
+	PresenceAway
+	PresenceBusy
+)
+
+func (s PresenceStatus) String() string {
+	switch s {
+	case PresenceOnline:
+		return "online"
+	case PresenceAway:
+		return "away"
+	case PresenceBusy:
+		return "busy"
+	default:
+		return fmt.Sprintf("status(%d)", byte(s))
+	}
+}
+
+// Presence is what a peer announces about its user: availability and the
+// nickname to show. It is sent as a KindPresence message of the status byte
+// followed by the nickname.
+type Presence struct {
+	Status   PresenceStatus
+	Nickname string
+}
+
+func encodePresence(p Presence) []byte {
+	return append([]byte{byte(p.Status)}, truncateName(p.Nickname)...)
+}
+
+func decodePresence(data []byte) (Presence, bool) {
+	if len(data) < 1 || len(data) > 1+maxNameLen || data[0] == 0 {
+		return Presence{}, false
+	}
+	return Presence{Status: PresenceStatus(data[0]), Nickname: string(data[1:])}, true
+}
+
+// SetPresence sets the presence announced to peers, sending it to every
+// connected peer at once and then every presenceInterval. A nickname longer
+// than 64 bytes is cut short.
+func (t *Transport) SetPresence(p Presence) {
+	p.Nickname = truncateName(p.Nickname)
+	t.presence.Store(&p)
+
+	t.sessMu.Lock()
+	sessions := make([]*peerSession, 0, len(t.sessions))
+	for _, s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	t.sessMu.Unlock()
+	for _, s := range sessions {
+		go s.sendPresence()
+	}
+}
+
+// OnPresence registers fn to receive every presence announcement a peer
+// sends, about every presenceInterval while it stays connected. fn runs on
+// the receive path, so it must not block.
+func (t *Transport) OnPresence(fn func(id string, p Presence)) {
+	if fn == nil {
+		t.onPresence.Store(nil)
+		return
+	}
+	t.onPresence.Store(&fn)
+}
+
+// sendPresence announces our presence to the session's peer, if we have one
+// to announce and the peer understands it.
+func (s *peerSession) sendPresence() {
+	p := s.t.presence.Load()
+	if p == nil || !s.peerSupports(featPresence) {
+		return
+	}
+	s.t.SendTTL(s.id, KindPresence, encodePresence(*p), presenceInterval)
+}
+
+// startPresence starts announcing presence on a new connection, stopping
+// any loop left from the previous one. The first announcement waits for the
+// peer's HELLO; see onHello.
+func (s *peerSession) startPresence() {
+	s.presenceMu.Lock()
+	defer s.presenceMu.Unlock()
+	if s.presenceStop != nil {
+		close(s.presenceStop)
+	}
+	stop := make(chan struct{})
+	s.presenceStop = stop
+	go s.runPresence(stop)
+}
+
+func (s *peerSession) stopPresence() {
+	s.presenceMu.Lock()
+	defer s.presenceMu.Unlock()
+	if s.presenceStop != nil {
+		close(s.presenceStop)
+		s.presenceStop = nil
+	}
+}
+
+func (s *peerSession) runPresence(stop <-chan struct{}) {
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.sendPresence()
+		}
+	}
+}
+
+func (s *peerSession) onPresence(data []byte) {
+	p, ok := decodePresence(data)
+	if !ok {
+		return
+	}
+	if fn := s.t.onPresence.Load(); fn != nil {
+		(*fn)(s.id, p)
+	}
+}
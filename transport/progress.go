@@ -0,0 +1,40 @@
+package transport
+
+import "fmt"
+
+// ReceiveProgress reports how far reassembly of an incoming message has got.
+// Abandoned is set when a partly received message is discarded, because it
+// expired after ReassemblyTimeout or its seq was reused by a new message.
+type ReceiveProgress struct {
+	From      string
+	Seq       uint8
+	Received  int
+	Total     int
+	Abandoned bool
+}
+
+// OnProgress registers fn to be called for each new fragment of an incoming
+// message and for each message abandoned before it completed. Like
+// OnMessage, fn runs on the receive path and must not block.
+func (t *Transport) OnProgress(fn func(p ReceiveProgress)) {
+	if fn == nil {
+		t.onProgress.Store(nil)
+		return
+	}
+	t.onProgress.Store(&fn)
+}
+
+func (s *peerSession) reportProgress(seq uint8, msg *rxMessage) {
+	if fn := s.t.onProgress.Load(); fn != nil {
+		(*fn)(ReceiveProgress{From: s.id, Seq: seq, Received: msg.received, Total: int(msg.total)})
+	}
+}
+
+// abandon reports a partly received message that is being discarded.
+func (s *peerSession) abandon(seq uint8, msg *rxMessage, why string) {
+	s.t.publishStatus(fmt.Sprintf("Abandoned incomplete message (seq=%d, %d of %d fragments): %s",
+		seq, msg.received, msg.total, why))
+	if fn := s.t.onProgress.Load(); fn != nil {
+		(*fn)(ReceiveProgress{From: s.id, Seq: seq, Received: msg.received, Total: int(msg.total), Abandoned: true})
+	}
+}
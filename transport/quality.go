@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// LinkQuality is a rolling estimate of how well the link to a peer is
+// carrying traffic, from what the transport observes rather than RSSI.
+// Score runs from 0 (unusable) to 100; Bars maps it onto 0-4 for a signal
+// indicator.
+type LinkQuality struct {
+	Peer  string
+	Score int
+	Bars  int
+
+	// RTT is the smoothed fragment round trip, RetransmitRate the share of
+	// recent fragment writes that were retransmissions, and Jitter the
+	// smoothed variation between keepalive round trips.
+	RTT            time.Duration
+	RetransmitRate float64
+	Jitter         time.Duration
+}
+
+const (
+	// qualityWeight is the weight of each new observation in the rolling
+	// retransmission rate and jitter.
+	qualityWeight = 0.1
+
+	// Round trips and jitter up to the good bound cost nothing; at the bad
+	// bound they cost their full share of the score.
+	rttGood, rttBad       = 100 * time.Millisecond, 2 * time.Second
+	jitterGood, jitterBad = 20 * time.Millisecond, 500 * time.Millisecond
+)
+
+// linkStats accumulates the observations behind a session's LinkQuality.
+type linkStats struct {
+	mu          sync.Mutex
+	retransmit  float64
+	pingSentAt  time.Time
+	lastPingRTT time.Duration
+	jitter      time.Duration
+}
+
+// OnLinkQuality registers fn to receive the link quality of each connected
+// peer once per keepalive interval. It runs on the keepalive loop and must
+// not block.
+func (t *Transport) OnLinkQuality(fn func(q LinkQuality)) {
+	if fn == nil {
+		t.onQuality.Store(nil)
+		return
+	}
+	t.onQuality.Store(&fn)
+}
+
+// LinkQuality returns the current link quality of the active peer.
+func (t *Transport) LinkQuality() (LinkQuality, bool) {
+	s := t.route("")
+	if s == nil {
+		return LinkQuality{}, false
+	}
+	return s.linkQuality(), true
+}
+
+// onTransmit records a fragment write; retry is whether it was a resend.
+func (ls *linkStats) onTransmit(retry bool) {
+	var sample float64
+	if retry {
+		sample = 1
+	}
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.retransmit += qualityWeight * (sample - ls.retransmit)
+}
+
+func (ls *linkStats) onPing() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.pingSentAt = time.Now()
+}
+
+// onPong measures the keepalive round trip and folds its change since the
+// last one into the jitter.
+func (ls *linkStats) onPong() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	if ls.pingSentAt.IsZero() {
+		return
+	}
+	rtt := time.Since(ls.pingSentAt)
+	ls.pingSentAt = time.Time{}
+	if ls.lastPingRTT != 0 {
+		diff := rtt - ls.lastPingRTT
+		if diff < 0 {
+			diff = -diff
+		}
+		ls.jitter += time.Duration(qualityWeight * float64(diff-ls.jitter))
+	}
+	ls.lastPingRTT = rtt
+}
+
+func (ls *linkStats) reset() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.retransmit, ls.jitter, ls.lastPingRTT = 0, 0, 0
+	ls.pingSentAt = time.Time{}
+}
+
+func (s *peerSession) linkQuality() LinkQuality {
+	s.cc.mu.Lock()
+	rtt := s.cc.srtt
+	s.cc.mu.Unlock()
+
+	s.stats.mu.Lock()
+	rate, jitter := s.stats.retransmit, s.stats.jitter
+	s.stats.mu.Unlock()
+
+	// Retransmissions weigh most: half the fragments being resent leaves
+	// nothing of their share.
+	score := 100.0
+	score -= 30 * penalty(rtt, rttGood, rttBad)
+	score -= 50 * min(rate*2, 1)
+	score -= 20 * penalty(jitter, jitterGood, jitterBad)
+
+	q := LinkQuality{
+		Peer:           s.id,
+		Score:          int(score + 0.5),
+		RTT:            rtt,
+		RetransmitRate: rate,
+		Jitter:         jitter,
+	}
+	q.Bars = min(q.Score/20, 4)
+	return q
+}
+
+// penalty scales v between good (0) and bad (1).
+func penalty(v, good, bad time.Duration) float64 {
+	switch {
+	case v <= good:
+		return 0
+	case v >= bad:
+		return 1
+	}
+	return float64(v-good) / float64(bad-good)
+}
+
+// publishQuality hands the session's link quality to the OnLinkQuality
+// callback.
+func (s *peerSession) publishQuality() {
+	if fn := s.t.onQuality.Load(); fn != nil {
+		(*fn)(s.linkQuality())
+	}
+}
@@ -0,0 +1,270 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxQueued bounds the messages held for a peer that is not connected; past
+// it new messages fail with ErrNotConnected as before.
+const maxQueued = 256
+
+// ErrExpired is the error of a message whose TTL ran out before it could be
+// sent.
+var ErrExpired = errors.New("message expired before it could be sent")
+
+// QueueConfig controls the outbound queue. Messages sent while no peer is
+// connected, or cut off by a disconnect, are held for up to TTL and sent
+// when the link comes back. When Path is set the queue is also saved there,
+// so it survives a restart. A TTL of zero disables queueing. File and
+// stream data are never queued. A message sent with its own TTL (see
+// Transport.SendTTL) is held for that long instead.
+type QueueConfig struct {
+	TTL  time.Duration
+	Path string
+}
+
+var defaultQueue = QueueConfig{TTL: 5 * time.Minute}
+
+// outQueue holds deliveries waiting for a connection, oldest first.
+type outQueue struct {
+	t *Transport
+
+	mu    sync.Mutex
+	cfg   QueueConfig
+	items []*Delivery
+
+	// handoff holds deliveries on their way back to the send lanes, in
+	// order, and draining is whether a goroutine is emptying it. Sends made
+	// meanwhile join the end, so they cannot overtake what was queued
+	// first.
+	handoff  []*Delivery
+	draining bool
+}
+
+// queuedMessage is a delivery as saved to QueueConfig.Path.
+type queuedMessage struct {
+	To      string      `json:"to,omitempty"`
+	Kind    MessageKind `json:"kind"`
+	Data    []byte      `json:"data"`
+	Expires time.Time   `json:"expires"`
+}
+
+func newOutQueue(t *Transport) *outQueue {
+	return &outQueue{t: t, cfg: defaultQueue}
+}
+
+// SetQueue replaces the outbound queue settings. With a Path, messages saved
+// there by an earlier run are loaded and sent once a peer connects.
+func (t *Transport) SetQueue(cfg QueueConfig) error {
+	q := t.queue
+	q.mu.Lock()
+	q.cfg = cfg
+	q.mu.Unlock()
+
+	if cfg.Path == "" {
+		return nil
+	}
+	saved, err := os.ReadFile(cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("load outbound queue: %w", err)
+	}
+	var msgs []queuedMessage
+	if err := json.Unmarshal(saved, &msgs); err != nil {
+		return fmt.Errorf("load outbound queue: %w", err)
+	}
+	for _, m := range msgs {
+		d := newDelivery(m.Kind, m.Data)
+		d.to = m.To
+		d.expires = m.Expires
+		if !q.park(d) {
+			d.finish(ErrExpired)
+		}
+	}
+	return nil
+}
+
+// Queueing reports whether messages for a peer that is not connected are
+// held in the outbound queue rather than failed.
+func (t *Transport) Queueing() bool {
+	return t.queue.enabled()
+}
+
+func (q *outQueue) enabled() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.cfg.TTL > 0
+}
+
+// parkable reports whether d may wait in the queue at all. An identity
+// message is signed for the session it was meant for.
+func parkable(d *Delivery) bool {
+	return !d.kind.bulk() && d.kind != KindIdentity
+}
+
+// park queues d for the next connection and reports whether it did. The
+// caller still owns d when it returns false.
+func (q *outQueue) park(d *Delivery) bool {
+	q.mu.Lock()
+	cfg := q.cfg
+	if cfg.TTL <= 0 || !parkable(d) || len(q.items) >= maxQueued {
+		q.mu.Unlock()
+		return false
+	}
+	if d.expires.IsZero() {
+		d.expires = time.Now().Add(cfg.TTL)
+	}
+	ttl := time.Until(d.expires)
+	if ttl <= 0 {
+		q.mu.Unlock()
+		return false
+	}
+	q.items = append(q.items, d)
+	q.saveLocked()
+	q.mu.Unlock()
+
+	go q.watch(d, ttl)
+
+	// The peer may have come back while d was failing.
+	if q.t.route(d.to) != nil {
+		q.flush(d.to)
+	}
+	return true
+}
+
+// watch fails d if it is still queued when it expires or is canceled.
+func (q *outQueue) watch(d *Delivery, ttl time.Duration) {
+	timer := time.NewTimer(ttl)
+	defer timer.Stop()
+
+	err := ErrExpired
+	select {
+	case <-timer.C:
+	case <-d.cancel:
+		err = d.canceled()
+	case <-d.done:
+		return
+	}
+	if q.remove(d) {
+		d.finish(err)
+	}
+}
+
+// remove takes d out of the queue, reporting whether it was still there.
+func (q *outQueue) remove(d *Delivery) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	i := slices.Index(q.items, d)
+	if i < 0 {
+		return false
+	}
+	q.items = slices.Delete(q.items, i, i+1)
+	q.saveLocked()
+	return true
+}
+
+// hold keeps d behind the messages queued before it and reports whether it
+// did. While a flush is handing messages back to the send lanes d joins the
+// end of the handoff, and while messages for its peer are still parked d is
+// parked after them. The caller still owns d when it returns false.
+func (q *outQueue) hold(d *Delivery) bool {
+	if !parkable(d) {
+		return false
+	}
+	q.mu.Lock()
+	if q.draining {
+		q.handoff = append(q.handoff, d)
+		q.mu.Unlock()
+		return true
+	}
+	waiting := slices.ContainsFunc(q.items, func(p *Delivery) bool { return p.to == d.to })
+	q.mu.Unlock()
+	return waiting && q.park(d)
+}
+
+// flush hands the messages queued for peer id, or for whichever peer is
+// active, back to the send lanes in the order they were queued, ahead of
+// anything sent after.
+func (q *outQueue) flush(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.handoff)
+	q.items = slices.DeleteFunc(q.items, func(d *Delivery) bool {
+		if d.to == "" || d.to == id {
+			q.handoff = append(q.handoff, d)
+			return true
+		}
+		return false
+	})
+	if len(q.handoff) == n {
+		return
+	}
+	q.saveLocked()
+	if !q.draining {
+		q.draining = true
+		go q.drain()
+	}
+}
+
+// drain enqueues the handoff one delivery at a time until it is empty. It
+// runs on its own goroutine, as a full lane blocks it.
+func (q *outQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.handoff) == 0 {
+			q.handoff = nil
+			q.draining = false
+			q.mu.Unlock()
+			return
+		}
+		d := q.handoff[0]
+		q.handoff = q.handoff[1:]
+		q.mu.Unlock()
+		q.t.enqueue(d)
+	}
+}
+
+// saveLocked writes the queue to cfg.Path, if set. Callers hold mu.
+func (q *outQueue) saveLocked() {
+	if q.cfg.Path == "" {
+		return
+	}
+	msgs := make([]queuedMessage, 0, len(q.items))
+	for _, d := range q.items {
+		msgs = append(msgs, queuedMessage{To: d.to, Kind: d.kind, Data: d.data, Expires: d.expires})
+	}
+	data, err := json.Marshal(msgs)
+	if err == nil {
+		err = writeFileAtomic(q.cfg.Path, data)
+	}
+	if err != nil {
+		q.t.publishStatus(fmt.Sprintf("Could not save outbound queue: %v", err))
+	}
+}
+
+// writeFileAtomic replaces path with data, so a crash leaves either the old
+// file or the new one.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
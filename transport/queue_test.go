@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// queued returns how many messages wait in tr's outbound queue.
+func queued(tr *Transport) int {
+	tr.queue.mu.Lock()
+	defer tr.queue.mu.Unlock()
+	return len(tr.queue.items)
+}
+
+func TestQueueFlushKeepsOrder(t *testing.T) {
+	const parked, sent = 8, 8
+	tests := []struct {
+		name string
+		send func(tr *Transport, data []byte)
+	}{
+		{"SendTo", func(tr *Transport, data []byte) { tr.SendTo("b", KindChat, data) }},
+		{"SendTTL", func(tr *Transport, data []byte) { tr.SendTTL("", KindChat, data, time.Minute) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPair(t, fastConfig(4), faults{}, faults{}, nil)
+			p.a.Detach("b")
+
+			var want [][]byte
+			for i := range parked {
+				want = append(want, testMessage(i, 20))
+				tt.send(p.a, want[i])
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for queued(p.a) < parked {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d of %d messages parked", queued(p.a), parked)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			// Messages sent as the link comes back go out after the parked
+			// ones.
+			p.a.Attach("b", p.la)
+			for i := parked; i < parked+sent; i++ {
+				want = append(want, testMessage(i, 20))
+				tt.send(p.a, want[i])
+			}
+
+			got := p.waitReceived(t, len(want), 10*time.Second)
+			for i := range want {
+				if !bytes.Equal(got[i], want[i]) {
+					t.Fatalf("message %d arrived out of order", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"maps"
+	"slices"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	chainInfo = "bluetalk chain v1"
+
+	// maxSkip bounds how far ahead of the expected counter a message may be,
+	// so a forged counter cannot make the receiver derive keys without end.
+	maxSkip = 256
+
+	// maxSkippedKeys bounds the message keys kept for messages that were
+	// skipped over and may still arrive out of order.
+	maxSkippedKeys = 32
+)
+
+var errReplay = errors.New("message key already used")
+
+// chainRatchet is one direction of a symmetric key ratchet. Every message
+// gets its own key from the chain, and the chain key is replaced as it
+// advances, so a key captured later cannot decrypt messages already sent.
+type chainRatchet struct {
+	chainKey []byte
+	counter  uint64
+
+	// skipped holds the keys of messages the chain advanced past before they
+	// arrived; each is deleted once used.
+	skipped map[uint64][]byte
+}
+
+func newChainRatchet(root []byte) *chainRatchet {
+	return &chainRatchet{
+		chainKey: root,
+		skipped:  make(map[uint64][]byte),
+	}
+}
+
+// stepChain derives the next chain key and the message key for the current
+// step from chainKey.
+func stepChain(chainKey []byte) (next, msgKey []byte) {
+	out, err := hkdf.Key(sha256.New, chainKey, nil, chainInfo, 2*chacha20poly1305.KeySize)
+	if err != nil {
+		// Only reachable for output lengths HKDF-SHA256 cannot produce.
+		panic(err)
+	}
+	return out[:chacha20poly1305.KeySize], out[chacha20poly1305.KeySize:]
+}
+
+// next returns the counter and key for the next outgoing message and
+// advances the chain.
+func (r *chainRatchet) next() (uint64, []byte) {
+	next, key := stepChain(r.chainKey)
+	clear(r.chainKey)
+	r.chainKey = next
+	ctr := r.counter
+	r.counter++
+	return ctr, key
+}
+
+// keyFor returns the key of incoming message ctr. The chain is only advanced
+// when the caller runs commit, after the message has authenticated, so a
+// forged message cannot move it.
+func (r *chainRatchet) keyFor(ctr uint64) (key []byte, commit func(), err error) {
+	if ctr < r.counter {
+		key, ok := r.skipped[ctr]
+		if !ok {
+			return nil, nil, errReplay
+		}
+		return key, func() {
+			delete(r.skipped, ctr)
+			clear(key)
+		}, nil
+	}
+	if ctr-r.counter > maxSkip {
+		return nil, nil, errDecrypt
+	}
+
+	chainKey := r.chainKey
+	var skipped [][]byte
+	for range ctr - r.counter {
+		var k []byte
+		chainKey, k = stepChain(chainKey)
+		skipped = append(skipped, k)
+	}
+	next, key := stepChain(chainKey)
+
+	return key, func() {
+		for i, k := range skipped {
+			r.skipped[r.counter+uint64(i)] = k
+		}
+		r.evictSkipped()
+		clear(r.chainKey)
+		r.chainKey = next
+		r.counter = ctr + 1
+		clear(key)
+	}, nil
+}
+
+// dropBefore forgets skipped keys for counters below floor, which the replay
+// window would reject anyway.
+func (r *chainRatchet) dropBefore(floor uint64) {
+	for ctr, key := range r.skipped {
+		if ctr < floor {
+			clear(key)
+			delete(r.skipped, ctr)
+		}
+	}
+}
+
+// evictSkipped drops the oldest skipped keys beyond maxSkippedKeys.
+func (r *chainRatchet) evictSkipped() {
+	if len(r.skipped) <= maxSkippedKeys {
+		return
+	}
+	ctrs := slices.Sorted(maps.Keys(r.skipped))
+	for _, ctr := range ctrs[:len(ctrs)-maxSkippedKeys] {
+		clear(r.skipped[ctr])
+		delete(r.skipped, ctr)
+	}
+}
@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"encoding/binary"
+	"slices"
+)
+
+const (
+	receiptRead byte = 0x01
+
+	// maxAwaitingRead bounds how many delivered chat messages are remembered
+	// while waiting for their read receipt.
+	maxAwaitingRead = 256
+)
+
+// EnableReadReceipts turns automatic read receipts on or off.
+func (t *Transport) EnableReadReceipts(on bool) {
+	t.readReceipts.Store(on)
+}
+
+// MarkRead sends the active peer a read receipt for message id. The receipt
+// is queued like any other message and MarkRead does not wait for it to be
+// delivered.
+func (t *Transport) MarkRead(id uint32) {
+	t.Send(KindReceipt, readReceipt(id))
+}
+
+// markRead sends a read receipt to this session's peer.
+func (s *peerSession) markRead(id uint32) {
+	s.t.SendTo(s.id, KindReceipt, readReceipt(id))
+}
+
+func readReceipt(id uint32) []byte {
+	return binary.LittleEndian.AppendUint32([]byte{receiptRead}, id)
+}
+
+func (s *peerSession) awaitRead(d *Delivery) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	s.awaitingRead = append(s.awaitingRead, d)
+	if len(s.awaitingRead) > maxAwaitingRead {
+		s.awaitingRead = s.awaitingRead[1:]
+	}
+}
+
+func (s *peerSession) clearAwaitingRead() {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	s.awaitingRead = nil
+}
+
+func (s *peerSession) forgetRead(d *Delivery) {
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	if i := slices.Index(s.awaitingRead, d); i >= 0 {
+		s.awaitingRead = slices.Delete(s.awaitingRead, i, i+1)
+	}
+}
+
+func (s *peerSession) onReceipt(data []byte) {
+	if len(data) < 1+4 || data[0] != receiptRead {
+		return
+	}
+	id := binary.LittleEndian.Uint32(data[1:])
+
+	s.readMu.Lock()
+	defer s.readMu.Unlock()
+	i := slices.IndexFunc(s.awaitingRead, func(d *Delivery) bool { return d.ID == id })
+	if i < 0 {
+		return
+	}
+	close(s.awaitingRead[i].read)
+	s.awaitingRead = slices.Delete(s.awaitingRead, i, i+1)
+}
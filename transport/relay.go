@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// relayHeaderSize is the envelope of a KindRelay message: a 64-bit mesh
+	// message ID, the hops it may still travel and the kind of the message
+	// it carries.
+	relayHeaderSize = 10
+
+	// relaySeenMax bounds how many mesh message IDs are remembered for
+	// duplicate suppression.
+	relaySeenMax = 512
+)
+
+// ErrNotRelayable is the error of a Broadcast of a kind that only makes
+// sense between two directly connected peers.
+var ErrNotRelayable = errors.New("message kind cannot be relayed")
+
+// relayState is what the transport needs to take part in a mesh: how far
+// messages travel, and which ones it has already seen.
+type relayState struct {
+	hops atomic.Int32
+
+	mu    sync.Mutex
+	seen  map[uint64]struct{}
+	order []uint64
+}
+
+// SetRelay makes the transport a relay: messages other peers Broadcast are
+// passed on to every other connected peer until they have made maxHops hops,
+// so peers out of each other's range can still reach each other through
+// this one. maxHops is also how far this transport's own broadcasts go.
+// Zero, the default, turns relaying off.
+func (t *Transport) SetRelay(maxHops int) {
+	t.relay.hops.Store(int32(min(max(maxHops, 0), 255)))
+}
+
+// Broadcast sends a message to every connected peer, and through relays to
+// peers beyond them. Each peer handles it as a message of the given kind
+// from the neighbour that passed it on, at most once however many paths
+// lead to it. The deliveries complete when the direct neighbours have it.
+func (t *Transport) Broadcast(kind MessageKind, data []byte) []*Delivery {
+	if !kind.relayable() {
+		return []*Delivery{finishedDelivery(fmt.Errorf("%w: %v", ErrNotRelayable, kind))}
+	}
+	id := rand.Uint64()
+	t.relay.firstSight(id)
+	hops := max(byte(t.relay.hops.Load()), 1)
+	return t.flood("", appendRelay(nil, id, hops, kind, data))
+}
+
+// relayable reports whether messages of kind k may travel through the mesh.
+// Streams, files, receipts and control messages belong to one link.
+func (k MessageKind) relayable() bool {
+	return k == KindChat || k == KindPresence
+}
+
+func appendRelay(dst []byte, id uint64, hops byte, kind MessageKind, data []byte) []byte {
+	dst = binary.LittleEndian.AppendUint64(dst, id)
+	dst = append(dst, hops, byte(kind))
+	return append(dst, data...)
+}
+
+// flood sends a relay envelope to every connected peer except the one with
+// id except.
+func (t *Transport) flood(except string, envelope []byte) []*Delivery {
+	t.sessMu.Lock()
+	var ids []string
+	for id := range t.sessions {
+		if id != except {
+			ids = append(ids, id)
+		}
+	}
+	t.sessMu.Unlock()
+
+	deliveries := make([]*Delivery, 0, len(ids))
+	for _, id := range ids {
+		deliveries = append(deliveries, t.SendTo(id, KindRelay, envelope))
+	}
+	return deliveries
+}
+
+// onRelay handles a relayed message: the first copy to arrive is passed on,
+// if it has hops left and relaying is on, and handled here; later copies
+// are dropped.
+func (s *peerSession) onRelay(m Message, seq byte) {
+	if len(m.Data) < relayHeaderSize {
+		s.t.publishStatus(fmt.Sprintf("Dropped message (seq=%d): short relay envelope", seq))
+		return
+	}
+	id := binary.LittleEndian.Uint64(m.Data)
+	hops, kind := m.Data[8], MessageKind(m.Data[9])
+	data := m.Data[relayHeaderSize:]
+	if !kind.relayable() || !s.t.relay.firstSight(id) {
+		return
+	}
+
+	if hops > 1 && s.t.relay.hops.Load() > 0 {
+		s.t.flood(s.id, appendRelay(nil, id, hops-1, kind, data))
+	}
+	m.Kind, m.Data = kind, data
+	s.dispatch(m, seq, false)
+}
+
+// firstSight records mesh message id and reports whether it is new.
+func (r *relayState) firstSight(id uint64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.seen[id]; ok {
+		return false
+	}
+	if r.seen == nil {
+		r.seen = make(map[uint64]struct{})
+	}
+	r.seen[id] = struct{}{}
+	r.order = append(r.order, id)
+	if len(r.order) > relaySeenMax {
+		delete(r.seen, r.order[0])
+		r.order = r.order[1:]
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package transport
+
+import "errors"
+
+// replayWindowSize is how far behind the newest message an out-of-order
+// message may arrive and still be accepted.
+const replayWindowSize = 64
+
+var errReplayWindow = errors.New("message outside replay window")
+
+// replayWindow is a sliding anti-replay window over the message counters of
+// one direction, as in IPsec: it accepts each counter at most once, and only
+// counters no more than replayWindowSize behind the highest seen.
+type replayWindow struct {
+	started bool
+	highest uint64
+
+	// seen has bit i set when counter highest-i has been accepted.
+	seen uint64
+}
+
+// check reports whether ctr may be accepted, without recording it.
+func (w *replayWindow) check(ctr uint64) error {
+	if !w.started || ctr > w.highest {
+		return nil
+	}
+	diff := w.highest - ctr
+	if diff >= replayWindowSize {
+		return errReplayWindow
+	}
+	if w.seen&(1<<diff) != 0 {
+		return errReplay
+	}
+	return nil
+}
+
+// accept records ctr, sliding the window forward if it is the newest. It
+// must only be called for a counter check allowed and whose message
+// authenticated.
+func (w *replayWindow) accept(ctr uint64) {
+	switch {
+	case !w.started:
+		w.started = true
+		w.highest = ctr
+		w.seen = 1
+	case ctr > w.highest:
+		shift := ctr - w.highest
+		if shift >= replayWindowSize {
+			w.seen = 0
+		} else {
+			w.seen <<= shift
+		}
+		w.seen |= 1
+		w.highest = ctr
+	default:
+		w.seen |= 1 << (w.highest - ctr)
+	}
+}
+
+// floor is the oldest counter the window can still accept.
+func (w *replayWindow) floor() uint64 {
+	if w.highest < replayWindowSize {
+		return 0
+	}
+	return w.highest - replayWindowSize + 1
+}
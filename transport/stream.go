@@ -0,0 +1,244 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	streamData  byte = 0x00
+	streamClose byte = 0x01
+
+	// streamChunk is the most stream data carried by one message. It stays
+	// well under the 255-fragment message limit at the smallest MTU.
+	streamChunk = 1024
+
+	// maxStreamBuffer bounds data received but not yet read. Messages are
+	// acknowledged as they arrive, so a reader that stops reading cannot slow
+	// the sender down; past this limit the stream fails instead.
+	maxStreamBuffer = 1 << 20
+)
+
+var errStreamOverflow = errors.New("stream receive buffer full")
+
+// Stream is a byte stream over the transport. Writes are split into messages
+// and return once the peer has acknowledged them; reads return data in the
+// order it was written. Each side has one stream per peer session: data the
+// peer writes is buffered until NewStream is called.
+type Stream struct {
+	sess *peerSession
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	rerr   error
+	closed bool
+}
+
+var (
+	_ io.ReadWriteCloser = (*Stream)(nil)
+	_ io.ReaderFrom      = (*Stream)(nil)
+)
+
+// NewStream returns the active peer's stream, creating it if needed. With no
+// peer connected, the stream fails every call with ErrNotConnected.
+func (t *Transport) NewStream() *Stream {
+	sess := t.route("")
+	if sess == nil {
+		st := newStream(nil)
+		st.rerr = ErrNotConnected
+		return st
+	}
+	return sess.openStream()
+}
+
+func newStream(sess *peerSession) *Stream {
+	st := &Stream{sess: sess}
+	st.cond = sync.NewCond(&st.mu)
+	return st
+}
+
+func (s *peerSession) openStream() *Stream {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.stream == nil {
+		s.stream = newStream(s)
+	}
+	return s.stream
+}
+
+func (s *peerSession) onStream(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	if data[0] == streamClose {
+		s.closeStream(io.EOF)
+		return
+	}
+	s.openStream().push(data[1:])
+}
+
+// closeStream ends the session's stream with err and detaches it, so the
+// next NewStream starts a fresh one.
+func (s *peerSession) closeStream(err error) {
+	s.streamMu.Lock()
+	st := s.stream
+	s.stream = nil
+	s.streamMu.Unlock()
+
+	if st != nil {
+		st.fail(err)
+	}
+}
+
+func (s *Stream) push(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rerr != nil || s.closed {
+		return
+	}
+	if s.buf.Len()+len(data) > maxStreamBuffer {
+		s.rerr = errStreamOverflow
+		s.cond.Broadcast()
+		return
+	}
+	s.buf.Write(data)
+	s.cond.Broadcast()
+}
+
+func (s *Stream) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rerr == nil {
+		s.rerr = err
+	}
+	s.cond.Broadcast()
+}
+
+// Read reads received data, blocking until some arrives. It returns io.EOF
+// once the peer has closed the stream and everything it sent has been read.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.buf.Len() == 0 && s.rerr == nil && !s.closed {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if s.buf.Len() > 0 {
+		return s.buf.Read(p)
+	}
+	return 0, s.rerr
+}
+
+// Write sends p to the peer, blocking until every chunk is acknowledged.
+func (s *Stream) Write(p []byte) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for n < len(p) {
+		end := min(n+streamChunk, len(p))
+		if err := s.sendChunk(p[n:end]); err != nil {
+			return n, err
+		}
+		n = end
+	}
+	return n, nil
+}
+
+// ReadFrom sends everything read from r until EOF. Only one chunk is held in
+// memory at a time, so io.Copy into a Stream does not buffer the source.
+func (s *Stream) ReadFrom(r io.Reader) (int64, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+
+	var n int64
+	buf := make([]byte, 1+streamChunk)
+	buf[0] = streamData
+	for {
+		m, rerr := io.ReadFull(r, buf[1:])
+		if m > 0 {
+			if err := s.sendMessage(buf[:1+m]); err != nil {
+				return n, err
+			}
+			n += int64(m)
+		}
+		switch {
+		case rerr == io.EOF || rerr == io.ErrUnexpectedEOF:
+			return n, nil
+		case rerr != nil:
+			return n, rerr
+		}
+	}
+}
+
+func (s *Stream) writable() error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return io.ErrClosedPipe
+	}
+	if s.sess == nil {
+		return ErrNotConnected
+	}
+	return nil
+}
+
+func (s *Stream) sendChunk(p []byte) error {
+	msg := make([]byte, 0, 1+len(p))
+	msg = append(msg, streamData)
+	return s.sendMessage(append(msg, p...))
+}
+
+// sendMessage sends one stream message and waits for its acknowledgement,
+// after which msg may be reused.
+func (s *Stream) sendMessage(msg []byte) error {
+	if err := s.sess.t.SendTo(s.sess.id, KindStream, msg).Wait(); err != nil {
+		return fmt.Errorf("stream write: %w", err)
+	}
+	return nil
+}
+
+// Close tells the peer no more data is coming and releases the stream.
+// Pending reads return io.ErrClosedPipe.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	sess := s.sess
+	if sess == nil {
+		return nil
+	}
+	sess.streamMu.Lock()
+	if sess.stream == s {
+		sess.stream = nil
+	}
+	sess.streamMu.Unlock()
+
+	return sess.t.SendTo(sess.id, KindStream, []byte{streamClose}).Wait()
+}
+
+// SendReader sends length bytes read from r over the active peer's stream,
+// fragmenting as it reads rather than loading the payload into memory. It
+// fails with io.ErrUnexpectedEOF if r ends early.
+func (t *Transport) SendReader(r io.Reader, length int64) error {
+	n, err := t.NewStream().ReadFrom(io.LimitReader(r, length))
+	if err == nil && n < length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
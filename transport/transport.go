@@ -0,0 +1,1340 @@
+// Package transport is BlueTalk's reliability layer. It fragments messages to
+// the link MTU, acknowledges and retransmits them, and optionally compresses
+// and encrypts them, over any Link that carries packets between two peers.
+package transport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Packet types. 0x04 marked compressed data before compression moved to
+	// the header flags; it is not reused.
+	packetData      byte = 0x01
+	packetAck       byte = 0x02
+	packetHandshake byte = 0x03
+	packetHello     byte = 0x05
+	packetPing      byte = 0x06
+	packetPong      byte = 0x07
+	packetNack      byte = 0x08
+	packetBatch     byte = 0x09
+
+	// headerSize is the header every packet starts with; see the wire format
+	// in packet.go.
+	headerSize = 6
+
+	// ackSize is a selective ACK: the header, whose index field holds the
+	// cumulative count of fragments received in order, and a 32-bit bitmap
+	// of the fragments received after the first gap.
+	ackSize = headerSize + 4
+
+	// maxWindowSize is the most fragments one message may have
+	// unacknowledged at once: the 32 fragments an ACK bitmap covers.
+	maxWindowSize = 32
+
+	// checksumSize is the CRC-32 (IEEE) trailer appended to messages sent
+	// with frameChecksum before fragmentation and verified after reassembly.
+	checksumSize = 4
+
+	// recentCompletedMax bounds how many delivered messages are remembered
+	// for duplicate suppression. It is far below the 255 messages it takes
+	// for a seq to come round again, so a reused seq is never mistaken for a
+	// retransmission.
+	recentCompletedMax = 32
+
+	// bulkYieldInterval is how often a bulk message that is giving way to
+	// priority traffic checks whether it may send again.
+	bulkYieldInterval = 20 * time.Millisecond
+
+	// statusTimeout bounds how long a status line waits on a full status
+	// channel before it is dropped and counted.
+	statusTimeout = 200 * time.Millisecond
+
+	// minMTU is the packet size every link is assumed to carry, the 20
+	// bytes of a default BLE ATT MTU, and maxPacketSize the largest packet
+	// the transport sends or accepts, the most an ATT attribute holds.
+	minMTU        = 20
+	maxPacketSize = 512
+
+	// defaultPacing is the gap left between link writes by default.
+	defaultPacing = 5 * time.Millisecond
+)
+
+// Errors a send can fail with, besides ErrCanceled and ErrExpired. They are
+// wrapped with details, so test for them with errors.Is.
+var (
+	// ErrNotConnected means no link is attached for the peer.
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrTimeout means the peer stopped acknowledging: a fragment went
+	// unacknowledged through every retry, or a handshake did not finish.
+	ErrTimeout = errors.New("timed out waiting for the peer")
+
+	// ErrDisconnected means the link dropped while the message was in
+	// flight.
+	ErrDisconnected = errors.New("disconnected during delivery")
+
+	// ErrTooLarge means the message needs more fragments than a message
+	// can have at the current MTU.
+	ErrTooLarge = errors.New("message too large")
+)
+
+// TransportConfig holds the transport's timing and retry tuning. Zero fields
+// take their value from DefaultTransportConfig.
+type TransportConfig struct {
+	// AckTimeout is how long a fragment waits for its ACK before it is
+	// retransmitted until round trips have been measured; after that the
+	// timeout follows the link. WriteRetryDelay is the wait after a failed
+	// write.
+	AckTimeout      time.Duration
+	WriteRetryDelay time.Duration
+
+	// MaxRetries is how many times a fragment is sent before the message
+	// fails.
+	MaxRetries int
+
+	// WindowSize caps how many fragments of one message may be
+	// unacknowledged at once, at most 32. The congestion window grows
+	// towards it while the link keeps up.
+	WindowSize int
+
+	// Pacing is the smallest gap left between consecutive writes to the link;
+	// it widens when round trips grow.
+	Pacing time.Duration
+
+	// ReassemblyTimeout is how long a partly received message is kept before
+	// it is abandoned and reported through OnProgress.
+	ReassemblyTimeout time.Duration
+
+	// HandshakeTimeout bounds how long a send waits for key agreement.
+	HandshakeTimeout time.Duration
+
+	// RecvTimeout bounds how long a received chat message waits on a full
+	// receive channel before it is dropped. Delivery happens before the
+	// message is acknowledged, so a slow consumer holds the sender back for
+	// up to this long.
+	RecvTimeout time.Duration
+}
+
+// DefaultTransportConfig returns the settings used unless overridden.
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		AckTimeout:        900 * time.Millisecond,
+		WriteRetryDelay:   250 * time.Millisecond,
+		MaxRetries:        5,
+		WindowSize:        8,
+		Pacing:            defaultPacing,
+		ReassemblyTimeout: 2 * time.Minute,
+		HandshakeTimeout:  10 * time.Second,
+		RecvTimeout:       2 * time.Second,
+	}
+}
+
+// withDefaults fills zero fields from DefaultTransportConfig and clamps the
+// window to what an ACK can describe.
+func (c TransportConfig) withDefaults() TransportConfig {
+	d := DefaultTransportConfig()
+	if c.AckTimeout <= 0 {
+		c.AckTimeout = d.AckTimeout
+	}
+	if c.WriteRetryDelay <= 0 {
+		c.WriteRetryDelay = d.WriteRetryDelay
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = d.MaxRetries
+	}
+	if c.WindowSize <= 0 {
+		c.WindowSize = d.WindowSize
+	}
+	c.WindowSize = min(c.WindowSize, maxWindowSize)
+	if c.Pacing < 0 {
+		c.Pacing = 0
+	}
+	if c.ReassemblyTimeout <= 0 {
+		c.ReassemblyTimeout = d.ReassemblyTimeout
+	}
+	if c.HandshakeTimeout <= 0 {
+		c.HandshakeTimeout = d.HandshakeTimeout
+	}
+	if c.RecvTimeout <= 0 {
+		c.RecvTimeout = d.RecvTimeout
+	}
+	return c
+}
+
+// MessageKind tags what a message carries. It is the first byte of every
+// message body, so features other than chat get their own channel instead of
+// encoding themselves into chat text.
+type MessageKind byte
+
+const (
+	KindChat MessageKind = iota + 1
+	KindControl
+	KindPresence
+	KindFileChunk
+	KindReceipt
+	KindStream
+	KindRelay
+	KindIdentity
+)
+
+// bulk reports whether messages of kind k are bulk data, sent at low
+// priority.
+func (k MessageKind) bulk() bool {
+	return k == KindFileChunk || k == KindStream
+}
+
+func (k MessageKind) String() string {
+	switch k {
+	case KindChat:
+		return "chat"
+	case KindControl:
+		return "control"
+	case KindPresence:
+		return "presence"
+	case KindFileChunk:
+		return "file-chunk"
+	case KindReceipt:
+		return "receipt"
+	case KindStream:
+		return "stream"
+	case KindRelay:
+		return "relay"
+	case KindIdentity:
+		return "identity"
+	default:
+		return fmt.Sprintf("kind(%d)", byte(k))
+	}
+}
+
+// DropStats counts items discarded because their consumer fell behind.
+type DropStats struct {
+	Messages uint64
+	Status   uint64
+}
+
+// Message is a received message. From identifies the link it came from and
+// Identity the fingerprint of the peer on it, empty until that peer has
+// proved its identity. ID is the sender's delivery ID, which read receipts
+// refer back to.
+type Message struct {
+	From     string
+	Identity string
+	ID       uint32
+	Kind     MessageKind
+	Data     []byte
+}
+
+// msgHeaderSize is the kind byte and 32-bit message ID that start every
+// message body.
+const msgHeaderSize = 5
+
+// ackInfo is a decoded selective ACK. Fragments [0, cum) have arrived, and
+// bit i of bitmap means fragment cum+1+i has arrived too.
+type ackInfo struct {
+	cum    uint8
+	bitmap uint32
+
+	// missing lists fragments a NACK asks to have resent at once.
+	missing []uint8
+}
+
+// gaps returns the fragments the receiver is missing below the highest one
+// it has, which is what a NACK reports.
+func (a ackInfo) gaps() []uint8 {
+	if a.bitmap == 0 {
+		return nil
+	}
+	missing := []uint8{a.cum}
+	for bit := range 32 {
+		if a.bitmap>>bit == 0 {
+			break
+		}
+		if a.bitmap&(1<<bit) == 0 {
+			missing = append(missing, a.cum+1+uint8(bit))
+		}
+	}
+	return missing
+}
+
+// txFragment is the sender's view of one fragment in flight.
+type txFragment struct {
+	packet   []byte
+	acked    bool
+	sent     bool
+	tries    int
+	sentAt   time.Time
+	deadline time.Time
+}
+
+// completedKey identifies a delivered message for duplicate suppression.
+type completedKey struct {
+	seq   uint8
+	total uint8
+}
+
+type rxMessage struct {
+	ptype     byte
+	flags     byte
+	total     uint8
+	fragments []*[]byte // pooled; see release
+	received  int
+	createdAt time.Time
+}
+
+// Transport carries messages over links to one or more peers. State that
+// belongs to one connected peer lives in a peerSession; Transport holds the
+// settings and channels shared by all of them.
+type Transport struct {
+	cfg atomic.Pointer[TransportConfig]
+
+	recvCh   chan string
+	statusCh chan string
+
+	// encrypt requests end-to-end encryption and compress enables deflate
+	// for outgoing messages, on every session.
+	encrypt  atomic.Bool
+	compress atomic.Bool
+
+	onMessage      atomic.Pointer[func(Message)]
+	onProgress     atomic.Pointer[func(ReceiveProgress)]
+	onQuality      atomic.Pointer[func(LinkQuality)]
+	onDrop         atomic.Pointer[func(id, reason string)]
+	onIncompatible atomic.Pointer[func(id, reason string)]
+	onIdentity     atomic.Pointer[func(id string, peer Identity)]
+	onStatus       atomic.Pointer[func(msg string)]
+	onTyping       atomic.Pointer[func(id string, typing bool)]
+	onPresence     atomic.Pointer[func(id string, p Presence)]
+	onBulkReady    atomic.Pointer[func(id string)]
+
+	// bulkLinks is set while the owner can open side links for bulk data.
+	bulkLinks atomic.Bool
+
+	// presence is what we announce to peers about our user; nil announces
+	// nothing.
+	presence atomic.Pointer[Presence]
+
+	// identity is who this transport tells peers it is; nil sends nothing.
+	identity atomic.Pointer[localIdentity]
+
+	droppedMessages atomic.Uint64
+	droppedStatus   atomic.Uint64
+
+	keepMu    sync.Mutex
+	keepalive KeepaliveConfig
+
+	// lanes queue outgoing messages by kind. Each lane sends one message at
+	// a time, in order, while different kinds are in flight together.
+	lanesMu sync.Mutex
+	lanes   map[MessageKind]chan *Delivery
+
+	// readReceipts sends a read receipt for every chat message handed to
+	// recvCh.
+	readReceipts atomic.Bool
+
+	files *fileTransfers
+	queue *outQueue
+	relay relayState
+
+	// sessions holds the state of each connected peer by identity; active
+	// is the peer messages go to when no destination is given.
+	sessMu   sync.Mutex
+	sessions map[string]*peerSession
+	active   string
+}
+
+// peerSession is the transport state of one connected peer: its sequence
+// numbers, in-flight sends, reassembly buffers and negotiated features.
+type peerSession struct {
+	t  *Transport
+	id string
+
+	nextSeq atomic.Uint32
+
+	// urgent counts priority messages in flight; bulk messages hold back new
+	// fragments while it is non-zero.
+	urgent atomic.Int32
+
+	// link carries the session's packets; nil once detached. mtu is the
+	// largest packet it carries; fragments are sized to it.
+	link atomic.Pointer[Link]
+	mtu  atomic.Int32
+
+	// bulk is the side link bulk messages go over; nil if there is none.
+	bulk atomic.Pointer[Link]
+
+	// adoptMTU is set when the link cannot report its MTU and takes the
+	// one the peer announces.
+	adoptMTU atomic.Bool
+
+	// wireMismatch is set once the peer has been refused for speaking
+	// another wire format version.
+	wireMismatch atomic.Bool
+
+	cc    *congestion
+	stats linkStats
+
+	// out queues packets for the link; see coalesce.go.
+	out sendQueue
+
+	// turns makes the messages in flight take fragment writes in turn.
+	turns fairQueue
+
+	ackMu       sync.Mutex
+	pendingAcks map[uint8]chan ackInfo
+
+	// acks holds outgoing ACKs waiting to ride on a data fragment.
+	acks ackQueue
+
+	rxMu       sync.Mutex
+	reassembly map[uint8]*rxMessage
+
+	// completed and completedOrder remember recently delivered messages, so
+	// retransmitted fragments whose ACK was lost are re-ACKed but not
+	// delivered twice.
+	completed      map[completedKey]time.Time
+	completedOrder []completedKey
+
+	// rejected remembers recently dropped messages that failed their
+	// checksum, so fragments of them resent because the NACK asking for the
+	// whole message was lost bring that NACK again.
+	rejected map[completedKey]time.Time
+
+	// crypto holds the key agreement state. peerVersion, peerCaps and
+	// peerMTU are what the peer announced in its HELLO; zero until it
+	// arrives.
+	crypto      atomic.Pointer[cryptoSession]
+	peerVersion atomic.Uint32
+	peerCaps    atomic.Uint32
+	peerMTU     atomic.Int32
+
+	// peerIdentity is the identity the peer proved; nil until it has.
+	// identityUnbound is set while our own identity went out without the
+	// session keys to bind it to.
+	peerIdentity    atomic.Pointer[Identity]
+	identityUnbound atomic.Bool
+
+	streamMu sync.Mutex
+	stream   *Stream
+
+	// lastHeard is when any packet last arrived, in Unix nanoseconds; the
+	// keepalive loop declares the peer gone when it grows too old.
+	lastHeard atomic.Int64
+	keepMu    sync.Mutex
+	keepStop  chan struct{}
+
+	presenceMu   sync.Mutex
+	presenceStop chan struct{}
+
+	// awaitingRead holds delivered chat messages whose read receipt has not
+	// arrived, oldest first.
+	readMu       sync.Mutex
+	awaitingRead []*Delivery
+
+	// typingSent is when we last told the peer we are typing, in Unix
+	// nanoseconds, or zero if we have not since stopping. typingTimer runs
+	// while the peer is typing, to end it if the peer goes quiet.
+	typingSent  atomic.Int64
+	typingMu    sync.Mutex
+	typingTimer *time.Timer
+}
+
+// NewTransport returns a transport that hands received chat text to recvCh
+// and status lines to statusCh. Either may be nil, for owners that take
+// messages from OnMessage and status from OnStatus. Peers are added with
+// Attach.
+func NewTransport(recvCh, statusCh chan string, cfg TransportConfig) *Transport {
+	t := &Transport{
+		recvCh:    recvCh,
+		statusCh:  statusCh,
+		keepalive: defaultKeepalive,
+		lanes:     make(map[MessageKind]chan *Delivery),
+		sessions:  make(map[string]*peerSession),
+	}
+	t.files = newFileTransfers(t)
+	t.queue = newOutQueue(t)
+	t.SetConfig(cfg)
+	return t
+}
+
+func newPeerSession(t *Transport, id string) *peerSession {
+	s := &peerSession{
+		t:           t,
+		id:          id,
+		pendingAcks: make(map[uint8]chan ackInfo),
+		reassembly:  make(map[uint8]*rxMessage),
+		completed:   make(map[completedKey]time.Time),
+		rejected:    make(map[completedKey]time.Time),
+		cc:          newCongestion(),
+	}
+	s.mtu.Store(minMTU)
+	return s
+}
+
+// SetConfig replaces the transport's tuning. Messages already being sent
+// keep the settings they started with.
+func (t *Transport) SetConfig(cfg TransportConfig) {
+	cfg = cfg.withDefaults()
+	t.cfg.Store(&cfg)
+}
+
+func (t *Transport) config() TransportConfig {
+	return *t.cfg.Load()
+}
+
+// sessionFor returns the session for peer id, creating it if needed.
+func (t *Transport) sessionFor(id string) *peerSession {
+	t.sessMu.Lock()
+	defer t.sessMu.Unlock()
+	s, ok := t.sessions[id]
+	if !ok {
+		s = newPeerSession(t, id)
+		t.sessions[id] = s
+	}
+	return s
+}
+
+// route returns the session for peer id, or the active session when id is
+// empty. It returns nil if that peer is not connected.
+func (t *Transport) route(id string) *peerSession {
+	t.sessMu.Lock()
+	defer t.sessMu.Unlock()
+	if id == "" {
+		id = t.active
+	}
+	return t.sessions[id]
+}
+
+// Attach starts a session for peer id over link and makes it the active one.
+// Packets the link delivers are handled from then until Detach.
+func (t *Transport) Attach(id string, link Link) {
+	s := t.sessionFor(id)
+	t.sessMu.Lock()
+	t.active = id
+	t.sessMu.Unlock()
+
+	s.startWriter()
+	s.link.Store(&link)
+	s.setMTU(link.MTU())
+	s.adoptMTU.Store(link.MTU() == 0)
+	s.reset()
+	link.OnPacket(s.receive)
+	s.startCrypto()
+	s.startKeepalive()
+	s.startPresence()
+	go s.sendHello()
+	go s.sendIdentity()
+	t.queue.flush(id)
+}
+
+// Detach ends the session of peer id, failing its in-flight sends. The link
+// stops being used; closing it is up to the caller.
+func (t *Transport) Detach(id string) {
+	t.sessMu.Lock()
+	s := t.sessions[id]
+	delete(t.sessions, id)
+	if t.active == id {
+		t.active = ""
+	}
+	t.sessMu.Unlock()
+
+	if s != nil {
+		s.close()
+	}
+}
+
+// OnDrop registers fn to be called when the transport gives up on peer id's
+// link: the peer went silent past the keepalive timeout or, unless
+// OnIncompatible is set, speaks an incompatible protocol. fn should close
+// the link and call Detach.
+func (t *Transport) OnDrop(fn func(id, reason string)) {
+	if fn == nil {
+		t.onDrop.Store(nil)
+		return
+	}
+	t.onDrop.Store(&fn)
+}
+
+func (s *peerSession) close() {
+	if link := s.link.Swap(nil); link != nil {
+		(*link).OnPacket(nil)
+	}
+	s.detachBulk(s.bulk.Load())
+	s.stopWriter()
+	s.stopKeepalive()
+	s.stopPresence()
+	s.reset()
+	s.crypto.Store(nil)
+	s.peerVersion.Store(0)
+	s.peerCaps.Store(0)
+	s.peerMTU.Store(0)
+	s.peerIdentity.Store(nil)
+	s.identityUnbound.Store(false)
+	s.closeStream(io.ErrUnexpectedEOF)
+	s.clearAwaitingRead()
+	s.typingSent.Store(0)
+	s.setPeerTyping(false)
+}
+
+// write queues a raw packet for this session's peer. It fails only if the
+// peer is not connected or its link is not keeping up; the packet is copied,
+// so the caller may reuse it at once.
+func (s *peerSession) write(packet []byte) error {
+	if s.link.Load() == nil {
+		return ErrNotConnected
+	}
+	return s.out.push(packet)
+}
+
+// drop asks the owner of the session's link to disconnect it.
+func (s *peerSession) drop(reason string) {
+	if fn := s.t.onDrop.Load(); fn != nil {
+		go (*fn)(s.id, reason)
+	}
+}
+
+// SetMTU changes the largest packet peer id's link carries, or the active
+// peer's when id is empty, for links whose MTU is only settled after Attach.
+// The peer is sent a new HELLO so it can use the new size too.
+func (t *Transport) SetMTU(id string, mtu int) {
+	if s := t.route(id); s != nil && s.setMTU(mtu) {
+		go s.sendHello()
+	}
+}
+
+// setMTU clamps mtu to what the transport supports and stores it, reporting
+// whether it changed.
+func (s *peerSession) setMTU(mtu int) bool {
+	m := int32(min(max(mtu, minMTU), maxPacketSize))
+	return s.mtu.Swap(m) != m
+}
+
+// payloadSize is the fragment payload that fits both our link MTU and the
+// MTU the peer announced.
+func (s *peerSession) payloadSize() int {
+	mtu := s.mtu.Load()
+	if peer := s.peerMTU.Load(); peer > 0 {
+		mtu = min(mtu, peer)
+	}
+	return int(mtu) - headerSize
+}
+
+func (s *peerSession) reset() {
+	s.ackMu.Lock()
+	for key, ch := range s.pendingAcks {
+		delete(s.pendingAcks, key)
+		close(ch)
+	}
+	s.ackMu.Unlock()
+
+	s.rxMu.Lock()
+	for _, msg := range s.reassembly {
+		msg.release()
+	}
+	clear(s.reassembly)
+	clear(s.completed)
+	s.completedOrder = s.completedOrder[:0]
+	clear(s.rejected)
+	s.rxMu.Unlock()
+
+	s.acks.mu.Lock()
+	s.acks.pending = nil
+	s.acks.mu.Unlock()
+
+	s.cc.reset()
+	s.stats.reset()
+}
+
+// SendMessage queues text for the peer as a chat message.
+func (t *Transport) SendMessage(text string) *Delivery {
+	if len(text) == 0 {
+		return finishedDelivery(nil)
+	}
+	return t.Send(KindChat, []byte(text))
+}
+
+// Send queues a message of the given kind for the active peer and returns a
+// handle that completes when the peer has acknowledged all of it. Messages
+// of the same kind are sent one after another in the order they are queued;
+// messages of different kinds are in flight together, taking turns on the
+// link, and bulk kinds give way to everything else.
+func (t *Transport) Send(kind MessageKind, data []byte) *Delivery {
+	return t.SendTo("", kind, data)
+}
+
+// SendTo is Send addressed to peer id; an empty id means the active peer.
+func (t *Transport) SendTo(id string, kind MessageKind, data []byte) *Delivery {
+	d := newDelivery(kind, data)
+	d.to = id
+	t.submit(d)
+	return d
+}
+
+// submit hands a new delivery to its send lane, or holds it back behind the
+// messages queued for its peer before it.
+func (t *Transport) submit(d *Delivery) {
+	if !t.queue.hold(d) {
+		t.enqueue(d)
+	}
+}
+
+// enqueue hands d to the send lane for its kind, starting the lane on first
+// use. Chat ends our typing state, as it does on the peer's side.
+func (t *Transport) enqueue(d *Delivery) {
+	if d.kind == KindChat {
+		if s := t.route(d.to); s != nil {
+			s.typingSent.Store(0)
+		}
+	}
+	t.lanesMu.Lock()
+	lane, ok := t.lanes[d.kind]
+	if !ok {
+		lane = make(chan *Delivery, outboxSize)
+		t.lanes[d.kind] = lane
+		go t.sendLoop(lane)
+	}
+	t.lanesMu.Unlock()
+	lane <- d
+}
+
+// sendNow compresses, encrypts and sends one message, returning once it is
+// acknowledged.
+func (s *peerSession) sendNow(d *Delivery) error {
+	msg := make([]byte, 0, msgHeaderSize+len(d.data))
+	msg = append(msg, byte(d.kind))
+	msg = binary.LittleEndian.AppendUint32(msg, d.ID)
+	msg = append(msg, d.data...)
+
+	flags, body := s.compressOutgoing(msg)
+	body, sealed, err := s.sealOutgoing(body, d.cancel)
+	if err != nil {
+		return err
+	}
+	// A sealed message is already authenticated, so the CRC would add
+	// nothing.
+	if sealed {
+		flags |= frameEncrypted
+	} else {
+		flags |= frameChecksum
+	}
+	return s.sendFrame(packetData, flags, body, d.kind.bulk(), d.cancel)
+}
+
+// sendFrame fragments a message of type ptype with header flags and sends it,
+// checksummed if flags asks for it, with a sliding
+// window: up to the congestion window of fragments are in flight at once,
+// selective ACKs retire them, and only fragments whose ACK deadline passes
+// are retransmitted. Closing cancel abandons the message; a nil cancel never
+// does.
+func (s *peerSession) sendFrame(ptype, flags byte, body []byte, bulk bool, cancel <-chan struct{}) error {
+	data := body
+	if flags&frameChecksum != 0 {
+		data = appendChecksum(body)
+	}
+
+	payloadSize := s.payloadSize()
+	total := (len(data) + payloadSize - 1) / payloadSize
+	if total > 255 {
+		return fmt.Errorf("%w: max %d bytes", ErrTooLarge, 255*payloadSize-checksumSize)
+	}
+
+	seq := s.newSeq()
+
+	// Every fragment's packet is a slice of one buffer, written in place.
+	frags := make([]txFragment, total)
+	packets := make([]byte, 0, total*headerSize+len(data))
+	for i := range total {
+		start := i * payloadSize
+		end := start + payloadSize
+		end = min(end, len(data))
+
+		n := len(packets)
+		packets = appendHeader(packets, ptype, flags, seq, uint8(total), uint8(i))
+		packets = append(packets, data[start:end]...)
+		frags[i].packet = packets[n:len(packets):len(packets)]
+	}
+
+	if !bulk {
+		s.urgent.Add(1)
+		defer s.urgent.Add(-1)
+	}
+	return s.sendWindow(seq, frags, bulk, cancel)
+}
+
+// newSeq returns the sequence number for the next message sent.
+func (s *peerSession) newSeq() uint8 {
+	seq := uint8(s.nextSeq.Add(1) % 256)
+	if seq == 0 {
+		seq = 1
+	}
+	return seq
+}
+
+// renumber readies frags to be sent again from scratch as message seq.
+func renumber(frags []txFragment, seq uint8) {
+	for i := range frags {
+		frags[i].packet[offSeq] = seq
+		frags[i] = txFragment{packet: frags[i].packet}
+	}
+}
+
+// sendWindow runs the sliding window for one message. A bulk message sends
+// no new fragments while a priority message is in flight, only retransmits,
+// so chat is not stuck behind a file transfer.
+//
+// When the peer drops the message for failing its checksum, the message
+// starts over under a new sequence number. ACKs and NACKs still on their way for the
+// old one are then ignored, so none of them can retire a fragment the peer
+// no longer has.
+func (s *peerSession) sendWindow(seq uint8, frags []txFragment, bulk bool, cancel <-chan struct{}) error {
+	cfg := s.t.config()
+	acks := s.registerAck(seq)
+	defer func() { s.unregisterAck(seq) }()
+	timer := time.NewTimer(s.cc.rto(cfg))
+	defer timer.Stop()
+
+	base, next, remaining := 0, 0, len(frags)
+	attempts := 1
+	for remaining > 0 {
+		yield := bulk && s.urgent.Load() > 0
+		for !yield && next < len(frags) && next < base+s.cc.window(cfg) {
+			s.transmit(&frags[next], bulk, cfg)
+			next++
+		}
+
+		now := time.Now()
+		var wake time.Time
+		for i := base; i < next; i++ {
+			f := &frags[i]
+			if f.acked {
+				continue
+			}
+			if !now.Before(f.deadline) {
+				if f.tries >= cfg.MaxRetries {
+					return fmt.Errorf("delivery (seq=%d, frag=%d): %w", seq, i, ErrTimeout)
+				}
+				s.cc.onLoss()
+				s.transmit(f, bulk, cfg)
+			}
+			if wake.IsZero() || f.deadline.Before(wake) {
+				wake = f.deadline
+			}
+		}
+		if retry := now.Add(bulkYieldInterval); yield && (wake.IsZero() || retry.Before(wake)) {
+			wake = retry
+		}
+
+		timer.Reset(time.Until(wake))
+		select {
+		case ack, ok := <-acks:
+			if !ok {
+				return fmt.Errorf("%w (seq=%d)", ErrDisconnected, seq)
+			}
+			n, rtt := applyAck(frags, ack)
+			remaining -= n
+			s.cc.onAck(n, rtt, cfg)
+			restart, err := s.fastRetransmit(seq, frags, ack.missing, bulk, cfg)
+			if err != nil {
+				return err
+			}
+			if restart {
+				if attempts >= cfg.MaxRetries {
+					return fmt.Errorf("delivery (seq=%d): %w", seq, ErrTimeout)
+				}
+				attempts++
+				s.cc.onLoss()
+				s.unregisterAck(seq)
+				seq = s.newSeq()
+				acks = s.registerAck(seq)
+				renumber(frags, seq)
+				base, next, remaining = 0, 0, len(frags)
+				continue
+			}
+		case <-timer.C:
+		case <-cancel:
+			return ErrCanceled
+		}
+
+		for base < len(frags) && frags[base].acked {
+			base++
+		}
+	}
+
+	return nil
+}
+
+// transmit writes one fragment, over the side link if it is bulk data, and
+// arms its retransmission deadline, which doubles with each retry. A failed
+// write is retried sooner than a lost ACK.
+func (s *peerSession) transmit(f *txFragment, bulk bool, cfg TransportConfig) {
+	s.stats.onTransmit(f.tries > 0)
+	f.tries++
+	f.sent = true
+	f.sentAt = time.Now()
+	s.turns.acquire()
+	err := s.writeFragment(bulk, func(dst []byte) []byte { return s.attachAck(dst, f.packet) })
+	s.turns.release()
+	if err != nil {
+		f.deadline = time.Now().Add(cfg.WriteRetryDelay)
+		return
+	}
+	f.deadline = time.Now().Add(min(s.cc.rto(cfg)<<(f.tries-1), maxRTO))
+}
+
+// fastRetransmit resends the fragments a NACK reports missing without waiting
+// for their ACK deadline. A fragment sent within the last quarter
+// retransmission timeout is skipped, so repeated NACKs for the same gap do not
+// trigger a burst, as is one already ACKed, which a NACK overtaken by a
+// later ACK may still list.
+//
+// A NACK for a partly received message only lists fragments below the
+// highest one the peer has, so one that lists the last fragment means the
+// peer dropped the whole message for failing its checksum. fastRetransmit
+// then resends nothing and reports that the message must start over.
+func (s *peerSession) fastRetransmit(seq uint8, frags []txFragment, missing []uint8, bulk bool, cfg TransportConfig) (restart bool, err error) {
+	if slices.Contains(missing, uint8(len(frags)-1)) {
+		return true, nil
+	}
+	guard := s.cc.rto(cfg) / 4
+	for _, idx := range missing {
+		if int(idx) >= len(frags) {
+			continue
+		}
+		f := &frags[idx]
+		if !f.sent || f.acked || time.Since(f.sentAt) < guard {
+			continue
+		}
+		if f.tries >= cfg.MaxRetries {
+			return false, fmt.Errorf("delivery (seq=%d, frag=%d): %w", seq, idx, ErrTimeout)
+		}
+		s.cc.onLoss()
+		s.transmit(f, bulk, cfg)
+	}
+	return false, nil
+}
+
+// applyAck marks the fragments covered by ack and returns how many were newly
+// acknowledged, with the round trip of the latest one that was only sent once
+// (zero if none was, since a retransmitted fragment's ACK is ambiguous).
+func applyAck(frags []txFragment, ack ackInfo) (n int, rtt time.Duration) {
+	var newest time.Time
+	mark := func(i int) {
+		if i < len(frags) && frags[i].sent && !frags[i].acked {
+			frags[i].acked = true
+			n++
+			if frags[i].tries == 1 && frags[i].sentAt.After(newest) {
+				newest = frags[i].sentAt
+			}
+		}
+	}
+	for i := range int(ack.cum) {
+		mark(i)
+	}
+	for bit := range 32 {
+		if ack.bitmap&(1<<bit) != 0 {
+			mark(int(ack.cum) + 1 + bit)
+		}
+	}
+	if !newest.IsZero() {
+		rtt = time.Since(newest)
+	}
+	return n, rtt
+}
+
+// receive handles one packet from the peer. Packets DecodePacket rejects are
+// dropped without touching any state; a peer speaking another wire format
+// version is disconnected.
+func (s *peerSession) receive(data []byte) {
+	p, err := DecodePacket(data)
+	if errors.Is(err, ErrWireVersion) && s.wireMismatch.CompareAndSwap(false, true) {
+		s.refuse(err.Error())
+	}
+	if err != nil {
+		return
+	}
+	s.lastHeard.Store(time.Now().UnixNano())
+
+	if p.Ack != nil {
+		s.signalAck(p.Ack.Seq, ackInfo{cum: p.Ack.Index, bitmap: p.Ack.Bitmap})
+	}
+
+	switch p.Type {
+	case packetPing:
+		_ = s.writeWith(func(dst []byte) []byte { return appendHeader(dst, packetPong, 0, 0, 0, 0) })
+	case packetPong:
+		s.stats.onPong()
+	case packetAck:
+		s.signalAck(p.Seq, ackInfo{cum: p.Index, bitmap: p.Bitmap})
+	case packetNack:
+		s.signalAck(p.Seq, ackInfo{missing: p.Missing})
+	case packetBatch:
+		for _, packet := range p.Batch {
+			s.receive(packet)
+		}
+	default:
+		ack, ok := s.acceptData(p)
+		if !ok {
+			return
+		}
+		if ack.missing != nil {
+			s.forgetAck(p.Seq)
+			s.sendNack(p.Seq, p.Total, ack.missing)
+			return
+		}
+		s.sendAck(p.Seq, p.Total, ack)
+		s.sendNack(p.Seq, p.Total, ack.gaps())
+	}
+}
+
+// sendNack reports fragments missing from a message, usually the gaps in a
+// partly received one, so the sender can resend them immediately instead of
+// waiting out their ACK timeout. A list too long for one packet is split.
+func (s *peerSession) sendNack(seq, total uint8, missing []uint8) {
+	limit := min(s.payloadSize(), 255)
+	for len(missing) > 0 {
+		n := min(len(missing), limit)
+		part := missing[:n]
+		missing = missing[n:]
+		_ = s.writeWith(func(dst []byte) []byte {
+			dst = appendHeader(dst, packetNack, 0, seq, total, uint8(len(part)))
+			return append(dst, part...)
+		})
+	}
+}
+
+func (s *peerSession) registerAck(seq uint8) chan ackInfo {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+
+	ch := make(chan ackInfo, maxWindowSize)
+	s.pendingAcks[seq] = ch
+	return ch
+}
+
+func (s *peerSession) unregisterAck(seq uint8) {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+	delete(s.pendingAcks, seq)
+}
+
+func (s *peerSession) signalAck(seq uint8, ack ackInfo) {
+	s.ackMu.Lock()
+	defer s.ackMu.Unlock()
+	ch, ok := s.pendingAcks[seq]
+	if !ok {
+		return
+	}
+	select {
+	case ch <- ack:
+	default:
+	}
+}
+
+// acceptData stores a fragment and returns the selective ACK describing what
+// has arrived for its message so far. If the fragment completes a message
+// that fails its checksum, or belongs to one that did, it returns instead an
+// ackInfo whose missing lists every fragment, to be sent back as a NACK.
+func (s *peerSession) acceptData(p *Packet) (ackInfo, bool) {
+	seq, total, idx := p.Seq, p.Total, p.Index
+	flags := p.Flags &^ frameAck
+
+	s.rxMu.Lock()
+	defer s.rxMu.Unlock()
+
+	expiry := s.t.config().ReassemblyTimeout
+	now := time.Now()
+	s.expireReassembly(now, expiry)
+
+	key := completedKey{seq: seq, total: total}
+	if at, ok := s.completed[key]; ok && now.Sub(at) < expiry {
+		return ackInfo{cum: total}, true
+	}
+	if _, ok := s.rejected[key]; ok {
+		return ackInfo{missing: allFragments(total)}, true
+	}
+
+	msg, ok := s.reassembly[seq]
+	if !ok || msg.total != total || msg.ptype != p.Type || msg.flags != flags {
+		if ok {
+			s.abandon(seq, msg, "replaced by a new message")
+			msg.release()
+		}
+		msg = &rxMessage{ptype: p.Type, flags: flags, total: total, fragments: make([]*[]byte, total), createdAt: now}
+		s.reassembly[seq] = msg
+	}
+
+	if msg.fragments[idx] == nil {
+		frag := getPacketBuf()
+		*frag = append(*frag, p.Payload...)
+		msg.fragments[idx] = frag
+		msg.received++
+		s.reportProgress(seq, msg)
+	}
+
+	ack := msg.ackState()
+	if int(ack.cum) < int(msg.total) {
+		return ack, true
+	}
+
+	size := 0
+	for i := 0; i < int(msg.total); i++ {
+		size += len(*msg.fragments[i])
+	}
+	full := make([]byte, 0, size)
+	for i := 0; i < int(msg.total); i++ {
+		full = append(full, *msg.fragments[i]...)
+	}
+	msg.release()
+	delete(s.reassembly, seq)
+
+	// A message that fails its checksum is neither ACKed nor remembered:
+	// the peer is asked for every fragment again.
+	body := full
+	if msg.flags&frameChecksum != 0 {
+		if body, ok = verifyChecksum(full); !ok {
+			s.t.publishStatus(fmt.Sprintf("Dropped corrupted message (seq=%d), asking for it again", seq))
+			s.rejected[key] = now
+			return ackInfo{missing: allFragments(total)}, true
+		}
+	}
+
+	s.markCompleted(key, now)
+	s.deliver(msg.ptype, msg.flags, seq, body)
+	return ack, true
+}
+
+// allFragments lists the indices of a message of total fragments.
+func allFragments(total uint8) []uint8 {
+	all := make([]uint8, total)
+	for i := range all {
+		all[i] = uint8(i)
+	}
+	return all
+}
+
+// deliver hands a reassembled message to the key exchange or HELLO or,
+// decrypted and decompressed, to the chat. Handshakes are processed before
+// their ACK goes out, so the peer never sends ciphertext we have no key for.
+func (s *peerSession) deliver(ptype, flags, seq byte, body []byte) {
+	switch ptype {
+	case packetHandshake:
+		s.onHandshake(body)
+		return
+	case packetHello:
+		s.onHello(body)
+		return
+	}
+
+	msg, err := s.openIncoming(body, flags&frameEncrypted != 0)
+	if err == nil && flags&frameCompressed != 0 {
+		msg, err = inflate(msg)
+	}
+	if err == nil && len(msg) < msgHeaderSize {
+		err = errors.New("missing message header")
+	}
+	if err != nil {
+		s.t.publishStatus(fmt.Sprintf("Dropped message (seq=%d): %v", seq, err))
+		return
+	}
+
+	m := Message{
+		From:     s.id,
+		Identity: s.fingerprint(),
+		ID:       binary.LittleEndian.Uint32(msg[1:]),
+		Kind:     MessageKind(msg[0]),
+		Data:     msg[msgHeaderSize:],
+	}
+	s.dispatch(m, seq, true)
+}
+
+// dispatch hands a received message to whatever handles its kind. receipt
+// is whether a chat message may be answered with a read receipt, which only
+// makes sense for one that came straight from its sender.
+func (s *peerSession) dispatch(m Message, seq byte, receipt bool) {
+	switch m.Kind {
+	case KindRelay:
+		s.onRelay(m, seq)
+		return
+	case KindIdentity:
+		s.onIdentity(m.Data)
+		return
+	case KindStream:
+		s.onStream(m.Data)
+		return
+	case KindReceipt:
+		s.onReceipt(m.Data)
+		return
+	case KindFileChunk:
+		s.t.files.onChunk(s, m.Data)
+		return
+	case KindControl:
+		if s.onTypingControl(m.Data) || s.t.files.onControl(s, m.Data) {
+			return
+		}
+	case KindPresence:
+		// Presence relayed through the peer is about someone else, and is
+		// left to OnMessage.
+		if receipt {
+			s.onPresence(m.Data)
+			return
+		}
+	case KindChat:
+		// Chat from the peer itself, not relayed through it, ends its
+		// typing.
+		if receipt {
+			s.setPeerTyping(false)
+		}
+	}
+	if fn := s.t.onMessage.Load(); fn != nil {
+		(*fn)(m)
+	}
+	if m.Kind != KindChat || s.t.recvCh == nil {
+		return
+	}
+	if !offer(s.t.recvCh, string(m.Data), s.t.config().RecvTimeout) {
+		n := s.t.droppedMessages.Add(1)
+		s.t.publishStatus(fmt.Sprintf("Receive queue full: dropped message (seq=%d, %d dropped so far)", seq, n))
+		return
+	}
+	if receipt && s.t.readReceipts.Load() && s.peerSupports(featReadReceipts) {
+		go s.markRead(m.ID)
+	}
+}
+
+// OnMessage registers fn to receive every message, whatever its kind. Chat
+// messages are still delivered on the receive channel as well. fn runs on
+// the receive path before the message is acknowledged, so it must not block.
+func (t *Transport) OnMessage(fn func(msg Message)) {
+	if fn == nil {
+		t.onMessage.Store(nil)
+		return
+	}
+	t.onMessage.Store(&fn)
+}
+
+// expireReassembly abandons partly received messages older than expiry,
+// and forgets rejected ones. Callers hold rxMu.
+func (s *peerSession) expireReassembly(now time.Time, expiry time.Duration) {
+	for seq, msg := range s.reassembly {
+		if now.Sub(msg.createdAt) > expiry {
+			delete(s.reassembly, seq)
+			s.abandon(seq, msg, "timed out")
+			msg.release()
+		}
+	}
+	for key, at := range s.rejected {
+		if now.Sub(at) > expiry {
+			delete(s.rejected, key)
+		}
+	}
+}
+
+// markCompleted remembers a delivered message, forgetting the oldest once
+// recentCompletedMax are held. Callers hold rxMu.
+func (s *peerSession) markCompleted(key completedKey, at time.Time) {
+	if _, ok := s.completed[key]; !ok {
+		s.completedOrder = append(s.completedOrder, key)
+	}
+	s.completed[key] = at
+	if len(s.completedOrder) > recentCompletedMax {
+		delete(s.completed, s.completedOrder[0])
+		s.completedOrder = s.completedOrder[1:]
+	}
+}
+
+func (t *Transport) publishStatus(msg string) {
+	if fn := t.onStatus.Load(); fn != nil {
+		(*fn)(msg)
+		return
+	}
+	if t.statusCh != nil && !OfferStatus(t.statusCh, msg) {
+		t.droppedStatus.Add(1)
+	}
+}
+
+// OnStatus registers fn to take the transport's status lines instead of the
+// status channel, for owners that relay them with status of their own. fn
+// runs on whatever path reports the status, so it must not block for long.
+func (t *Transport) OnStatus(fn func(msg string)) {
+	if fn == nil {
+		t.onStatus.Store(nil)
+		return
+	}
+	t.onStatus.Store(&fn)
+}
+
+// DropStats reports how many messages and status lines were dropped because
+// their channel stayed full.
+func (t *Transport) DropStats() DropStats {
+	return DropStats{
+		Messages: t.droppedMessages.Load(),
+		Status:   t.droppedStatus.Load(),
+	}
+}
+
+// OfferStatus sends the status line msg on ch, waiting a short while for
+// room, and reports whether it was sent. Owners that forward the transport's
+// status lines on a channel of their own use it so a stalled UI drops lines
+// the same way everywhere.
+func OfferStatus(ch chan<- string, msg string) bool {
+	return offer(ch, msg, statusTimeout)
+}
+
+// offer sends msg on ch, waiting up to timeout for room. It reports whether
+// the message was sent.
+func offer(ch chan<- string, msg string, timeout time.Duration) bool {
+	select {
+	case ch <- msg:
+		return true
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case ch <- msg:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func appendChecksum(data []byte) []byte {
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+// verifyChecksum checks and strips the CRC-32 trailer of a reassembled
+// message.
+func verifyChecksum(full []byte) ([]byte, bool) {
+	if len(full) < checksumSize {
+		return nil, false
+	}
+	body := full[:len(full)-checksumSize]
+	want := binary.LittleEndian.Uint32(full[len(full)-checksumSize:])
+	return body, crc32.ChecksumIEEE(body) == want
+}
+
+func (m *rxMessage) ackState() ackInfo {
+	var ack ackInfo
+	for int(ack.cum) < int(m.total) && m.fragments[ack.cum] != nil {
+		ack.cum++
+	}
+	for bit := range 32 {
+		i := int(ack.cum) + 1 + bit
+		if i >= int(m.total) {
+			break
+		}
+		if m.fragments[i] != nil {
+			ack.bitmap |= 1 << bit
+		}
+	}
+	return ack
+}
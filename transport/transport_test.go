@@ -0,0 +1,512 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testMTU keeps fragments small, so messages of a few hundred bytes span
+// several windows.
+const testMTU = 40
+
+// testPair is two transports attached to each other over a lossyLink.
+type testPair struct {
+	a, b   *Transport
+	la, lb *lossyLink
+
+	mu       sync.Mutex
+	received [][]byte
+	arrived  chan struct{}
+}
+
+// newTestPair connects transports "a" and "b" with cfg. setup, if not nil,
+// may install filters on the links before they are attached.
+func newTestPair(t *testing.T, cfg TransportConfig, aToB, bToA faults, setup func(la, lb *lossyLink)) *testPair {
+	t.Helper()
+	p := &testPair{
+		a:       NewTransport(nil, nil, cfg),
+		b:       NewTransport(nil, nil, cfg),
+		arrived: make(chan struct{}, 1),
+	}
+	p.la, p.lb = newLossyPair(testMTU, 1, aToB, bToA)
+	if setup != nil {
+		setup(p.la, p.lb)
+	}
+	for _, tr := range []*Transport{p.a, p.b} {
+		tr.SetKeepalive(KeepaliveConfig{})
+		tr.OnStatus(func(string) {})
+	}
+	p.b.OnMessage(func(m Message) {
+		if m.Kind != KindChat {
+			return
+		}
+		p.mu.Lock()
+		p.received = append(p.received, bytes.Clone(m.Data))
+		p.mu.Unlock()
+		select {
+		case p.arrived <- struct{}{}:
+		default:
+		}
+	})
+	p.b.Attach("a", p.lb)
+	p.a.Attach("b", p.la)
+	t.Cleanup(func() {
+		p.a.Detach("b")
+		p.b.Detach("a")
+		p.la.Close()
+		p.lb.Close()
+	})
+	return p
+}
+
+// waitReceived waits until b has received n chat messages and returns them.
+func (p *testPair) waitReceived(t *testing.T, n int, timeout time.Duration) [][]byte {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		p.mu.Lock()
+		got := len(p.received)
+		p.mu.Unlock()
+		if got >= n {
+			break
+		}
+		select {
+		case <-p.arrived:
+		case <-deadline:
+			t.Fatalf("received %d of %d messages", got, n)
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.received...)
+}
+
+// testMessage returns a message of n bytes that differs for each i.
+func testMessage(i, n int) []byte {
+	msg := make([]byte, n)
+	for j := range msg {
+		msg[j] = byte(i*31 + j)
+	}
+	return msg
+}
+
+// fastConfig retransmits quickly and often enough to ride out heavy loss.
+func fastConfig(window int) TransportConfig {
+	return TransportConfig{
+		AckTimeout:      60 * time.Millisecond,
+		WriteRetryDelay: 10 * time.Millisecond,
+		MaxRetries:      12,
+		WindowSize:      window,
+		Pacing:          time.Millisecond,
+	}
+}
+
+func TestARQRecovers(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		faults faults
+	}{
+		{"clean", 8, faults{latency: time.Millisecond}},
+		{"stop and wait", 1, faults{drop: 0.1, latency: time.Millisecond}},
+		{"drops", 8, faults{drop: 0.15, latency: time.Millisecond}},
+		{"drops wide window", 32, faults{drop: 0.15, latency: time.Millisecond}},
+		{"duplicates", 8, faults{duplicate: 0.3, latency: time.Millisecond}},
+		{"reordering", 8, faults{reorder: 0.3, latency: time.Millisecond, reorderDelay: 15 * time.Millisecond}},
+		{"drops duplicates and reordering", 16, faults{drop: 0.1, duplicate: 0.1, reorder: 0.2, latency: 2 * time.Millisecond, reorderDelay: 10 * time.Millisecond}},
+		{"corruption", 8, faults{corrupt: 0.05, drop: 0.05, duplicate: 0.05, latency: time.Millisecond}},
+		{"corruption and reordering", 8, faults{corrupt: 0.05, reorder: 0.2, latency: time.Millisecond, reorderDelay: 10 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			p := newTestPair(t, fastConfig(tt.window), tt.faults, tt.faults, nil)
+
+			const count = 8
+			var sent [][]byte
+			var deliveries []*Delivery
+			for i := range count {
+				msg := testMessage(i, 40+i*45)
+				sent = append(sent, msg)
+				deliveries = append(deliveries, p.a.SendTo("b", KindChat, msg))
+			}
+			for i, d := range deliveries {
+				if err := d.Wait(); err != nil {
+					t.Fatalf("message %d: %v", i, err)
+				}
+			}
+
+			got := p.waitReceived(t, count, 10*time.Second)
+			// Let late duplicates arrive before checking none got through.
+			time.Sleep(50 * time.Millisecond)
+			p.mu.Lock()
+			got = p.received
+			p.mu.Unlock()
+			if len(got) != count {
+				t.Fatalf("received %d messages, want %d", len(got), count)
+			}
+			for i := range sent {
+				if !bytes.Equal(got[i], sent[i]) {
+					t.Errorf("message %d arrived as %x, want %x", i, got[i], sent[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWindowLimitsFragmentsInFlight(t *testing.T) {
+	tests := []struct {
+		window int
+		want   int
+	}{
+		{1, 1},
+		{2, 2},
+		{8, initialWindow},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("window %d", tt.window), func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			seen := make(map[uint8]bool)
+			// Nothing gets back to the sender, so the window never opens.
+			setup := func(la, lb *lossyLink) {
+				la.filter = func(p *Packet) bool {
+					if p.Type == packetData {
+						mu.Lock()
+						seen[p.Index] = true
+						mu.Unlock()
+					}
+					return false
+				}
+				lb.filter = func(p *Packet) bool { return true }
+			}
+			cfg := fastConfig(tt.window)
+			cfg.MaxRetries = 3
+			p := newTestPair(t, cfg, faults{}, faults{}, setup)
+
+			d := p.a.SendTo("b", KindChat, testMessage(0, 20*testMTU))
+			if err := d.Wait(); !errors.Is(err, ErrTimeout) {
+				t.Fatalf("delivery over a one-way link = %v, want %v", err, ErrTimeout)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(seen) != tt.want {
+				t.Errorf("sent %d distinct fragments without an ACK, want %d", len(seen), tt.want)
+			}
+		})
+	}
+}
+
+func TestRetransmitsAfterTimeout(t *testing.T) {
+	// The last fragment leaves no gap for a NACK to report, so only its ACK
+	// timeout brings it back.
+	var drops atomic.Int32
+	setup := func(la, lb *lossyLink) {
+		la.filter = func(p *Packet) bool {
+			return p.Type == packetData && p.Index == p.Total-1 && drops.Add(1) <= 2
+		}
+	}
+	p := newTestPair(t, fastConfig(8), faults{}, faults{}, setup)
+
+	msg := testMessage(1, 200)
+	if err := p.a.SendTo("b", KindChat, msg).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	got := p.waitReceived(t, 1, 5*time.Second)
+	if !bytes.Equal(got[0], msg) {
+		t.Errorf("received %x, want %x", got[0], msg)
+	}
+	if n := drops.Load(); n < 3 {
+		t.Errorf("last fragment sent %d times, want 3", n)
+	}
+}
+
+func TestNackRetransmitsBeforeTimeout(t *testing.T) {
+	var dropped atomic.Bool
+	setup := func(la, lb *lossyLink) {
+		la.filter = func(p *Packet) bool {
+			return p.Type == packetData && p.Index == 1 && p.Total > 10 && dropped.CompareAndSwap(false, true)
+		}
+	}
+	// The ACK timeout is far longer than the test allows, so only a NACK
+	// can bring the lost fragment back in time. The latency makes the round
+	// trip outlast the guard against resending a fragment twice in a row.
+	cfg := fastConfig(32)
+	cfg.AckTimeout = 30 * time.Second
+	link := faults{latency: 25 * time.Millisecond}
+	p := newTestPair(t, cfg, link, link, setup)
+
+	msg := testMessage(2, 40*testMTU)
+	d := p.a.SendTo("b", KindChat, msg)
+	select {
+	case <-d.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("lost fragment was not resent before its ACK timeout")
+	}
+	if err := d.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !dropped.Load() {
+		t.Fatal("no fragment was dropped")
+	}
+	got := p.waitReceived(t, 1, time.Second)
+	if !bytes.Equal(got[0], msg) {
+		t.Errorf("received %x, want %x", got[0], msg)
+	}
+}
+
+func TestCorruptedMessageIsResent(t *testing.T) {
+	var corrupted atomic.Bool
+	var mu sync.Mutex
+	var statuses []string
+	setup := func(la, lb *lossyLink) {
+		la.filter = func(p *Packet) bool {
+			if p.Type == packetData && p.Index == 2 && corrupted.CompareAndSwap(false, true) {
+				// Drop the fragment and send a corrupted copy in its place.
+				bad := p.Append(nil)
+				bad[headerSize] ^= 0x40
+				go la.Write(bad)
+				return true
+			}
+			return false
+		}
+	}
+	p := newTestPair(t, fastConfig(8), faults{}, faults{}, setup)
+	p.b.OnStatus(func(msg string) {
+		mu.Lock()
+		statuses = append(statuses, msg)
+		mu.Unlock()
+	})
+
+	msg := testMessage(3, 6*testMTU)
+	if err := p.a.SendTo("b", KindChat, msg).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	got := p.waitReceived(t, 1, 5*time.Second)
+	time.Sleep(50 * time.Millisecond)
+	p.mu.Lock()
+	got = p.received
+	p.mu.Unlock()
+	if len(got) != 1 || !bytes.Equal(got[0], msg) {
+		t.Fatalf("received %x, want just %x", got, msg)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var reported bool
+	for _, s := range statuses {
+		reported = reported || bytes.Contains([]byte(s), []byte("corrupted"))
+	}
+	if !reported {
+		t.Errorf("corruption not reported; status lines: %q", statuses)
+	}
+}
+
+// unbatch writes the packets a batch carries back to l one by one, so a
+// filter sees each of them, and reports whether p was a batch.
+func unbatch(l *lossyLink, p *Packet) bool {
+	if p.Type != packetBatch {
+		return false
+	}
+	for _, packet := range p.Batch {
+		l.Write(packet)
+	}
+	return true
+}
+
+func TestStaleAckAfterCorruption(t *testing.T) {
+	// The message body, its header and checksum fill eight fragments.
+	const total = 8
+	var (
+		mu        sync.Mutex
+		held      []byte // fragment 1, sent on after the last fragment
+		released  bool   // whether held went out
+		corrupted bool   // whether fragment 2 went out corrupted
+		stale     []byte // the last partial ACK b sent for the message
+		replayed  bool   // whether stale followed the NACK
+		lost      bool   // whether the resent last fragment was lost
+	)
+	// Writes made from a filter run with mu unlocked, as they come back
+	// through it.
+	writeAll := func(l *lossyLink, packets ...[]byte) {
+		mu.Unlock()
+		defer mu.Lock()
+		for _, packet := range packets {
+			l.Write(packet)
+		}
+	}
+	setup := func(la, lb *lossyLink) {
+		// Fragment 1 arrives last, however often it is sent, so b's partial
+		// ACKs cover the last fragment, and fragment 2 arrives corrupted. Once the message is asked
+		// for again, the first resend of the last fragment is lost.
+		la.filter = func(p *Packet) bool {
+			if unbatch(la, p) {
+				return true
+			}
+			if p.Type != packetData || p.Total != total {
+				return false
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case p.Index == 1 && !released:
+				if held == nil {
+					held = p.Append(nil)
+				}
+				return true
+			case p.Index == total-1 && held != nil:
+				last, first := p.Append(nil), held
+				held, released = nil, true
+				writeAll(la, last, first)
+				return true
+			case p.Index == 2 && !corrupted:
+				corrupted = true
+				bad := p.Append(nil)
+				bad[headerSize] ^= 0x40
+				writeAll(la, bad)
+				return true
+			case p.Index == total-1 && replayed && !lost:
+				lost = true
+				return true
+			}
+			return false
+		}
+		// Copy the partial ACKs b sends for the message, and deliver the
+		// last again right behind the NACK for the corrupted message, as a
+		// link that reorders packets may. NACKs for the gap fragment 1
+		// leaves are lost, so it is not resent early.
+		lb.filter = func(p *Packet) bool {
+			if unbatch(lb, p) {
+				return true
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case replayed:
+			case p.Type == packetNack && p.Total == total && len(p.Missing) < total:
+				return true
+			case p.Type == packetAck && p.Total == total && p.Index < total:
+				stale = p.Append(nil)
+			case p.Type == packetNack && p.Total == total && len(p.Missing) == total:
+				replayed = true
+				writeAll(lb, p.Append(nil), stale)
+				return true
+			}
+			return false
+		}
+	}
+	// Pacing past the ACK delay has b send an ACK for every fragment.
+	cfg := fastConfig(total)
+	cfg.Pacing = 2 * ackDelay
+	p := newTestPair(t, cfg, faults{}, faults{}, setup)
+	// Open the window wide enough for the whole message at once.
+	cc := p.a.route("b").cc
+	cc.mu.Lock()
+	cc.cwnd = total
+	cc.mu.Unlock()
+
+	msg := testMessage(4, 6*testMTU)
+	if err := p.a.SendTo("b", KindChat, msg).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if !corrupted || !replayed || stale == nil || !lost {
+		t.Errorf("corrupted %v, replayed %v, stale ACK %x, lost %v; want all", corrupted, replayed, stale, lost)
+	}
+	mu.Unlock()
+
+	// b hands a message over before acknowledging its last fragment, so a
+	// delivery that succeeded has already arrived.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.received) != 1 || !bytes.Equal(p.received[0], msg) {
+		t.Errorf("send succeeded with %d messages received, want just the one sent", len(p.received))
+	}
+}
+
+func TestAckState(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		received []int
+		want     ackInfo
+		gaps     []uint8
+	}{
+		{"nothing", 4, nil, ackInfo{}, nil},
+		{"in order", 4, []int{0, 1}, ackInfo{cum: 2}, nil},
+		{"complete", 3, []int{0, 1, 2}, ackInfo{cum: 3}, nil},
+		{"first missing", 4, []int{1, 3}, ackInfo{cum: 0, bitmap: 0b101}, []uint8{0, 2}},
+		{"gap after prefix", 6, []int{0, 1, 3, 5}, ackInfo{cum: 2, bitmap: 0b101}, []uint8{2, 4}},
+		{"bitmap edge", 40, []int{0, 33}, ackInfo{cum: 1, bitmap: 1 << 31}, func() []uint8 {
+			var gaps []uint8
+			for i := uint8(1); i < 33; i++ {
+				gaps = append(gaps, i)
+			}
+			return gaps
+		}()},
+		{"past the bitmap", 40, []int{0, 34}, ackInfo{cum: 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &rxMessage{total: uint8(tt.total), fragments: make([]*[]byte, tt.total)}
+			for _, i := range tt.received {
+				m.fragments[i] = &[]byte{byte(i)}
+			}
+			got := m.ackState()
+			if got.cum != tt.want.cum || got.bitmap != tt.want.bitmap {
+				t.Fatalf("ackState() = cum %d bitmap %b, want cum %d bitmap %b", got.cum, got.bitmap, tt.want.cum, tt.want.bitmap)
+			}
+			if gaps := got.gaps(); !bytes.Equal(gaps, tt.gaps) {
+				t.Errorf("gaps() = %v, want %v", gaps, tt.gaps)
+			}
+		})
+	}
+}
+
+func TestApplyAck(t *testing.T) {
+	tests := []struct {
+		name  string
+		sent  int
+		acked []int
+		ack   ackInfo
+		want  int
+		after []int
+	}{
+		{"cumulative", 4, nil, ackInfo{cum: 3}, 3, []int{0, 1, 2}},
+		{"selective", 6, nil, ackInfo{cum: 1, bitmap: 0b1010}, 3, []int{0, 3, 5}},
+		{"already acked", 4, []int{0, 1}, ackInfo{cum: 3}, 1, []int{0, 1, 2}},
+		{"not yet sent", 2, nil, ackInfo{cum: 4}, 2, []int{0, 1}},
+		{"nack only", 4, nil, ackInfo{missing: []uint8{1}}, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frags := make([]txFragment, 6)
+			for i := range tt.sent {
+				frags[i].sent = true
+				frags[i].tries = 1
+				frags[i].sentAt = time.Now()
+			}
+			for _, i := range tt.acked {
+				frags[i].acked = true
+			}
+			n, _ := applyAck(frags, tt.ack)
+			if n != tt.want {
+				t.Errorf("applyAck() newly acked %d, want %d", n, tt.want)
+			}
+			var after []int
+			for i, f := range frags {
+				if f.acked {
+					after = append(after, i)
+				}
+			}
+			if fmt.Sprint(after) != fmt.Sprint(tt.after) {
+				t.Errorf("acked fragments = %v, want %v", after, tt.after)
+			}
+		})
+	}
+}
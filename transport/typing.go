@@ -0,0 +1,95 @@
+package transport
+
+import "time"
+
+// ctlTyping is the control message saying whether the user is composing a
+// message: the op, then 1 for typing or 0 for stopped.
+const ctlTyping byte = 0x10
+
+const (
+	// typingRefresh is how often a user who keeps typing is announced
+	// again, and typingTimeout how long after the last announcement the
+	// peer stops showing the indicator, in case "stopped" never arrives.
+	typingRefresh = 3 * time.Second
+	typingTimeout = 6 * time.Second
+)
+
+// SetTyping tells the active peer whether the local user is composing a
+// message. UIs call it on every keystroke and when the input is cleared;
+// it only sends when the state changes or needs refreshing. Sending a chat
+// message ends the typing state on both sides.
+func (t *Transport) SetTyping(typing bool) {
+	s := t.route("")
+	if s == nil || !s.peerSupports(featTyping) {
+		return
+	}
+	last := s.typingSent.Load()
+	state := byte(0)
+	if typing {
+		now := time.Now()
+		if last != 0 && now.Sub(time.Unix(0, last)) < typingRefresh {
+			return
+		}
+		if !s.typingSent.CompareAndSwap(last, now.UnixNano()) {
+			return
+		}
+		state = 1
+	} else if last == 0 || !s.typingSent.CompareAndSwap(last, 0) {
+		return
+	}
+	t.SendTTL(s.id, KindControl, []byte{ctlTyping, state}, typingTimeout)
+}
+
+// OnTyping registers fn to be told when peer id starts or stops typing.
+// fn runs on the receive path, so it must not block.
+func (t *Transport) OnTyping(fn func(id string, typing bool)) {
+	if fn == nil {
+		t.onTyping.Store(nil)
+		return
+	}
+	t.onTyping.Store(&fn)
+}
+
+// onTypingControl handles a typing control message, reporting whether data
+// was one.
+func (s *peerSession) onTypingControl(data []byte) bool {
+	if len(data) != 2 || data[0] != ctlTyping {
+		return false
+	}
+	s.setPeerTyping(data[1] == 1)
+	return true
+}
+
+// setPeerTyping records whether the peer is typing, reporting changes. A
+// typing peer that goes quiet for typingTimeout is taken to have stopped.
+func (s *peerSession) setPeerTyping(typing bool) {
+	s.typingMu.Lock()
+	was := s.typingTimer != nil
+	if was {
+		s.typingTimer.Stop()
+		s.typingTimer = nil
+	}
+	if typing {
+		var timer *time.Timer
+		timer = time.AfterFunc(typingTimeout, func() { s.expireTyping(timer) })
+		s.typingTimer = timer
+	}
+	s.typingMu.Unlock()
+
+	if typing != was {
+		if fn := s.t.onTyping.Load(); fn != nil {
+			(*fn)(s.id, typing)
+		}
+	}
+}
+
+// expireTyping ends the peer's typing state if timer is still the one
+// running for it.
+func (s *peerSession) expireTyping(timer *time.Timer) {
+	s.typingMu.Lock()
+	current := s.typingTimer == timer
+	s.typingMu.Unlock()
+	if current {
+		s.setPeerTyping(false)
+	}
+}
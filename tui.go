@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/uniseg"
+
+	"bluetalk/peer"
+)
+
+const (
+	// scrollbackLines bounds the chat lines the TUI keeps.
+	scrollbackLines = 1000
+
+	inputPrompt = "You: "
+)
+
+var (
+	styleSystem = tcell.StyleDefault.Foreground(tcell.ColorGray)
+	stylePeer   = tcell.StyleDefault.Bold(true)
+	styleBar    = tcell.StyleDefault.Reverse(true)
+)
+
+// tuiLine is one entry of the scrollback.
+type tuiLine struct {
+	text  string
+	style tcell.Style
+}
+
+// tui is the full-screen frontend: the scrollback on top, a status bar fed
+// by the peer's events, and the input line at the bottom. Output and input
+// no longer share a line, so a message arriving mid-typing leaves what was
+// typed alone.
+type tui struct {
+	screen tcell.Screen
+
+	mu     sync.Mutex
+	lines  []tuiLine
+	scroll int // rows scrolled back from the bottom
+	edit   []rune
+	cursor int
+	link   linkState
+}
+
+// linkState is what a status bar shows: the connected peer, how the link is
+// doing and its last signal reading.
+type linkState struct {
+	Peer   string `json:"peer"`
+	State  string `json:"state"`
+	RSSI   int16  `json:"rssi"`
+	Typing bool   `json:"typing"`
+}
+
+// apply updates s from a peer event, reporting whether anything shown
+// changed.
+func (s *linkState) apply(ev peer.PeerEvent) bool {
+	switch ev := ev.(type) {
+	case peer.ScanStarted:
+		if s.Peer == "" {
+			s.State = "scanning"
+		}
+	case peer.Connected:
+		s.Peer, s.State = ev.Peer, "connected"
+		if !ev.Central {
+			s.State += " (peripheral)"
+		}
+	case peer.Disconnected:
+		s.Peer, s.State, s.RSSI, s.Typing = "", "disconnected", 0, false
+	case peer.SignalStrength:
+		s.RSSI = ev.RSSI
+	case peer.WeakLink:
+		s.RSSI = ev.RSSI
+	case peer.Typing:
+		s.Typing = ev.Active
+	case peer.PowerChanged:
+		s.State = "bluetooth off"
+		if ev.On {
+			s.State = "bluetooth on"
+		}
+	case peer.PresenceChanged:
+		if ev.Presence.Online && ev.Presence.Address == s.Peer && ev.Presence.Nickname != "" {
+			s.Peer = ev.Presence.Nickname
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func newTUI(p *peer.Peer) (*tui, error) {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return nil, err
+	}
+	if err := screen.Init(); err != nil {
+		return nil, err
+	}
+	t := &tui{screen: screen, link: linkState{State: "starting"}}
+
+	events, _ := p.Subscribe(64)
+	go func() {
+		for ev := range events {
+			t.event(ev)
+		}
+	}()
+	t.draw()
+	return t, nil
+}
+
+// event updates the status bar from a peer event.
+func (t *tui) event(ev peer.PeerEvent) {
+	t.mu.Lock()
+	changed := t.link.apply(ev)
+	t.mu.Unlock()
+	if changed {
+		t.draw()
+	}
+}
+
+func (t *tui) message(name, text string) {
+	t.add(tuiLine{text: fmt.Sprintf("[%s]: %s", name, text), style: stylePeer})
+}
+
+func (t *tui) status(text string) {
+	t.add(tuiLine{text: "[System]: " + text, style: styleSystem})
+}
+
+func (t *tui) add(line tuiLine) {
+	t.mu.Lock()
+	t.lines = append(t.lines, line)
+	if n := len(t.lines) - scrollbackLines; n > 0 {
+		t.lines = t.lines[n:]
+	}
+	t.mu.Unlock()
+	t.draw()
+}
+
+// input reads keys until the user quits with Ctrl-C or Ctrl-D or the screen
+// is closed, handing each line entered to handle.
+func (t *tui) input(handle func(text string)) {
+	for {
+		switch ev := t.screen.PollEvent().(type) {
+		case nil:
+			return
+		case *tcell.EventResize:
+			t.screen.Sync()
+		case *tcell.EventKey:
+			if ev.Key() == tcell.KeyCtrlC || ev.Key() == tcell.KeyCtrlD {
+				return
+			}
+			if text, ok := t.key(ev); ok {
+				if strings.TrimSpace(text) != "" {
+					t.add(tuiLine{text: "[You]: " + text, style: tcell.StyleDefault})
+				}
+				handle(text)
+			}
+		}
+		t.draw()
+	}
+}
+
+// key applies an editing key to the input line, returning the line when
+// Enter completes it.
+func (t *tui) key(ev *tcell.EventKey) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch ev.Key() {
+	case tcell.KeyEnter:
+		text := string(t.edit)
+		t.edit, t.cursor, t.scroll = t.edit[:0], 0, 0
+		return text, true
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if t.cursor > 0 {
+			t.edit = append(t.edit[:t.cursor-1], t.edit[t.cursor:]...)
+			t.cursor--
+		}
+	case tcell.KeyDelete:
+		if t.cursor < len(t.edit) {
+			t.edit = append(t.edit[:t.cursor], t.edit[t.cursor+1:]...)
+		}
+	case tcell.KeyLeft:
+		t.cursor = max(t.cursor-1, 0)
+	case tcell.KeyRight:
+		t.cursor = min(t.cursor+1, len(t.edit))
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		t.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		t.cursor = len(t.edit)
+	case tcell.KeyCtrlU:
+		t.edit, t.cursor = t.edit[:0], 0
+	case tcell.KeyPgUp:
+		_, h := t.screen.Size()
+		t.scroll += max(h-3, 1)
+	case tcell.KeyPgDn:
+		_, h := t.screen.Size()
+		t.scroll = max(t.scroll-max(h-3, 1), 0)
+	case tcell.KeyRune:
+		t.edit = append(t.edit[:t.cursor], append([]rune{ev.Rune()}, t.edit[t.cursor:]...)...)
+		t.cursor++
+	}
+	return "", false
+}
+
+// draw repaints the whole screen.
+func (t *tui) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.screen
+	s.Clear()
+	w, h := s.Size()
+	if w <= 0 || h < 3 {
+		s.Show()
+		return
+	}
+
+	// Scrollback, wrapped to the width and filled from the bottom up.
+	var rows []tuiLine
+	for _, line := range t.lines {
+		for _, row := range wrap(line.text, w) {
+			rows = append(rows, tuiLine{text: row, style: line.style})
+		}
+	}
+	pane := h - 2
+	t.scroll = min(t.scroll, max(len(rows)-pane, 0))
+	end := len(rows) - t.scroll
+	for i, row := range rows[max(end-pane, 0):end] {
+		s.PutStrStyled(0, pane-min(end, pane)+i, row.text, row.style)
+	}
+
+	// Status bar.
+	bar := " BlueTalk | " + t.link.State
+	if t.link.Peer != "" {
+		bar += " | " + t.link.Peer
+	}
+	if t.link.RSSI != 0 {
+		bar += fmt.Sprintf(" | %d dBm", t.link.RSSI)
+	}
+	if t.link.Typing {
+		bar += " | typing..."
+	}
+	if t.scroll > 0 {
+		bar += fmt.Sprintf(" | scrolled back %d", t.scroll)
+	}
+	bar += strings.Repeat(" ", max(w-uniseg.StringWidth(bar), 0))
+	s.PutStrStyled(0, h-2, bar, styleBar)
+
+	// Input line, scrolled sideways to keep the cursor in view.
+	avail := w - uniseg.StringWidth(inputPrompt) - 1
+	start := 0
+	for start < t.cursor && uniseg.StringWidth(string(t.edit[start:t.cursor])) > avail {
+		start++
+	}
+	s.PutStr(0, h-1, inputPrompt+string(t.edit[start:]))
+	s.ShowCursor(uniseg.StringWidth(inputPrompt+string(t.edit[start:t.cursor])), h-1)
+	s.Show()
+}
+
+func (t *tui) close() {
+	t.screen.Fini()
+}
+
+// wrap breaks text into rows of at most width cells.
+func wrap(text string, width int) []string {
+	var rows []string
+	var row strings.Builder
+	used := 0
+	g := uniseg.NewGraphemes(text)
+	for g.Next() {
+		if cw := g.Width(); used+cw > width && used > 0 {
+			rows = append(rows, row.String())
+			row.Reset()
+			used = 0
+		}
+		row.WriteString(g.Str())
+		used += g.Width()
+	}
+	return append(rows, row.String())
+}
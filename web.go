@@ -0,0 +1,223 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"bluetalk/peer"
+)
+
+//go:embed web/index.html
+var webPage []byte
+
+// webEvent is one update for the browser, sent as JSON over the WebSocket.
+type webEvent struct {
+	// Kind is "message" for a line from the peer, "sent" for one we typed,
+	// "status" for a system line and "link" for the status bar.
+	Kind string     `json:"kind"`
+	Name string     `json:"name,omitempty"`
+	Text string     `json:"text,omitempty"`
+	Link *linkState `json:"link,omitempty"`
+}
+
+// webUI is the browser frontend: it serves the chat page at / and streams
+// the chat to it over a WebSocket at /ws, which also takes the lines typed
+// in the page. Any number of tabs may be open; each starts with the
+// scrollback so far.
+type webUI struct {
+	addr   net.Addr
+	server *http.Server
+	typed  chan string
+	done   chan struct{}
+	once   sync.Once
+
+	// host is the host the frontend was asked to listen on, which pages
+	// may reach it by besides localhost, and exposed whether other
+	// machines can reach it there.
+	host    string
+	exposed bool
+
+	mu      sync.Mutex
+	clients map[chan webEvent]struct{}
+	lines   []webEvent
+	link    linkState
+}
+
+// newWebUI starts serving the web frontend on addr, which must be a loopback
+// address unless expose is set.
+func newWebUI(p *peer.Peer, addr string, expose bool) (*webUI, error) {
+	exposed, err := checkExposed(addr, expose, "-expose-web")
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	w := &webUI{
+		addr:    ln.Addr(),
+		host:    host,
+		exposed: exposed,
+		typed:   make(chan string),
+		done:    make(chan struct{}),
+		clients: make(map[chan webEvent]struct{}),
+		link:    linkState{State: "starting"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write(webPage)
+	})
+	mux.Handle("GET /ws", websocket.Server{Handshake: w.handshake, Handler: w.serve})
+	w.server = &http.Server{Handler: mux}
+	go w.server.Serve(ln)
+
+	events, _ := p.Subscribe(64)
+	go func() {
+		for ev := range events {
+			w.mu.Lock()
+			if w.link.apply(ev) {
+				link := w.link
+				w.broadcast(webEvent{Kind: "link", Link: &link})
+			}
+			w.mu.Unlock()
+		}
+	}()
+	return w, nil
+}
+
+// handshake refuses WebSockets opened by pages from other sites, which
+// could otherwise read the chat and send as the user. Comparing the Origin
+// with the Host is not enough on its own: a site that rebinds its DNS name
+// to this machine has both name itself, so the Host must also be localhost,
+// a loopback IP or the host the frontend was asked to listen on. Clients
+// that are not browsers send no Origin and are let through, which is why
+// newWebUI only listens where other machines can connect when asked to.
+func (w *webUI) handshake(cfg *websocket.Config, r *http.Request) error {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = strings.Trim(r.Host, "[]")
+	}
+	if !loopbackHost(host) && (w.host == "" || !strings.EqualFold(host, w.host)) {
+		return errors.New("WebSocket for another host refused")
+	}
+	origin, err := websocket.Origin(cfg, r)
+	if err != nil {
+		return err
+	}
+	if origin != nil && origin.Host != r.Host {
+		return errors.New("cross-origin WebSocket refused")
+	}
+	return nil
+}
+
+// serve runs one browser connection: the scrollback and status bar first,
+// then every update, while the lines it sends go to input.
+func (w *webUI) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	out := make(chan webEvent, 64)
+	w.mu.Lock()
+	link := w.link
+	backlog := append(slices.Clone(w.lines), webEvent{Kind: "link", Link: &link})
+	w.clients[out] = struct{}{}
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.clients, out)
+		w.mu.Unlock()
+	}()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			var text string
+			if err := websocket.Message.Receive(ws, &text); err != nil {
+				return
+			}
+			select {
+			case w.typed <- text:
+			case <-w.done:
+				return
+			}
+		}
+	}()
+
+	for _, ev := range backlog {
+		if websocket.JSON.Send(ws, ev) != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case ev := <-out:
+			if websocket.JSON.Send(ws, ev) != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// send records ev in the scrollback and hands it to every open page.
+func (w *webUI) send(ev webEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lines = append(w.lines, ev)
+	if n := len(w.lines) - scrollbackLines; n > 0 {
+		w.lines = w.lines[n:]
+	}
+	w.broadcast(ev)
+}
+
+// broadcast hands ev to every open page; a page too slow to keep up misses
+// it. w.mu must be held.
+func (w *webUI) broadcast(ev webEvent) {
+	for out := range w.clients {
+		select {
+		case out <- ev:
+		default:
+		}
+	}
+}
+
+// input hands each line typed in any page to handle until close.
+func (w *webUI) input(handle func(text string)) {
+	for {
+		select {
+		case text := <-w.typed:
+			if strings.TrimSpace(text) != "" {
+				w.send(webEvent{Kind: "sent", Text: text})
+			}
+			handle(text)
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *webUI) message(name, text string) {
+	w.send(webEvent{Kind: "message", Name: name, Text: text})
+}
+
+func (w *webUI) status(text string) {
+	w.send(webEvent{Kind: "status", Text: text})
+}
+
+func (w *webUI) close() {
+	w.once.Do(func() {
+		close(w.done)
+		w.server.Close()
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWebHandshake(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen string // host the frontend was asked to listen on
+		host   string
+		origin string
+		ok     bool
+	}{
+		{"localhost", "localhost", "localhost:8080", "http://localhost:8080", true},
+		{"loopback IP", "", "127.0.0.1:8080", "http://127.0.0.1:8080", true},
+		{"IPv6 loopback", "", "[::1]:8080", "http://[::1]:8080", true},
+		{"listen host", "192.168.1.5", "192.168.1.5:8080", "http://192.168.1.5:8080", true},
+		{"no origin", "localhost", "localhost:8080", "", true},
+		{"cross origin", "localhost", "localhost:8080", "http://evil.example", false},
+		{"rebound name", "localhost", "evil.example:8080", "http://evil.example:8080", false},
+		{"other address", "", "192.168.1.5:8080", "http://192.168.1.5:8080", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &webUI{host: tt.listen}
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			err := w.handshake(&websocket.Config{Version: websocket.ProtocolVersionHybi13}, r)
+			if (err == nil) != tt.ok {
+				t.Errorf("handshake = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewWebUIRefusesExposedAddr(t *testing.T) {
+	for _, addr := range []string{":0", "0.0.0.0:0", "[::]:0", "192.0.2.1:0"} {
+		if w, err := newWebUI(nil, addr, false); err == nil {
+			w.close()
+			t.Errorf("newWebUI(%q) listened without -expose-web", addr)
+		}
+	}
+}
+
+func TestCheckExposed(t *testing.T) {
+	tests := []struct {
+		addr    string
+		allow   bool
+		exposed bool
+		ok      bool
+	}{
+		{"localhost:7447", false, false, true},
+		{"127.0.0.1:7447", false, false, true},
+		{"[::1]:7447", false, false, true},
+		{":7447", false, true, false},
+		{"0.0.0.0:7447", false, true, false},
+		{"0.0.0.0:7447", true, true, true},
+		{"7447", false, false, false},
+	}
+	for _, tt := range tests {
+		exposed, err := checkExposed(tt.addr, tt.allow, "-expose")
+		if exposed != tt.exposed || (err == nil) != tt.ok {
+			t.Errorf("checkExposed(%q, %v) = %v, %v; want %v, ok %v", tt.addr, tt.allow, exposed, err, tt.exposed, tt.ok)
+		}
+	}
+}